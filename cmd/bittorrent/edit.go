@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
+)
+
+// runEdit rewrites the metadata of an existing torrent. Only the flags given
+// on the command line change anything; passing an empty value removes a key.
+func runEdit(args []string) {
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default: overwrite the input)")
+	var trackers, webSeeds stringList
+	fs.Var(&trackers, "t", "tracker announce URL, replacing all trackers; repeat for backups, use commas for several trackers in one tier")
+	noTrackers := fs.Bool("no-trackers", false, "remove all trackers")
+	fs.Var(&webSeeds, "w", "web seed URL, replacing all web seeds; may be repeated")
+	noWebSeeds := fs.Bool("no-web-seeds", false, "remove all web seeds")
+	comment := fs.String("comment", "", "free-form comment")
+	createdBy := fs.String("created-by", "", "creator string")
+	private := fs.String("private", "", "set (true) or clear (false) the private flag; CHANGES THE INFOHASH")
+	source := fs.String("source", "", "source tag in the info dictionary; CHANGES THE INFOHASH")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: bittorrent edit [flags] <torrent-file>")
+	}
+
+	var e torrentfile.Edit
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["t"] || *noTrackers {
+		tiers := [][]string{}
+		for _, tier := range trackers {
+			tiers = append(tiers, strings.Split(tier, ","))
+		}
+		e.Trackers = &tiers
+	}
+	if set["w"] || *noWebSeeds {
+		seeds := []string(webSeeds)
+		e.WebSeeds = &seeds
+	}
+	if set["comment"] {
+		e.Comment = comment
+	}
+	if set["created-by"] {
+		e.CreatedBy = createdBy
+	}
+	if set["private"] {
+		p, err := strconv.ParseBool(*private)
+		if err != nil {
+			log.Fatalf("invalid -private value %q (want true or false)", *private)
+		}
+		e.Private = &p
+	}
+	if set["source"] {
+		e.Source = source
+	}
+
+	in := fs.Arg(0)
+	data, err := torrentfile.ReadFile(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	edited, err := torrentfile.EditTorrent(data, e)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	before, err := torrentfile.Parse(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	after, err := torrentfile.Parse(edited)
+	if err != nil {
+		log.Fatal(err)
+	}
+	oldHash, _ := before.InfoHash()
+	newHash, _ := after.InfoHash()
+	if oldHash != newHash {
+		fmt.Fprintf(os.Stderr, "WARNING: the info dictionary changed, so this is now a different torrent.\n")
+		fmt.Fprintf(os.Stderr, "         Infohash %x -> %x; peers of the old swarm won't see it.\n", oldHash, newHash)
+	}
+
+	path := *out
+	if path == "" {
+		path = in
+	}
+	// Write next to the target and rename, so a failure never leaves half a torrent behind.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".edit-*.torrent")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = tmp.Write(edited)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %s (infohash %x)\n", path, newHash)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
+)
+
+// runInspect prints any bencoded file (torrent, resume file, saved tracker
+// response) as a tree or as JSON, plus a summary when it's a torrent.
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the decoded structure as JSON")
+	preview := fs.Int("preview", 16, "bytes of binary strings to show as hex before eliding")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: bittorrent inspect [-json] [-preview n] <file>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, torrentfile.MaxTorrentSize))
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := inspect(os.Stdout, data, *asJSON, *preview); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// inspect writes the bencoded data to w as runInspect describes.
+func inspect(w io.Writer, data []byte, asJSON bool, preview int) error {
+	var v any
+	if err := bencode.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	v = elide(v, preview)
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	printTree(w, v, 0)
+	if err := bencode.Valid(data); err != nil {
+		fmt.Fprintf(w, "\nNot canonical: %v\n", err)
+	}
+	if dict, ok := v.(map[string]any); ok {
+		if _, ok := dict["info"].(map[string]any); ok {
+			printSummary(w, data)
+		}
+	}
+	return nil
+}
+
+// hexPreview stands in for an elided binary string. It prints unquoted in the
+// tree and as a plain string in JSON.
+type hexPreview string
+
+// elide replaces binary or very long strings by a short hex preview, so that
+// "pieces" doesn't flood the terminal. Dictionary keys get the same
+// treatment: those of v2 "piece layers" are binary merkle roots.
+func elide(v any, preview int) any {
+	switch v := v.(type) {
+	case string:
+		if printable(v) {
+			return v
+		}
+		return hexPreview(hexString(v, preview))
+	case []any:
+		for i := range v {
+			v[i] = elide(v[i], preview)
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, child := range v {
+			key := k
+			if !printable(k) {
+				key = hexString(k, preview)
+				if _, taken := out[key]; taken {
+					// Two keys with the same preview: show this one in full.
+					key = hexString(k, len(k))
+				}
+			}
+			out[key] = elide(child, preview)
+		}
+		return out
+	}
+	return v
+}
+
+// printable reports whether s can be shown as it is: valid UTF-8 of a
+// reasonable length without control characters other than tab and newline.
+func printable(s string) bool {
+	return utf8.ValidString(s) && len(s) <= 256 && !strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 && r != '\t' && r != '\n' })
+}
+
+// hexString describes s by its length and up to preview of its bytes in hex.
+func hexString(s string, preview int) string {
+	n := min(len(s), preview)
+	h := fmt.Sprintf("<%d bytes> %s", len(s), hex.EncodeToString([]byte(s[:n])))
+	if n < len(s) {
+		h += "..."
+	}
+	return h
+}
+
+func printTree(w io.Writer, v any, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch child := v[k].(type) {
+			case map[string]any:
+				fmt.Fprintf(w, "%s%s: (dict, %d keys)\n", indent, k, len(child))
+				printTree(w, child, depth+1)
+			case []any:
+				fmt.Fprintf(w, "%s%s: (list, %d items)\n", indent, k, len(child))
+				printTree(w, child, depth+1)
+			default:
+				fmt.Fprintf(w, "%s%s: %s\n", indent, k, scalar(child))
+			}
+		}
+	case []any:
+		for i, item := range v {
+			switch child := item.(type) {
+			case map[string]any:
+				fmt.Fprintf(w, "%s[%d]: (dict, %d keys)\n", indent, i, len(child))
+				printTree(w, child, depth+1)
+			case []any:
+				fmt.Fprintf(w, "%s[%d]: (list, %d items)\n", indent, i, len(child))
+				printTree(w, child, depth+1)
+			default:
+				fmt.Fprintf(w, "%s[%d]: %s\n", indent, i, scalar(child))
+			}
+		}
+	default:
+		fmt.Fprintf(w, "%s%s\n", indent, scalar(v))
+	}
+}
+
+func scalar(v any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
+
+// printSummary describes a metainfo file the way a user thinks about it.
+func printSummary(w io.Writer, data []byte) {
+	bto, err := torrentfile.Parse(data)
+	if err != nil {
+		fmt.Fprintf(w, "\nNot a valid torrent: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "\n=== Torrent ===\n")
+	fmt.Fprintf(w, "Name:         %s\n", bto.Info.Name)
+	if h, err := bto.InfoHash(); err == nil && bto.IsV1() {
+		fmt.Fprintf(w, "Infohash v1:  %x\n", h)
+	}
+	if h, ok := bto.InfoHashV2(); ok {
+		fmt.Fprintf(w, "Infohash v2:  %x\n", h)
+	}
+	fmt.Fprintf(w, "Private:      %v\n", bto.Info.Private == 1)
+	fmt.Fprintf(w, "Version:      %s\n", bto.Version())
+	if err := bto.CheckHybrid(); err != nil {
+		fmt.Fprintf(w, "Inconsistent: %v\n", err)
+	}
+	fmt.Fprintf(w, "Pieces:       %d x %d bytes\n", bto.NumPieces(), bto.Info.PieceLength)
+	fmt.Fprintf(w, "Total size:   %d bytes\n", bto.TotalLength())
+	if bto.Comment != "" {
+		fmt.Fprintf(w, "Comment:      %s\n", bto.Comment)
+	}
+	if bto.CreatedBy != "" {
+		fmt.Fprintf(w, "Created by:   %s\n", bto.CreatedBy)
+	}
+	if bto.CreationDate != 0 {
+		fmt.Fprintf(w, "Created:      %s\n", time.Unix(bto.CreationDate, 0).UTC().Format(time.RFC3339))
+	}
+	if bto.Info.Source != "" {
+		fmt.Fprintf(w, "Source:       %s\n", bto.Info.Source)
+	}
+
+	fmt.Fprintf(w, "Trackers:\n")
+	for _, tr := range bto.Trackers() {
+		fmt.Fprintf(w, "  %s\n", tr)
+	}
+	if len(bto.URLList) > 0 {
+		fmt.Fprintf(w, "Web seeds:\n")
+		for _, ws := range bto.URLList {
+			fmt.Fprintf(w, "  %s\n", ws)
+		}
+	}
+	files := bto.FileList()
+	fmt.Fprintf(w, "Files (%d):\n", len(files))
+	for i, f := range files {
+		if f.Padding {
+			fmt.Fprintf(w, "  %3d %12d  (padding)\n", i, f.Length)
+			continue
+		}
+		fmt.Fprintf(w, "  %3d %12d  %s\n", i, f.Length, f.Path)
+	}
+}
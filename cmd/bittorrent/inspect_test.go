@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TestInspect")
+
+// TestInspect compares the output of inspect on the bencode fixtures with
+// the golden files in testdata. Run with -update after a deliberate change.
+func TestInspect(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		json    bool
+		golden  string
+	}{
+		{"debian-13.3.0-amd64-netinst.iso.torrent", false, "v1.golden"},
+		{"v2.torrent", false, "v2.golden"},
+		{"hybrid.torrent", false, "hybrid.golden"},
+		{"v2.torrent", true, "v2.json.golden"},
+	} {
+		t.Run(tt.golden, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("..", "..", "pkg", "bencode", "testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := inspect(&out, data, tt.json, 16); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("output differs from %s:\n%s", golden, out.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
+)
+
+// runMagnet prints the magnet link of a .torrent file. Given a magnet link
+// instead, it prints what the link contains.
+func runMagnet(args []string) {
+	fs := flag.NewFlagSet("magnet", flag.ExitOnError)
+	noTrackers := fs.Bool("no-trackers", false, "leave the trackers out of the link")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: bittorrent magnet [-no-trackers] <torrent-file | magnet-uri>")
+	}
+
+	if strings.HasPrefix(fs.Arg(0), "magnet:") {
+		m, err := torrentfile.ParseMagnet(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if m.InfoHash != [20]byte{} {
+			fmt.Printf("Infohash v1:  %x\n", m.InfoHash)
+		}
+		if m.InfoHashV2 != [32]byte{} {
+			fmt.Printf("Infohash v2:  %x\n", m.InfoHashV2)
+		}
+		if m.Name != "" {
+			fmt.Printf("Name:         %s\n", m.Name)
+		}
+		if m.Length > 0 {
+			fmt.Printf("Total size:   %d bytes\n", m.Length)
+		}
+		for _, tr := range m.Trackers {
+			fmt.Printf("Tracker:      %s\n", tr)
+		}
+		for _, ws := range m.WebSeeds {
+			fmt.Printf("Web seed:     %s\n", ws)
+		}
+		return
+	}
+
+	bto, err := torrentfile.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := bto.Magnet()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *noTrackers {
+		m.Trackers = nil
+	}
+	fmt.Println(m)
+}
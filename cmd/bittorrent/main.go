@@ -1,55 +1,216 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 
+	"github.com/jyotishmoy12/bittorrent-go/pkg/mse"
 	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/stream"
 	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
 	"github.com/jyotishmoy12/bittorrent-go/pkg/tracker"
 )
 
+const usage = `Usage:
+  bittorrent [download] [flags] <torrent-file>      download a torrent
+  bittorrent create [flags] <path>                  create a .torrent from a file or directory
+  bittorrent edit [flags] <torrent-file>            change trackers, web seeds and other metadata
+  bittorrent inspect [flags] <file>                 show the structure of any bencoded file
+  bittorrent magnet [flags] <torrent-file>          print the magnet link of a torrent, or decode one
+  bittorrent verify [flags] <torrent-file> <path>   check files on disk against a torrent
+  bittorrent verify-meta [flags] <torrent-file>     check a .torrent file for malformed metadata
+
+Run "bittorrent <command> -h" for the flags of each command.
+`
+
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run cmd/bittorrent/main.go <torrent-file>")
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "download":
+		runDownload(os.Args[2:])
+	case "create":
+		runCreate(os.Args[2:])
+	case "edit":
+		runEdit(os.Args[2:])
+	case "inspect":
+		runInspect(os.Args[2:])
+	case "magnet":
+		runMagnet(os.Args[2:])
+	case "verify":
+		runVerify(os.Args[2:])
+	case "verify-meta":
+		runVerifyMeta(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		// A bare torrent path keeps working as before.
+		runDownload(os.Args[1:])
+	}
+}
+
+func runDownload(args []string) {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	alloc := fs.String("alloc", "sparse", "disk allocation mode: sparse or full")
+	sequential := fs.Bool("sequential", false, "download pieces in order, for playing while downloading")
+	httpAddr := fs.String("http", "", "serve the torrent's files over HTTP on this address (e.g. :8080) while downloading")
+	incompleteDir := fs.String("incomplete-dir", "", "directory for files still downloading (default: current directory)")
+	completeDir := fs.String("complete-dir", "", "directory to move files to once the download completes")
+	partSuffix := fs.Bool("part-suffix", false, "name files <name>.part until they are complete")
+	prio := fs.String("prio", "", "file priorities as index=skip|low|normal|high, comma separated")
+	useUTP := fs.Bool("utp", true, "connect to peers over uTP as well as TCP and keep whichever transport connects first (-utp=false for TCP only)")
+	encryption := fs.String("encryption", "prefer", "peer connection encryption (MSE/PE): disabled, prefer or require")
+	maxPeers := fs.Int("max-peers", torrentfile.DefaultMaxPeers, "number of peer connections to keep up")
+	maxHalfOpen := fs.Int("max-half-open", torrentfile.DefaultMaxHalfOpen, "number of peer connections to dial at once")
+	banThreshold := fs.Int("ban-threshold", torrentfile.DefaultBanThreshold, "corrupt pieces after which a peer's address is banned")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		log.Fatal("Usage: bittorrent download [flags] <torrent-file>")
 	}
-	torrentPath := os.Args[1]
+	torrentPath := fs.Arg(0)
 
-	// 1. Open and parse the .torrent file
-	bto, err := torrentfile.Open(torrentPath)
+	allocation, err := storage.ParseAllocationMode(*alloc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy, err := mse.ParsePolicy(*encryption)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// 2. Prepare the Torrent metadata
-	infoHash, _ := bto.InfoHash()
-	hashes, _ := bto.SplitPieceHashes()
-	peerID, _ := tracker.GeneratePeerID()
+	// 1. Open, check and parse the .torrent file
+	data, err := torrentfile.ReadFile(torrentPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	problems := torrentfile.Validate(data)
+	for _, p := range problems {
+		log.Printf("%s: %s", torrentPath, p)
+	}
+	if torrentfile.HasErrors(problems) {
+		log.Fatal("refusing to download a malformed torrent")
+	}
+	bto, err := torrentfile.Parse(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files := bto.FileList()
+	priorities, err := torrentfile.ParseFilePriorities(*prio, len(files))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// 3. Get Peers from Tracker
-	trackerURL, _ := tracker.BuildTrackerURL(bto.Announce, infoHash, peerID, 6881, bto.Info.Length)
-	peersBin, err := tracker.GetPeers(trackerURL)
+	// 2. Prepare the Torrent metadata
+	to, err := bto.Torrent()
+	if err != nil {
+		log.Fatal(err)
+	}
+	infoHashes, err := bto.InfoHashes()
 	if err != nil {
 		log.Fatal(err)
 	}
-	peers, _ := peer.Unmarshal(peersBin)
+	peerID, _ := tracker.GeneratePeerID()
+
+	// 3. Get Peers from the trackers, once per swarm: a hybrid torrent is
+	// shared under both its v1 and its v2 infohash.
+	swarmPeers := make([][]peer.Peer, len(infoHashes))
+	found := 0
+	for i, infoHash := range infoHashes {
+		peers, err := announce(bto.Tiers(), infoHash, peerID, bto.TotalLength())
+		if err != nil {
+			if len(infoHashes) == 1 {
+				log.Fatal(err)
+			}
+			log.Printf("Announce for %x failed: %v", infoHash, err)
+			continue
+		}
+		swarmPeers[i] = peers
+		found += len(peers)
+	}
+	if found == 0 && len(infoHashes) > 1 {
+		log.Fatal("no swarm returned any peers")
+	}
 
-	// 4. Create the Torrent orchestrator
-	to := &torrentfile.Torrent{
-		Peers:       peers,
-		PeerId:      peerID,
-		InfoHash:    infoHash,
-		PieceHashes: hashes,
-		PieceLength: bto.Info.PieceLength,
-		Length:      bto.Info.Length,
-		Name:        bto.Info.Name,
+	// 4. Fill in the download options
+	to.PeerId = peerID
+	to.FilePriorities = priorities
+	to.Allocation = allocation
+	to.Encryption = policy
+	to.UTP = *useUTP
+	to.MaxPeers = *maxPeers
+	to.MaxHalfOpen = *maxHalfOpen
+	to.BanThreshold = *banThreshold
+	to.Sequential = *sequential
+	to.IncompleteDir = *incompleteDir
+	to.CompleteDir = *completeDir
+	to.PartSuffix = *partSuffix
+	if len(infoHashes) > 1 {
+		to.InfoHashV2 = infoHashes[1]
+	}
+	// Hand over the trackers' peers, which even private torrents (BEP 27)
+	// may use. Any other source has to come through AddPeers too, so that a
+	// private torrent refuses it.
+	for i, infoHash := range infoHashes {
+		if err := to.AddPeers(torrentfile.SourceTracker, infoHash, swarmPeers[i]); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 5. Optionally stream the files while they download
+	serveErr := make(chan error, 1)
+	if *httpAddr != "" {
+		go func() { serveErr <- stream.ListenAndServe(*httpAddr, to) }()
 	}
 
 	err = to.Download()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer to.Close()
+
+	fmt.Printf("\nDone! %s has been saved to %s.\n", bto.Info.Name, to.Dir())
+
+	if *httpAddr != "" {
+		// Keep serving the finished files until the server stops or we're interrupted.
+		log.Fatal(<-serveErr)
+	}
+}
 
-	fmt.Printf("\nDone! %s has been saved to your current directory.\n", bto.Info.Name)
+// announce asks the trackers for the peers of the swarm of infoHash, tier
+// by tier as BEP 12 says: the trackers of a tier are tried in order, and the
+// next tier only once all of them failed. The first answer wins.
+func announce(tiers [][]string, infoHash, peerID [20]byte, left int) ([]peer.Peer, error) {
+	var errs []error
+	for _, tier := range tiers {
+		for _, u := range tier {
+			// Private trackers put a per-user passkey in the URL; never log it.
+			redacted := tracker.RedactURL(u)
+			log.Printf("Announcing %x to %s", infoHash, redacted)
+			trackerURL, err := tracker.BuildTrackerURL(u, infoHash, peerID, 6881, left)
+			if err != nil {
+				// The parse error quotes the URL, so leave it out.
+				errs = append(errs, fmt.Errorf("%s: invalid announce URL", redacted))
+				continue
+			}
+			peersBin, err := tracker.GetPeers(trackerURL)
+			if err == nil {
+				var peers []peer.Peer
+				if peers, err = peer.Unmarshal(peersBin); err == nil {
+					return peers, nil
+				}
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", redacted, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("the torrent has no trackers")
+	}
+	return nil, errors.Join(errs...)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAnnounceTiers checks that announce falls back through the trackers of
+// a tier and then to the next tier, and stops at the first that answers.
+func TestAnnounceTiers(t *testing.T) {
+	var hits []string
+	tracker := func(peers string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, r.URL.Path)
+			if r.URL.Query().Get("passkey") != "0123456789abcdef0123" {
+				http.Error(w, "lost passkey", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("d8:intervali1800e5:peers" + peers + "e"))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	good := tracker("6:\x7f\x00\x00\x01\x1a\xe1")
+	broken := tracker("5:short")
+	refused := httptest.NewServer(nil)
+	refused.Close()
+
+	const passkey = "?passkey=0123456789abcdef0123"
+	tiers := [][]string{
+		{refused.URL + "/a" + passkey, "http://[::1" + passkey, broken.URL + "/b" + passkey},
+		{good.URL + "/c" + passkey, good.URL + "/d" + passkey},
+	}
+	peers, err := announce(tiers, [20]byte{1}, [20]byte{2}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].String() != "127.0.0.1:6881" {
+		t.Errorf("peers %v, want 127.0.0.1:6881", peers)
+	}
+	if strings.Join(hits, " ") != "/b /c" {
+		t.Errorf("trackers asked: %v, want /b then /c", hits)
+	}
+
+	_, err = announce(tiers[:1], [20]byte{1}, [20]byte{2}, 100)
+	if err == nil {
+		t.Fatal("announce succeeded with every tracker failing")
+	}
+	if strings.Contains(err.Error(), "0123456789abcdef0123") || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("error should name the three trackers without their passkey: %v", err)
+	}
+	if _, err := announce(nil, [20]byte{1}, [20]byte{2}, 100); err == nil {
+		t.Error("announce succeeded without trackers")
+	}
+}
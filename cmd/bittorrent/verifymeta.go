@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
+)
+
+// runVerifyMeta lints a .torrent file and exits with status 1 when it has
+// errors. Warnings are printed but don't fail the check.
+func runVerifyMeta(args []string) {
+	fs := flag.NewFlagSet("verify-meta", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		log.Fatal("Usage: bittorrent verify-meta [-strict] <torrent-file>...")
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		data, err := torrentfile.ReadFile(path)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", path, err)
+			failed = true
+			continue
+		}
+		problems := torrentfile.Validate(data)
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", path, p)
+		}
+		if torrentfile.HasErrors(problems) || (*strict && len(problems) > 0) {
+			failed = true
+		} else if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -1,5 +1,3 @@
 module github.com/jyotishmoy12/bittorrent-go
 
 go 1.25.2
-
-require github.com/jackpal/bencode-go v1.0.2 // indirect
@@ -0,0 +1,325 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// RawMessage is a raw encoded value. Decoding into a RawMessage keeps the
+// exact input bytes, which is how the info dictionary is hashed without
+// re-encoding it; encoding a RawMessage writes it out verbatim.
+type RawMessage []byte
+
+// Unmarshaler is implemented by types that decode themselves from the raw
+// bytes of one value.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+// UnmarshalTypeError describes a value that can't be stored in a Go type.
+type UnmarshalTypeError struct {
+	Value  string // "integer", "string", "list" or "dictionary"
+	Type   reflect.Type
+	Offset int64
+	Field  string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("bencode: cannot unmarshal %s into field %s of type %s (offset %d)", e.Value, e.Field, e.Type, e.Offset)
+	}
+	return fmt.Sprintf("bencode: cannot unmarshal %s into Go value of type %s (offset %d)", e.Value, e.Type, e.Offset)
+}
+
+// Unmarshal decodes data into v, which must be a non-nil pointer. Decoding
+// into an interface produces int64, string, []any and map[string]any.
+func Unmarshal(data []byte, v any) error {
+	d := NewDecoder(bytes.NewReader(data))
+	return d.decodeAll(v)
+}
+
+// UnmarshalStrict is Unmarshal with Decoder.Strict set: the input must be
+// canonical and contain nothing after the value.
+func UnmarshalStrict(data []byte, v any) error {
+	d := NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	return d.decodeAll(v)
+}
+
+// Valid reports whether data is exactly one canonically encoded value.
+func Valid(data []byte) error {
+	d := NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	if err := d.Skip(); err != nil {
+		return err
+	}
+	if d.off != int64(len(data)) {
+		return d.errorf(d.off, "trailing data after value")
+	}
+	return nil
+}
+
+func (d *Decoder) decodeAll(v any) error {
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if d.Strict {
+		if _, err := d.peekByte(); err == nil {
+			return d.errorf(d.off, "trailing data after value")
+		}
+	}
+	return nil
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(RawMessage(nil))
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// Decode reads the next value from the stream and stores it in v.
+func (d *Decoder) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("bencode: Decode needs a non-nil pointer, got %T", v)
+	}
+	err := d.value(rv.Elem(), "")
+	if err == io.EOF && d.off > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// value decodes the next value into v.
+func (d *Decoder) value(v reflect.Value, field string) error {
+	if v.Type() == rawMessageType || reflect.PointerTo(v.Type()).Implements(unmarshalerType) {
+		raw, err := d.record()
+		if err != nil {
+			return err
+		}
+		if v.Type() == rawMessageType {
+			v.SetBytes(raw)
+			return nil
+		}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalBencode(raw)
+	}
+
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	return d.valueFrom(tok, v, field)
+}
+
+// record decodes one value while keeping a copy of its raw bytes.
+func (d *Decoder) record() ([]byte, error) {
+	if d.recording == 0 {
+		d.rec = d.rec[:0]
+	}
+	start := len(d.rec)
+	d.recording++
+	err := d.Skip()
+	d.recording--
+	if err != nil {
+		return nil, err
+	}
+	raw := append([]byte(nil), d.rec[start:]...)
+	if d.recording == 0 {
+		d.rec = d.rec[:0]
+	}
+	return raw, nil
+}
+
+// valueFrom decodes a value whose first token has already been read.
+func (d *Decoder) valueFrom(tok Token, v reflect.Value, field string) error {
+	if tok.Kind == End {
+		return d.errorf(tok.Offset, "unexpected end marker")
+	}
+
+	// Allocate through pointers.
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		val, err := d.anyFrom(tok)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	mismatch := func() error {
+		err := &UnmarshalTypeError{Value: tok.Kind.String(), Type: v.Type(), Offset: tok.Offset, Field: field}
+		// Keep the stream usable so callers can decide to carry on.
+		if skipErr := d.skipRest(tok); skipErr != nil {
+			return skipErr
+		}
+		return err
+	}
+
+	switch tok.Kind {
+	case IntToken:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(tok.Int) {
+				return d.errorf(tok.Offset, "integer %d overflows %s", tok.Int, v.Type())
+			}
+			v.SetInt(tok.Int)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if tok.Int < 0 || v.OverflowUint(uint64(tok.Int)) {
+				return d.errorf(tok.Offset, "integer %d overflows %s", tok.Int, v.Type())
+			}
+			v.SetUint(uint64(tok.Int))
+		case reflect.Bool:
+			v.SetBool(tok.Int != 0)
+		default:
+			return mismatch()
+		}
+
+	case StringToken:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(tok.Bytes))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(tok.Bytes)
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if len(tok.Bytes) != v.Len() {
+				return d.errorf(tok.Offset, "string of %d bytes for %s", len(tok.Bytes), v.Type())
+			}
+			reflect.Copy(v, reflect.ValueOf(tok.Bytes))
+		default:
+			return mismatch()
+		}
+
+	case ListStart:
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			for i := 0; d.More(); i++ {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+				if err := d.value(v.Index(i), field); err != nil {
+					return err
+				}
+			}
+		case reflect.Array:
+			i := 0
+			for ; d.More(); i++ {
+				if i >= v.Len() {
+					return d.errorf(tok.Offset, "list longer than %s", v.Type())
+				}
+				if err := d.value(v.Index(i), field); err != nil {
+					return err
+				}
+			}
+		default:
+			return mismatch()
+		}
+		return d.end()
+
+	case DictStart:
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return mismatch()
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			for d.More() {
+				key, err := d.Token()
+				if err != nil {
+					return err
+				}
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := d.value(elem, string(key.Bytes)); err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(string(key.Bytes)).Convert(v.Type().Key()), elem)
+			}
+		case reflect.Struct:
+			fields := cachedFields(v.Type())
+			for d.More() {
+				key, err := d.Token()
+				if err != nil {
+					return err
+				}
+				f, ok := fields.byKey[string(key.Bytes)]
+				if !ok {
+					if err := d.Skip(); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := d.value(v.FieldByIndex(f.index), f.key); err != nil {
+					return err
+				}
+			}
+		default:
+			return mismatch()
+		}
+		return d.end()
+	}
+	return nil
+}
+
+// end consumes the End token closing the current container.
+func (d *Decoder) end() error {
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if tok.Kind != End {
+		return d.errorf(tok.Offset, "expected end marker")
+	}
+	return nil
+}
+
+// anyFrom builds the generic representation of a value.
+func (d *Decoder) anyFrom(tok Token) (any, error) {
+	switch tok.Kind {
+	case IntToken:
+		return tok.Int, nil
+	case StringToken:
+		return string(tok.Bytes), nil
+	case ListStart:
+		list := []any{}
+		for d.More() {
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.anyFrom(t)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, d.end()
+	case DictStart:
+		dict := map[string]any{}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.anyFrom(t)
+			if err != nil {
+				return nil, err
+			}
+			dict[string(key.Bytes)] = v
+		}
+		return dict, d.end()
+	}
+	return nil, errors.New("bencode: unexpected end marker")
+}
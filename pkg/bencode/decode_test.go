@@ -0,0 +1,152 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fixtures are real torrents: one made by mktorrent, and a v2 and a hybrid
+// one made by an independent encoder. next is the key following "info" in
+// each, which is where the info dictionary ends.
+var fixtures = []struct {
+	file string
+	next string
+}{
+	{"debian-13.3.0-amd64-netinst.iso.torrent", "8:url-list"},
+	{"v2.torrent", "12:piece layers"},
+	{"hybrid.torrent", "12:piece layers"},
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStrictRejects(t *testing.T) {
+	tests := []struct {
+		name, in string
+	}{
+		{"negative zero", "i-0e"},
+		{"leading zero", "i03e"},
+		{"negative leading zero", "i-03e"},
+		{"string length leading zero", "03:abc"},
+		{"unsorted keys", "d1:b0:1:a0:e"},
+		{"duplicate keys", "d1:a0:1:a0:e"},
+		{"unsorted nested keys", "d1:ad1:zi1e1:yi2eee"},
+		{"trailing value", "i1ei2e"},
+		{"trailing byte", "0:x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := UnmarshalStrict([]byte(tt.in), &v); err == nil {
+				t.Errorf("UnmarshalStrict(%q) accepted it as %v", tt.in, v)
+			}
+			if err := Valid([]byte(tt.in)); err == nil {
+				t.Errorf("Valid(%q) = nil", tt.in)
+			}
+			// The lenient decoder takes what other clients produce.
+			if err := Unmarshal([]byte(tt.in), &v); err != nil {
+				t.Errorf("Unmarshal(%q): %v", tt.in, err)
+			}
+		})
+	}
+}
+
+func TestStrictAccepts(t *testing.T) {
+	for _, in := range []string{"i0e", "i-1e", "i10e", "0:", "10:0123456789", "le", "de", "d1:a0:1:b0:e", "d0:i1e1:ai2ee", "d1:ad1:yi1e1:zi2eee"} {
+		var v any
+		if err := UnmarshalStrict([]byte(in), &v); err != nil {
+			t.Errorf("UnmarshalStrict(%q): %v", in, err)
+		}
+		if err := Valid([]byte(in)); err != nil {
+			t.Errorf("Valid(%q): %v", in, err)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	for _, in := range []string{"", "i1", "ie", "i1x2e", "5:abc", "l", "d1:ae", "di1ei2ee", "e", "x"} {
+		var v any
+		if err := Unmarshal([]byte(in), &v); err == nil {
+			t.Errorf("Unmarshal(%q) accepted it as %v", in, v)
+		}
+	}
+	var se *SyntaxError
+	if err := Valid([]byte("i03e")); !errors.As(err, &se) || se.Offset != 0 {
+		t.Errorf("Valid(i03e) = %v, want a SyntaxError at offset 0", err)
+	}
+}
+
+// TestRawMessageInfo checks that decoding into a RawMessage keeps the exact
+// bytes of the info dictionary, which the infohash is computed over.
+func TestRawMessageInfo(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.file, func(t *testing.T) {
+			data := readFixture(t, f.file)
+			start := bytes.Index(data, []byte("4:infod")) + len("4:info")
+			end := bytes.LastIndex(data, []byte(f.next))
+			var torrent struct {
+				Announce string     `bencode:"announce"`
+				Info     RawMessage `bencode:"info"`
+			}
+			if err := UnmarshalStrict(data, &torrent); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(torrent.Info, data[start:end]) {
+				t.Fatalf("info is %d bytes, want the %d at %d-%d", len(torrent.Info), end-start, start, end)
+			}
+			if sha1.Sum(torrent.Info) != sha1.Sum(data[start:end]) {
+				t.Error("infohash differs")
+			}
+			if torrent.Announce == "" {
+				t.Error("announce was lost around the raw info")
+			}
+		})
+	}
+}
+
+// TestRoundTrip decodes real torrents and encodes them again. Canonical
+// input must come out byte for byte the same, whether it went through
+// generic values or RawMessages.
+func TestRoundTrip(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.file, func(t *testing.T) {
+			data := readFixture(t, f.file)
+			if err := Valid(data); err != nil {
+				t.Fatal(err)
+			}
+
+			var v any
+			if err := UnmarshalStrict(data, &v); err != nil {
+				t.Fatal(err)
+			}
+			out, err := Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Error("encode(decode(x)) != x through generic values")
+			}
+
+			var raw map[string]RawMessage
+			if err := UnmarshalStrict(data, &raw); err != nil {
+				t.Fatal(err)
+			}
+			if out, err = Marshal(raw); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Error("encode(decode(x)) != x through RawMessages")
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package bencode
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// structField is one encodable field of a struct, found through its tag:
+//
+//	Name string `bencode:"name"`
+//	Path []string `bencode:"path,omitempty"`
+//	skip int `bencode:"-"`
+//
+// Untagged exported fields use the Go field name as the key.
+type structField struct {
+	key       string
+	index     []int
+	omitEmpty bool
+}
+
+type structFields struct {
+	list  []structField // sorted by key, the order Marshal writes them in
+	byKey map[string]structField
+	dup   string // first key claimed by two fields, reported by Marshal
+}
+
+var fieldCache sync.Map // reflect.Type -> *structFields
+
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+func typeFields(t reflect.Type) *structFields {
+	fs := &structFields{byKey: map[string]structField{}}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		f := structField{key: name, index: sf.Index, omitEmpty: opts == "omitempty"}
+		if _, ok := fs.byKey[name]; ok && fs.dup == "" {
+			fs.dup = name
+		}
+		fs.byKey[name] = f
+		fs.list = append(fs.list, f)
+	}
+	sort.SliceStable(fs.list, func(i, j int) bool { return fs.list[i].key < fs.list[j].key })
+	return fs
+}
@@ -0,0 +1,373 @@
+// Package bencode implements the encoding used by .torrent files, tracker
+// responses and the extension protocol.
+//
+// A bencoded value is one of four things:
+//
+//	i42e          integer
+//	4:spam        byte string, prefixed with its length
+//	l...e         list
+//	d...e         dictionary, keys are byte strings in sorted order
+//
+// The Decoder is a streaming tokenizer with optional strict validation and
+// size/depth limits for input from untrusted peers and trackers. Marshal
+// always produces the canonical encoding, which is what infohashes are
+// computed over.
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Kind identifies the type of a Token.
+type Kind uint8
+
+const (
+	IntToken Kind = iota + 1
+	StringToken
+	ListStart
+	DictStart
+	End // closes the innermost list or dictionary
+)
+
+func (k Kind) String() string {
+	switch k {
+	case IntToken:
+		return "integer"
+	case StringToken:
+		return "string"
+	case ListStart:
+		return "list"
+	case DictStart:
+		return "dictionary"
+	case End:
+		return "end"
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// Token is one element of the bencode stream.
+type Token struct {
+	Kind   Kind
+	Int    int64  // set for IntToken
+	Bytes  []byte // set for StringToken
+	Offset int64  // position of the token's first byte in the input
+}
+
+// DefaultMaxDepth is the nesting limit used when Decoder.MaxDepth is zero.
+// Real torrents nest a handful of levels; v2 file trees a few more.
+const DefaultMaxDepth = 64
+
+// SyntaxError reports malformed or, in strict mode, non-canonical input.
+type SyntaxError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+// ErrLimit is wrapped by errors caused by Decoder.MaxSize or Decoder.MaxDepth.
+var ErrLimit = errors.New("bencode: input exceeds decoder limits")
+
+// frame is one open container on the decoder's stack.
+type frame struct {
+	dict     bool
+	wantKey  bool   // dictionaries alternate key, value, key, value...
+	lastKey  []byte // for the sorted-keys check in strict mode
+	firstKey bool
+}
+
+// Decoder reads bencoded values from a stream.
+type Decoder struct {
+	// Strict rejects input that isn't in canonical form: integers and string
+	// lengths with leading zeros, "-0", and dictionary keys that are unsorted
+	// or repeated. It also rejects trailing data after Unmarshal's value.
+	Strict bool
+	// MaxSize caps the number of bytes read. Zero means no limit.
+	MaxSize int64
+	// MaxDepth caps list/dictionary nesting. Zero means DefaultMaxDepth.
+	MaxDepth int
+
+	r     *bufio.Reader
+	off   int64
+	stack []frame
+
+	// rec collects the raw bytes consumed while recording > 0. Used for
+	// RawMessage and Unmarshaler, which want the exact input span.
+	rec       []byte
+	recording int
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// InputOffset returns the number of bytes consumed so far.
+func (d *Decoder) InputOffset() int64 {
+	return d.off
+}
+
+func (d *Decoder) errorf(off int64, format string, args ...any) error {
+	return &SyntaxError{Offset: off, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.MaxSize > 0 && d.off >= d.MaxSize {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrLimit, d.MaxSize)
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF && len(d.stack) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	d.off++
+	if d.recording > 0 {
+		d.rec = append(d.rec, c)
+	}
+	return c, nil
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readString reads n bytes without trusting n for the allocation: a hostile
+// "4294967295:" prefix only costs us what actually arrives.
+func (d *Decoder) readString(n int64, at int64) ([]byte, error) {
+	if d.MaxSize > 0 && d.off+n > d.MaxSize {
+		return nil, fmt.Errorf("%w: %d byte string at offset %d", ErrLimit, n, at)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(min(n, 64<<10)))
+	got, err := io.CopyN(&buf, d.r, n)
+	d.off += got
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if d.recording > 0 {
+		d.rec = append(d.rec, buf.Bytes()...)
+	}
+	return buf.Bytes(), nil
+}
+
+// readNumber parses the digits of an integer or string length up to delim.
+func (d *Decoder) readNumber(first byte, delim byte, at int64) (int64, error) {
+	var digits []byte
+	c := first
+	for {
+		if c == delim {
+			break
+		}
+		if !(c >= '0' && c <= '9') && !(c == '-' && len(digits) == 0) {
+			return 0, d.errorf(d.off-1, "invalid character %q in number", c)
+		}
+		digits = append(digits, c)
+		if len(digits) > 20 {
+			return 0, d.errorf(at, "number too long")
+		}
+		var err error
+		if c, err = d.readByte(); err != nil {
+			return 0, err
+		}
+	}
+
+	neg := len(digits) > 0 && digits[0] == '-'
+	mag := digits
+	if neg {
+		mag = digits[1:]
+	}
+	if len(mag) == 0 {
+		return 0, d.errorf(at, "empty number")
+	}
+	if d.Strict {
+		if len(mag) > 1 && mag[0] == '0' {
+			return 0, d.errorf(at, "leading zero in %q", digits)
+		}
+		if neg && mag[0] == '0' {
+			return 0, d.errorf(at, "negative zero")
+		}
+	}
+
+	var v int64
+	for _, c := range mag {
+		if v > (1<<63-1-int64(c-'0'))/10 {
+			return 0, d.errorf(at, "integer %q overflows int64", digits)
+		}
+		v = v*10 + int64(c-'0')
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+// Token reads the next token. At the end of the input it returns io.EOF,
+// or io.ErrUnexpectedEOF if a list or dictionary is still open.
+func (d *Decoder) Token() (Token, error) {
+	at := d.off
+	c, err := d.readByte()
+	if err != nil {
+		return Token{}, err
+	}
+
+	var top *frame
+	if len(d.stack) > 0 {
+		top = &d.stack[len(d.stack)-1]
+	}
+	if top != nil && top.dict && top.wantKey && c != 'e' && !(c >= '0' && c <= '9') {
+		return Token{}, d.errorf(at, "dictionary key must be a string")
+	}
+
+	var tok Token
+	switch {
+	case c == 'e':
+		if top == nil {
+			return Token{}, d.errorf(at, "unexpected end marker")
+		}
+		if top.dict && !top.wantKey {
+			return Token{}, d.errorf(at, "dictionary key without a value")
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+		tok = Token{Kind: End, Offset: at}
+		// The closed container counts as one value for its parent.
+		d.afterValue(nil)
+		return tok, nil
+
+	case c == 'i':
+		first, err := d.readByte()
+		if err != nil {
+			return Token{}, err
+		}
+		v, err := d.readNumber(first, 'e', at)
+		if err != nil {
+			return Token{}, err
+		}
+		tok = Token{Kind: IntToken, Int: v, Offset: at}
+
+	case c >= '0' && c <= '9':
+		n, err := d.readNumber(c, ':', at)
+		if err != nil {
+			return Token{}, err
+		}
+		if n < 0 {
+			return Token{}, d.errorf(at, "negative string length")
+		}
+		b, err := d.readString(n, at)
+		if err != nil {
+			return Token{}, err
+		}
+		tok = Token{Kind: StringToken, Bytes: b, Offset: at}
+
+	case c == 'l' || c == 'd':
+		max := d.MaxDepth
+		if max <= 0 {
+			max = DefaultMaxDepth
+		}
+		if len(d.stack) >= max {
+			return Token{}, fmt.Errorf("%w: nesting deeper than %d at offset %d", ErrLimit, max, at)
+		}
+		if c == 'l' {
+			tok = Token{Kind: ListStart, Offset: at}
+		} else {
+			tok = Token{Kind: DictStart, Offset: at}
+		}
+		// The container is a value in its parent, but only once it closes.
+		if top != nil && top.dict {
+			top.wantKey = true
+		}
+		d.stack = append(d.stack, frame{dict: c == 'd', wantKey: c == 'd', firstKey: true})
+		return tok, nil
+
+	default:
+		return Token{}, d.errorf(at, "invalid character %q", c)
+	}
+
+	if err := d.afterValue(&tok); err != nil {
+		return Token{}, err
+	}
+	return tok, nil
+}
+
+// afterValue advances the key/value state of the enclosing dictionary once a
+// scalar (tok != nil) or a whole container (tok == nil) has been read.
+func (d *Decoder) afterValue(tok *Token) error {
+	if len(d.stack) == 0 {
+		return nil
+	}
+	top := &d.stack[len(d.stack)-1]
+	if !top.dict {
+		return nil
+	}
+	if tok == nil {
+		// A container just closed. If it was a value, the next thing is a key;
+		// ListStart/DictStart already flipped wantKey, nothing more to do.
+		return nil
+	}
+	if top.wantKey {
+		if d.Strict && !top.firstKey && bytes.Compare(tok.Bytes, top.lastKey) <= 0 {
+			if bytes.Equal(tok.Bytes, top.lastKey) {
+				return d.errorf(tok.Offset, "duplicate key %q", tok.Bytes)
+			}
+			return d.errorf(tok.Offset, "key %q not in sorted order", tok.Bytes)
+		}
+		top.lastKey = append(top.lastKey[:0], tok.Bytes...)
+		top.firstKey = false
+		top.wantKey = false
+		return nil
+	}
+	top.wantKey = true
+	return nil
+}
+
+// Skip reads and discards one complete value.
+func (d *Decoder) Skip() error {
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	return d.skipRest(tok)
+}
+
+// skipRest finishes skipping a value whose first token has been read.
+func (d *Decoder) skipRest(tok Token) error {
+	switch tok.Kind {
+	case ListStart, DictStart:
+		depth := 1
+		for depth > 0 {
+			t, err := d.Token()
+			if err != nil {
+				return err
+			}
+			switch t.Kind {
+			case ListStart, DictStart:
+				depth++
+			case End:
+				depth--
+			}
+		}
+	case End:
+		return d.errorf(tok.Offset, "unexpected end marker")
+	}
+	return nil
+}
+
+// More reports whether the innermost open list or dictionary has another element.
+func (d *Decoder) More() bool {
+	c, err := d.peekByte()
+	return err == nil && c != 'e'
+}
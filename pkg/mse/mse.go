@@ -0,0 +1,394 @@
+// Package mse implements Message Stream Encryption, also known as Protocol
+// Encryption: a Diffie-Hellman key exchange followed by an RC4 stream that
+// hides the BitTorrent handshake and, optionally, everything after it.
+//
+// The exchange, with A the side that dialled and B the side that accepted:
+//
+//	1 A->B: Ya, PadA
+//	2 B->A: Yb, PadB
+//	3 A->B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S),
+//	        ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+//	4 B->A: ENCRYPT(VC, crypto_select, len(PadD), PadD), ENCRYPT2(payload)
+//	5 A->B: ENCRYPT2(payload)
+//
+// S is the shared secret and SKEY the infohash of the torrent, which lets B
+// find the torrent A wants without it ever crossing the wire in the clear.
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+)
+
+// Crypto methods for crypto_provide and crypto_select.
+const (
+	CryptoPlaintext uint32 = 0x01 // only the handshake headers are obfuscated
+	CryptoRC4       uint32 = 0x02 // the whole stream is RC4 encrypted
+)
+
+const (
+	keySize = 96  // Ya, Yb and S are 768-bit numbers
+	maxPad  = 512 // PadA to PadD are 0 to 512 random bytes
+	// discard is how much RC4 keystream is thrown away before use.
+	discard = 1024
+)
+
+// prime is the 768-bit safe prime P of the key exchange; the generator is 2.
+var prime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+
+var generator = big.NewInt(2)
+
+// vc is the verification constant, eight zero bytes.
+var vc [8]byte
+
+// plainHeader starts every unencrypted BitTorrent handshake.
+const plainHeader = "\x13BitTorrent protocol"
+
+var (
+	// ErrPlaintext is returned by Accept under PolicyRequire when the peer
+	// opened with a plain BitTorrent handshake.
+	ErrPlaintext = errors.New("mse: peer sent a plaintext handshake")
+	// ErrEncrypted is returned by Accept under PolicyDisabled when the peer
+	// opened with an MSE handshake. The peer is expected to retry in plain.
+	ErrEncrypted = errors.New("mse: peer sent an encrypted handshake")
+	// ErrUnknownTorrent means the SKEY of an incoming connection matched
+	// none of the infohashes we serve.
+	ErrUnknownTorrent = errors.New("mse: peer asked for an unknown torrent")
+)
+
+// Conn is a peer connection after the MSE handshake. Depending on the
+// negotiated method it reads and writes RC4 encrypted data or plaintext.
+type Conn struct {
+	net.Conn
+	r io.Reader // buffered: the handshake may have read past its end
+
+	// enc and dec are nil when plaintext was selected.
+	enc, dec *rc4.Cipher
+	// Method is the crypto_select the two sides agreed on.
+	Method uint32
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if c.dec != nil {
+		c.dec.XORKeyStream(b[:n], b[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(b)
+	}
+	// Encrypt a copy: the caller still owns b.
+	buf := make([]byte, len(b))
+	c.enc.XORKeyStream(buf, b)
+	return c.Conn.Write(buf)
+}
+
+// Dial runs the MSE handshake as the connecting side over conn, which is
+// usually a freshly dialled TCP connection, for the torrent with infoHash.
+// The returned Conn is ready for the BitTorrent handshake. Under
+// PolicyRequire a peer selecting plaintext is an error; PolicyDisabled is
+// the caller's business and treated like PolicyPrefer here.
+func Dial(conn net.Conn, infoHash [20]byte, policy Policy) (*Conn, error) {
+	priv, pub, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	// 1. Ya, PadA
+	if _, err := conn.Write(append(pub, randomPad()...)); err != nil {
+		return nil, err
+	}
+
+	// 2. Yb. PadB follows, but its length is only found below by looking for VC.
+	r := bufio.NewReader(conn)
+	yb := make([]byte, keySize)
+	if _, err := io.ReadFull(r, yb); err != nil {
+		return nil, err
+	}
+	s := sharedSecret(priv, yb)
+	enc, dec, err := newCiphers(s, infoHash[:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Two hashes for B, then the encrypted provide with an empty IA: the
+	// BitTorrent handshake is sent once the method is known.
+	req2 := hash("req2", infoHash[:])
+	req3 := hash("req3", s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	padC := randomPad()
+	head := make([]byte, 0, 8+4+2+len(padC)+2)
+	head = append(head, vc[:]...)
+	head = binary.BigEndian.AppendUint32(head, policy.provide())
+	head = binary.BigEndian.AppendUint16(head, uint16(len(padC)))
+	head = append(head, padC...)
+	head = binary.BigEndian.AppendUint16(head, 0) // len(IA)
+	enc.XORKeyStream(head, head)
+
+	msg := append(hash("req1", s), req2...)
+	if _, err := conn.Write(append(msg, head...)); err != nil {
+		return nil, err
+	}
+
+	// 4. Skip PadB up to the encrypted VC, then read crypto_select and PadD.
+	encVC := make([]byte, len(vc))
+	dec.XORKeyStream(encVC, vc[:])
+	if err := syncTo(r, encVC, maxPad); err != nil {
+		return nil, fmt.Errorf("mse: no reply from peer: %w", err)
+	}
+	sel := make([]byte, 6)
+	if _, err := io.ReadFull(r, sel); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(sel, sel)
+	method := binary.BigEndian.Uint32(sel)
+	padD := int(binary.BigEndian.Uint16(sel[4:]))
+	if padD > maxPad {
+		return nil, fmt.Errorf("mse: PadD is %d bytes", padD)
+	}
+	pad := make([]byte, padD)
+	if _, err := io.ReadFull(r, pad); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(pad, pad)
+
+	c := &Conn{Conn: conn, r: r, Method: method}
+	switch {
+	case method == CryptoRC4:
+		c.enc, c.dec = enc, dec
+	case method == CryptoPlaintext && policy != PolicyRequire:
+	default:
+		return nil, fmt.Errorf("mse: peer selected crypto method %#x, we offered %#x", method, policy.provide())
+	}
+	return c, nil
+}
+
+// Accept runs the MSE handshake as the accepting side of an incoming
+// connection. skeys are the infohashes of the torrents we serve; the one the
+// peer asked for is returned with the Conn. A peer that opens with a plain
+// BitTorrent handshake is let through unencrypted, with a zero infohash,
+// unless policy is PolicyRequire; the caller then reads the handshake from
+// the Conn as usual. Under PolicyDisabled only such peers are let through.
+func Accept(conn net.Conn, skeys [][20]byte, policy Policy) (*Conn, [20]byte, error) {
+	var infoHash [20]byte
+	r := bufio.NewReader(conn)
+	start, err := r.Peek(len(plainHeader))
+	if err != nil {
+		return nil, infoHash, err
+	}
+	if string(start) == plainHeader {
+		if policy == PolicyRequire {
+			return nil, infoHash, ErrPlaintext
+		}
+		return &Conn{Conn: conn, r: r}, infoHash, nil
+	}
+	if policy == PolicyDisabled {
+		return nil, infoHash, ErrEncrypted
+	}
+
+	// 1. Ya. PadA follows, up to req1.
+	ya := make([]byte, keySize)
+	if _, err := io.ReadFull(r, ya); err != nil {
+		return nil, infoHash, err
+	}
+	priv, pub, err := newKeyPair()
+	if err != nil {
+		return nil, infoHash, err
+	}
+	// 2. Yb, PadB
+	if _, err := conn.Write(append(pub, randomPad()...)); err != nil {
+		return nil, infoHash, err
+	}
+	s := sharedSecret(priv, ya)
+
+	// 3. Find req1, then the torrent: HASH('req2', SKEY) = received xor HASH('req3', S).
+	if err := syncTo(r, hash("req1", s), maxPad); err != nil {
+		return nil, infoHash, fmt.Errorf("mse: no req1 hash: %w", err)
+	}
+	obf := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(r, obf); err != nil {
+		return nil, infoHash, err
+	}
+	req3 := hash("req3", s)
+	for i := range obf {
+		obf[i] ^= req3[i]
+	}
+	found := false
+	for _, skey := range skeys {
+		if bytes.Equal(hash("req2", skey[:]), obf) {
+			infoHash, found = skey, true
+			break
+		}
+	}
+	if !found {
+		return nil, infoHash, ErrUnknownTorrent
+	}
+
+	enc, dec, err := newCiphers(s, infoHash[:], false)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	head := make([]byte, 8+4+2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, infoHash, err
+	}
+	dec.XORKeyStream(head, head)
+	if !bytes.Equal(head[:8], vc[:]) {
+		return nil, infoHash, fmt.Errorf("mse: bad verification constant")
+	}
+	provide := binary.BigEndian.Uint32(head[8:])
+	padC := int(binary.BigEndian.Uint16(head[12:]))
+	if padC > maxPad {
+		return nil, infoHash, fmt.Errorf("mse: PadC is %d bytes", padC)
+	}
+	rest := make([]byte, padC+2)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, infoHash, err
+	}
+	dec.XORKeyStream(rest, rest)
+	ia := make([]byte, binary.BigEndian.Uint16(rest[padC:]))
+	if _, err := io.ReadFull(r, ia); err != nil {
+		return nil, infoHash, err
+	}
+	dec.XORKeyStream(ia, ia)
+
+	// 4. Pick RC4 whenever offered, plaintext only if the policy allows it.
+	var method uint32
+	switch {
+	case provide&CryptoRC4 != 0:
+		method = CryptoRC4
+	case provide&CryptoPlaintext != 0 && policy != PolicyRequire:
+		method = CryptoPlaintext
+	default:
+		return nil, infoHash, fmt.Errorf("mse: no acceptable crypto method in %#x", provide)
+	}
+	padD := randomPad()
+	reply := make([]byte, 0, 8+4+2+len(padD))
+	reply = append(reply, vc[:]...)
+	reply = binary.BigEndian.AppendUint32(reply, method)
+	reply = binary.BigEndian.AppendUint16(reply, uint16(len(padD)))
+	reply = append(reply, padD...)
+	enc.XORKeyStream(reply, reply)
+	if _, err := conn.Write(reply); err != nil {
+		return nil, infoHash, err
+	}
+
+	c := &Conn{Conn: conn, r: r, Method: method}
+	if method == CryptoRC4 {
+		c.enc, c.dec = enc, dec
+	}
+	// The initial payload, usually the peer's BitTorrent handshake, was
+	// encrypted under the handshake keys whatever the method; hand it out
+	// in the clear before the rest of the stream.
+	if len(ia) > 0 {
+		c.r = io.MultiReader(bytes.NewReader(ia), plainReader{r, c.dec})
+		c.dec = nil
+	}
+	return c, infoHash, nil
+}
+
+// plainReader decrypts what it reads with dec, if set. It lets Accept put
+// already decrypted bytes in front of the stream.
+type plainReader struct {
+	r   io.Reader
+	dec *rc4.Cipher
+}
+
+func (p plainReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if p.dec != nil {
+		p.dec.XORKeyStream(b[:n], b[:n])
+	}
+	return n, err
+}
+
+// newKeyPair picks a random 160-bit private key and returns it with the
+// public key 2^priv mod P, padded to 96 bytes.
+func newKeyPair() (*big.Int, []byte, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil, err
+	}
+	priv := new(big.Int).SetBytes(raw)
+	pub := new(big.Int).Exp(generator, priv, prime)
+	return priv, pub.FillBytes(make([]byte, keySize)), nil
+}
+
+// sharedSecret computes S = Y^priv mod P, padded to 96 bytes.
+func sharedSecret(priv *big.Int, y []byte) []byte {
+	s := new(big.Int).Exp(new(big.Int).SetBytes(y), priv, prime)
+	return s.FillBytes(make([]byte, keySize))
+}
+
+// newCiphers sets up the two RC4 streams: A encrypts with keyA and B with
+// keyB, each keyed with HASH(key name, S, SKEY) and with the first 1024
+// bytes of keystream discarded.
+func newCiphers(s, skey []byte, initiator bool) (enc, dec *rc4.Cipher, err error) {
+	a, err := rc4.NewCipher(hash("keyA", s, skey))
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := rc4.NewCipher(hash("keyB", s, skey))
+	if err != nil {
+		return nil, nil, err
+	}
+	junk := make([]byte, discard)
+	a.XORKeyStream(junk, junk)
+	b.XORKeyStream(junk, junk)
+	if initiator {
+		return a, b, nil
+	}
+	return b, a, nil
+}
+
+// hash is SHA-1 over a label and the given parts.
+func hash(label string, parts ...[]byte) []byte {
+	h := sha1.New()
+	h.Write([]byte(label))
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// randomPad returns 0 to 512 random bytes.
+func randomPad() []byte {
+	var n [2]byte
+	rand.Read(n[:])
+	pad := make([]byte, int(binary.BigEndian.Uint16(n[:]))%(maxPad+1))
+	rand.Read(pad)
+	return pad
+}
+
+// syncTo consumes r up to and including pattern, which must start within
+// limit bytes.
+func syncTo(r *bufio.Reader, pattern []byte, limit int) error {
+	window := make([]byte, 0, limit+len(pattern))
+	for len(window) < cap(window) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("pattern not found in %d bytes", limit)
+}
@@ -0,0 +1,164 @@
+package mse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+var (
+	infoHash = [20]byte{1, 2, 3, 4, 5}
+	other    = [20]byte{6, 7, 8, 9}
+)
+
+// tap records what is written to a connection.
+type tap struct {
+	net.Conn
+	mu    sync.Mutex
+	wrote bytes.Buffer
+}
+
+func (t *tap) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	t.wrote.Write(b)
+	t.mu.Unlock()
+	return t.Conn.Write(b)
+}
+
+type result struct {
+	conn     *Conn
+	infoHash [20]byte
+	err      error
+}
+
+// handshake runs Dial against Accept over net.Pipe. A side that fails
+// closes its end, as a real peer would, so the other one fails too instead
+// of waiting for it.
+func handshake(t *testing.T, dialPolicy, acceptPolicy Policy, infoHash [20]byte, skeys [][20]byte) (dialled, accepted result, wire *tap) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	wire = &tap{Conn: a}
+
+	done := make(chan result)
+	go func() {
+		c, ih, err := Accept(b, skeys, acceptPolicy)
+		if err != nil {
+			b.Close()
+		}
+		done <- result{c, ih, err}
+	}()
+	c, err := Dial(wire, infoHash, dialPolicy)
+	if err != nil {
+		a.Close()
+	}
+	return result{conn: c, err: err}, <-done, wire
+}
+
+// roundTrip sends a BitTorrent handshake header each way. net.Pipe has no
+// buffer, so every write runs alongside the read that takes it.
+func roundTrip(t *testing.T, a, b io.ReadWriter) {
+	t.Helper()
+	for _, dir := range []struct{ from, to io.ReadWriter }{{a, b}, {b, a}} {
+		msg := []byte(plainHeader + " and then some")
+		go dir.from.Write(msg)
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(dir.to, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("received %q, sent %q", got, msg)
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		dial, accept Policy
+		refused      error // of Accept; nil means RC4 both ways
+	}{
+		{PolicyPrefer, PolicyDisabled, ErrEncrypted},
+		{PolicyPrefer, PolicyPrefer, nil},
+		{PolicyPrefer, PolicyRequire, nil},
+		{PolicyRequire, PolicyDisabled, ErrEncrypted},
+		{PolicyRequire, PolicyPrefer, nil},
+		{PolicyRequire, PolicyRequire, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dial.String()+"/"+tt.accept.String(), func(t *testing.T) {
+			dialled, accepted, wire := handshake(t, tt.dial, tt.accept, infoHash, [][20]byte{other, infoHash})
+			if tt.refused != nil {
+				if !errors.Is(accepted.err, tt.refused) {
+					t.Fatalf("Accept: %v, want %v", accepted.err, tt.refused)
+				}
+				if dialled.err == nil {
+					t.Fatal("Dial succeeded against a refusing peer")
+				}
+				return
+			}
+			if dialled.err != nil || accepted.err != nil {
+				t.Fatalf("Dial: %v, Accept: %v", dialled.err, accepted.err)
+			}
+			if accepted.infoHash != infoHash {
+				t.Errorf("Accept found torrent %x, want %x", accepted.infoHash, infoHash)
+			}
+			if dialled.conn.Method != CryptoRC4 || accepted.conn.Method != CryptoRC4 {
+				t.Errorf("methods %#x and %#x, want RC4", dialled.conn.Method, accepted.conn.Method)
+			}
+			roundTrip(t, dialled.conn, accepted.conn)
+			wire.mu.Lock()
+			defer wire.mu.Unlock()
+			if bytes.Contains(wire.wrote.Bytes(), []byte(plainHeader)) {
+				t.Error("the BitTorrent header went over the wire in the clear")
+			}
+		})
+	}
+}
+
+// TestPlainFallback has a peer open with a plain BitTorrent handshake, as
+// one under PolicyPrefer does after its encrypted attempt was refused.
+func TestPlainFallback(t *testing.T) {
+	for _, policy := range []Policy{PolicyDisabled, PolicyPrefer, PolicyRequire} {
+		t.Run(policy.String(), func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+			go a.Write([]byte(plainHeader))
+			c, ih, err := Accept(b, [][20]byte{infoHash}, policy)
+			if policy == PolicyRequire {
+				if !errors.Is(err, ErrPlaintext) {
+					t.Fatalf("Accept: %v, want ErrPlaintext", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ih != [20]byte{} {
+				t.Errorf("plain connection came with infohash %x", ih)
+			}
+			// The header Accept peeked at is still there for the caller.
+			got := make([]byte, len(plainHeader))
+			if _, err := io.ReadFull(c, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != plainHeader {
+				t.Errorf("read %q after Accept, want the plain header", got)
+			}
+			roundTrip(t, a, c)
+		})
+	}
+}
+
+func TestUnknownTorrent(t *testing.T) {
+	dialled, accepted, _ := handshake(t, PolicyPrefer, PolicyPrefer, infoHash, [][20]byte{other})
+	if !errors.Is(accepted.err, ErrUnknownTorrent) {
+		t.Fatalf("Accept: %v, want ErrUnknownTorrent", accepted.err)
+	}
+	if dialled.err == nil {
+		t.Fatal("Dial succeeded for a torrent the peer doesn't have")
+	}
+}
@@ -0,0 +1,52 @@
+package mse
+
+import "fmt"
+
+// Policy decides whether peer connections are encrypted.
+type Policy int
+
+const (
+	// PolicyDisabled only uses the plain BitTorrent handshake.
+	PolicyDisabled Policy = iota
+	// PolicyPrefer tries an encrypted connection first and falls back to a
+	// plain one when the peer doesn't speak MSE.
+	PolicyPrefer
+	// PolicyRequire only talks to peers that encrypt with RC4. Peers that
+	// can't, or that pick plaintext, are dropped.
+	PolicyRequire
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyDisabled:
+		return "disabled"
+	case PolicyPrefer:
+		return "prefer"
+	case PolicyRequire:
+		return "require"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy turns a command line value ("disabled", "prefer" or "require") into a Policy.
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "disabled":
+		return PolicyDisabled, nil
+	case "", "prefer":
+		return PolicyPrefer, nil
+	case "require":
+		return PolicyRequire, nil
+	}
+	return 0, fmt.Errorf("unknown encryption policy %q (want disabled, prefer or require)", s)
+}
+
+// provide is the crypto_provide bitfield we offer under p. Prefer offers
+// both methods and lets the other side choose; the headers are obfuscated
+// either way.
+func (p Policy) provide() uint32 {
+	if p == PolicyRequire {
+		return CryptoRC4
+	}
+	return CryptoPlaintext | CryptoRC4
+}
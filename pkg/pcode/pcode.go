@@ -10,4 +10,20 @@ const (
 	MsgRequest       uint8 = 6
 	MsgPiece         uint8 = 7
 	MsgCancel        uint8 = 8
+	MsgPort          uint8 = 9 // DHT port (BEP 5)
+
+	// Fast Extension (BEP 6), only sent when both peers set peer.ReservedFast
+	MsgSuggest     uint8 = 0x0D
+	MsgHaveAll     uint8 = 0x0E
+	MsgHaveNone    uint8 = 0x0F
+	MsgReject      uint8 = 0x10
+	MsgAllowedFast uint8 = 0x11
+
+	// Extension protocol (BEP 10); the payload starts with the extended ID
+	MsgExtended uint8 = 20
+
+	// BitTorrent v2 (BEP 52) merkle hash exchange
+	MsgHashRequest uint8 = 21
+	MsgHashes      uint8 = 22
+	MsgHashReject  uint8 = 23
 )
@@ -0,0 +1,50 @@
+package peer
+
+import "github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+
+// ReservedExtension is the reserved bit announcing the extension protocol
+// (BEP 10). It lives in the sixth reserved byte.
+const ReservedExtension = 0x10
+
+// SupportsExtensions reports whether the peer set the extension protocol bit.
+func (h *Handshake) SupportsExtensions() bool {
+	return h.Reserved[5]&ReservedExtension != 0
+}
+
+// ExtHandshake is the extended message ID of the extended handshake.
+const ExtHandshake = 0
+
+// ExtendedHandshake is the dictionary of the extended handshake (BEP 10).
+// Keys we don't use are dropped when parsing.
+type ExtendedHandshake struct {
+	// M maps the names of supported extensions to the extended message IDs
+	// the sender wants them on.
+	M map[string]int `bencode:"m"`
+	// V is the client name and version.
+	V string `bencode:"v,omitempty"`
+	// Reqq is how many outstanding requests the sender queues before it
+	// drops new ones; zero when it doesn't say.
+	Reqq int `bencode:"reqq,omitempty"`
+}
+
+// Message returns the handshake as extended message 0.
+func (h ExtendedHandshake) Message() *Message {
+	if h.M == nil {
+		h.M = map[string]int{}
+	}
+	payload, err := bencode.Marshal(h)
+	if err != nil {
+		panic(err) // a map and plain fields always encode
+	}
+	return Extended{ID: ExtHandshake, Payload: payload}.Message()
+}
+
+// ParseExtendedHandshake decodes the payload of extended message 0; one that
+// isn't a dictionary is a ProtocolError.
+func ParseExtendedHandshake(payload []byte) (*ExtendedHandshake, error) {
+	var h ExtendedHandshake
+	if err := bencode.Unmarshal(payload, &h); err != nil {
+		return nil, ProtocolErrorf("extended handshake: %v", err)
+	}
+	return &h, nil
+}
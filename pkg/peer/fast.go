@@ -0,0 +1,47 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+)
+
+// ReservedFast is the reserved bit announcing the Fast Extension (BEP 6). It
+// lives in the last reserved byte; the extension is on only when both peers set it.
+const ReservedFast = 0x04
+
+// SupportsFast reports whether the peer set the fast extension bit.
+func (h *Handshake) SupportsFast() bool {
+	return h.Reserved[7]&ReservedFast != 0
+}
+
+// AllowedFastSet computes the k pieces a peer at ip may request while choked,
+// with the canonical algorithm of BEP 6: repeatedly SHA-1 hash the peer's /24
+// network and the infohash, reading piece indexes off each digest. Both sides
+// derive the same set, so it can't be gamed by reconnecting from a
+// neighbouring address. Only IPv4 is defined; other addresses get no set.
+func AllowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []int {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	k = min(k, numPieces)
+
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+	var set []int
+	seen := map[int]bool{}
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := int(binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces))
+			if !seen[index] {
+				seen[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
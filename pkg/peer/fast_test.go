@@ -0,0 +1,42 @@
+package peer
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestAllowedFastSet(t *testing.T) {
+	var aa [20]byte
+	for i := range aa {
+		aa[i] = 0xaa
+	}
+	tests := []struct {
+		ip        string
+		numPieces int
+		k         int
+		want      []int
+	}{
+		// The reference vectors of BEP 6.
+		{"80.4.4.200", 1313, 7, []int{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"80.4.4.200", 1313, 9, []int{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}},
+		// Only the /24 network counts.
+		{"80.4.4.1", 1313, 7, []int{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"::ffff:80.4.4.200", 1313, 7, []int{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"2001:db8::1", 1313, 7, nil},
+		{"80.4.4.200", 0, 7, nil},
+	}
+	for _, tt := range tests {
+		got := AllowedFastSet(net.ParseIP(tt.ip), aa, tt.numPieces, tt.k)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("AllowedFastSet(%s, 0xaa..., %d, %d) = %v, want %v", tt.ip, tt.numPieces, tt.k, got, tt.want)
+		}
+	}
+
+	// k can't exceed the pieces there are.
+	got := AllowedFastSet(net.ParseIP("80.4.4.200"), aa, 3, 7)
+	slices.Sort(got)
+	if !slices.Equal(got, []int{0, 1, 2}) {
+		t.Errorf("AllowedFastSet with 3 pieces and k = 7 = %v, want every piece", got)
+	}
+}
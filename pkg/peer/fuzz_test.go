@@ -0,0 +1,99 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/pcode"
+)
+
+func frame(id uint8, payload ...byte) []byte {
+	return (&Message{ID: id, Payload: payload}).Serialize()
+}
+
+// FuzzRead checks that Read never panics, never allocates past the limit the
+// length prefix is checked against, and that what it decodes encodes back to
+// the bytes it read.
+func FuzzRead(f *testing.F) {
+	f.Add(make([]byte, 4))
+	f.Add(frame(pcode.MsgUnchoke))
+	f.Add(frame(pcode.MsgHave, 0, 0, 0, 7))
+	f.Add(frame(pcode.MsgRequest, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0))
+	f.Add(frame(pcode.MsgPiece, 0, 0, 0, 1, 0, 0, 0, 0, 'a', 'b', 'c'))
+	f.Add(frame(pcode.MsgBitfield, 0xff, 0x80))
+	f.Add(frame(pcode.MsgExtended, 0, 'd', 'e'))
+	f.Add(frame(pcode.MsgHave, 1, 2))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, pcode.MsgPiece})
+
+	limits := DefaultLimits.With(pcode.MsgBitfield, 64)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := limits.Read(bytes.NewReader(data))
+		if len(data) >= 5 {
+			length := binary.BigEndian.Uint32(data)
+			if length > limits.Max(data[4]) {
+				var pe *ProtocolError
+				if !errors.As(err, &pe) {
+					t.Fatalf("message of %d bytes read with err %v, want a protocol error", length, err)
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+		out := Encode(msg)
+		if !bytes.Equal(out, data[:len(out)]) {
+			t.Fatalf("%#v encodes to %x, read from %x", msg, out, data)
+		}
+	})
+}
+
+// FuzzReadHandshake checks that ReadHandshake never panics and that a
+// handshake it accepts serializes back to the bytes it read.
+func FuzzReadHandshake(f *testing.F) {
+	hs := Handshake{Pstr: "BitTorrent protocol", InfoHash: [20]byte{1}, PeerID: [20]byte{2}}
+	hs.Reserved[7] = ReservedFast
+	f.Add(hs.Serialize())
+	f.Add([]byte{0})
+	f.Add([]byte{3, 'a', 'b'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := ReadHandshake(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		out := h.Serialize()
+		if !bytes.Equal(out, data[:len(out)]) {
+			t.Fatalf("%+v serializes to %x, read from %x", h, out, data)
+		}
+	})
+}
+
+// FuzzDecodePiece checks piece parsing, which moved into Decode with the
+// typed messages: any payload either fails with a protocol error or yields a
+// piece that round-trips.
+func FuzzDecodePiece(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0x40, 0, 'x'})
+	f.Add([]byte{0, 0, 0, 1})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		msg, err := Decode(&Message{ID: pcode.MsgPiece, Payload: payload})
+		if err != nil {
+			var pe *ProtocolError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Decode(%x) = %v, want a protocol error", payload, err)
+			}
+			return
+		}
+		piece, ok := msg.(Piece)
+		if !ok {
+			t.Fatalf("Decode(%x) = %#v, want a Piece", payload, msg)
+		}
+		if got := piece.Message(); !reflect.DeepEqual(got.Payload, payload) {
+			t.Fatalf("%#v encodes to %x, decoded from %x", piece, got.Payload, payload)
+		}
+	})
+}
@@ -13,28 +13,35 @@
 
 package peer
 
-import (
-	"fmt"
-	"io"
-)
+import "io"
 
 // Handshake represents the message used to start a connection with a peer
 type Handshake struct {
 	Pstr     string
+	Reserved [8]byte // extension bits, see the Reserved* constants
 	InfoHash [20]byte
 	PeerID   [20]byte
 }
 
+// ReservedV2 is the reserved bit a peer sets to say it speaks BitTorrent v2
+// (BEP 52). It lives in the last reserved byte.
+const ReservedV2 = 0x10
+
+// SupportsV2 reports whether the peer set the v2 bit.
+func (h *Handshake) SupportsV2() bool {
+	return h.Reserved[7]&ReservedV2 != 0
+}
+
 // Serialize converts the Handshake struct into a byte slice that can be sent over the network
 
 func (h *Handshake) Serialize() []byte {
 	buf := make([]byte, 49+len(h.Pstr))
 	buf[0] = byte(len(h.Pstr)) // first byte: length of the protocol string
 	curr := 1
-	curr += copy(buf[curr:], h.Pstr)          // The string "BitTorrent protocol
-	curr += copy(buf[curr:], make([]byte, 8)) // reserved bytes (8 zeros)
-	curr += copy(buf[curr:], h.InfoHash[:])   // info hash (20 bytes)
-	curr += copy(buf[curr:], h.PeerID[:])     // peer ID (20 bytes)
+	curr += copy(buf[curr:], h.Pstr)        // The string "BitTorrent protocol
+	curr += copy(buf[curr:], h.Reserved[:]) // reserved bytes (extension bits)
+	curr += copy(buf[curr:], h.InfoHash[:]) // info hash (20 bytes)
+	curr += copy(buf[curr:], h.PeerID[:])   // peer ID (20 bytes)
 	return buf
 }
 
@@ -50,7 +57,7 @@ func ReadHandshake(r io.Reader) (*Handshake, error) {
 	}
 	pstrLen := int(lengthBuf[0])
 	if pstrLen == 0 {
-		return nil, fmt.Errorf("invalid protocol string length: %d", pstrLen)
+		return nil, ProtocolErrorf("invalid protocol string length: %d", pstrLen)
 	}
 	// read the rest of the handshake (pstrLen + 48 bytes)
 	handshakeBuf := make([]byte, pstrLen+48)
@@ -60,12 +67,15 @@ func ReadHandshake(r io.Reader) (*Handshake, error) {
 	}
 	//pstrLen + 48: Since the protocol string is usually 19 bytes, $19 + 48 = 67$.
 	// Plus the 1-byte length at the start makes the total 68 bytes.
+	var reserved [8]byte
 	var infohash, peerID [20]byte
+	copy(reserved[:], handshakeBuf[pstrLen:pstrLen+8])
 	copy(infohash[:], handshakeBuf[pstrLen+8:pstrLen+28]) // info hash starts after pstr and reserved bytes
 	copy(peerID[:], handshakeBuf[pstrLen+28:])
 
 	return &Handshake{
 		Pstr:     string(handshakeBuf[0:pstrLen]),
+		Reserved: reserved,
 		InfoHash: infohash,
 		PeerID:   peerID,
 	}, nil
@@ -0,0 +1,37 @@
+package peer
+
+import "encoding/binary"
+
+// HashRequest asks a v2 peer for a range of one file's merkle tree (BEP 52).
+// The same fields open the hashes and hash reject messages.
+type HashRequest struct {
+	PiecesRoot  [32]byte // root of the file's tree, identifying the file
+	BaseLayer   uint32   // layer of the requested hashes, 0 being the 16 KiB leaves
+	Index       uint32   // offset of the first hash within that layer
+	Length      uint32   // number of hashes, a power of two >= 2
+	ProofLayers uint32   // uncle hashes to include above the requested range
+}
+
+const hashRequestLen = 32 + 4*4
+
+func (r HashRequest) payload() []byte {
+	buf := make([]byte, hashRequestLen)
+	copy(buf, r.PiecesRoot[:])
+	binary.BigEndian.PutUint32(buf[32:36], r.BaseLayer)
+	binary.BigEndian.PutUint32(buf[36:40], r.Index)
+	binary.BigEndian.PutUint32(buf[40:44], r.Length)
+	binary.BigEndian.PutUint32(buf[44:48], r.ProofLayers)
+	return buf
+}
+
+// parseHashRequest decodes the fields that start every hash message. The
+// caller has checked the length.
+func parseHashRequest(payload []byte) HashRequest {
+	var r HashRequest
+	copy(r.PiecesRoot[:], payload[:32])
+	r.BaseLayer = binary.BigEndian.Uint32(payload[32:36])
+	r.Index = binary.BigEndian.Uint32(payload[36:40])
+	r.Length = binary.BigEndian.Uint32(payload[40:44])
+	r.ProofLayers = binary.BigEndian.Uint32(payload[44:48])
+	return r
+}
@@ -0,0 +1,72 @@
+package peer
+
+import (
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/pcode"
+)
+
+// ProtocolError is a breach of the wire protocol by the remote peer: an
+// oversized or malformed message, or one that makes no sense at that point
+// of the conversation. The connection can't be trusted afterwards; close it
+// and hold the peer to account.
+type ProtocolError struct {
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol violation: " + e.Reason
+}
+
+// ProtocolErrorf formats a ProtocolError.
+func ProtocolErrorf(format string, args ...any) error {
+	return &ProtocolError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Limits caps the length of incoming messages by type, the ID byte
+// included. The length prefix is checked before anything is allocated, so a
+// peer can't make us reserve gigabytes by claiming a huge message.
+type Limits struct {
+	// Default applies to message types without an entry in ByID.
+	Default uint32
+	ByID    map[uint8]uint32
+}
+
+// DefaultLimits suit a peer of an unknown torrent. Connections that know
+// the piece count should tighten the bitfield limit with With.
+var DefaultLimits = Limits{
+	Default: 1 << 10,
+	ByID: map[uint8]uint32{
+		pcode.MsgBitfield: 1 + 1<<20, // 8 million pieces
+		pcode.MsgPiece:    1 + 8 + MaxBlockSize,
+		pcode.MsgExtended: 1 << 20,
+		pcode.MsgHashes:   1 + hashRequestLen + 32<<16,
+	},
+}
+
+// Max returns the largest allowed length of a message with the given ID.
+func (l Limits) Max(id uint8) uint32 {
+	if n, ok := l.ByID[id]; ok {
+		return n
+	}
+	return l.Default
+}
+
+// With returns a copy of l with the limit for one message type replaced.
+func (l Limits) With(id uint8, max uint32) Limits {
+	l.ByID = maps.Clone(l.ByID)
+	if l.ByID == nil {
+		l.ByID = map[uint8]uint32{}
+	}
+	l.ByID[id] = max
+	return l
+}
+
+// Read reads the next message from a stream and decodes it, refusing
+// messages longer than l allows with a ProtocolError. A keep-alive comes
+// back as KeepAlive. Connections reading many messages should use a Reader.
+func (l Limits) Read(r io.Reader) (Msg, error) {
+	return NewReader(r, l).Read()
+}
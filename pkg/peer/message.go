@@ -2,7 +2,6 @@ package peer
 
 import (
 	"encoding/binary"
-	"fmt"
 	"io"
 )
 
@@ -29,32 +28,9 @@ func (m *Message) Serialize() []byte {
 	return buf
 }
 
-//Read parses a msg from a stream
-
-func ReadMessage(r io.Reader) (*Message, error) {
-	// read the length prefix (4 bytes)
-	lenBuf := make([]byte, 4)
-	_, err := io.ReadFull(r, lenBuf)
-	if err != nil {
-		return nil, err
-	}
-	//BitTorrent uses Big Endian for all integers.
-	//This ensures our 4-byte length prefix is written in the correct network byte order.
-	length := binary.BigEndian.Uint32(lenBuf)
-	if length == 0 {
-		// This is a keep-alive message (no ID, no payload)
-		return nil, nil
-	}
-	// read the message ID (1 byte)
-	msgBuf := make([]byte, length)
-	_, err = io.ReadFull(r, msgBuf)
-	if err != nil {
-		return nil, err
-	}
-	return &Message{
-		ID:      uint8(msgBuf[0]), // first byte is the message ID
-		Payload: msgBuf[1:],       // the rest is the payload
-	}, nil
+// Read reads the next message from a stream with DefaultLimits.
+func Read(r io.Reader) (Msg, error) {
+	return DefaultLimits.Read(r)
 }
 
 // Bitfield represents the pieces a peer has
@@ -73,55 +49,12 @@ func (b Bitfield) HasPiece(index int) bool {
 	return b[byteIndex]>>(7-bitIndex)&1 != 0
 }
 
-// ParsePiece validates a PIECE message and returns the offset and the raw block data.
-func ParsePiece(index int, buf []byte, msg *Message) (int, []byte, error) {
-	// A Piece message payload must at least have 8 bytes (4 for index, 4 for begin)
-	if len(msg.Payload) < 8 {
-		return 0, nil, fmt.Errorf("payload too short: %d", len(msg.Payload))
-	}
-
-	parsedIndex := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
-	if parsedIndex != index {
-		return 0, nil, fmt.Errorf("expected index %d, got %d", index, parsedIndex)
-	}
-
-	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
-	if begin >= len(buf) {
-		return 0, nil, fmt.Errorf("begin offset %d out of bounds", begin)
-	}
-
-	data := msg.Payload[8:]
-	if begin+len(data) > len(buf) {
-		return 0, nil, fmt.Errorf("data too long for offset %d with length %d", begin, len(data))
-	}
-
-	return begin, data, nil
-}
-
-// Read parses a BitTorrent message from a stream
-func Read(r io.Reader) (*Message, error) {
-	// 1. Read the 4-byte length prefix
-	lengthBuf := make([]byte, 4)
-	_, err := io.ReadFull(r, lengthBuf)
-	if err != nil {
-		return nil, err
-	}
-	length := binary.BigEndian.Uint32(lengthBuf)
-
-	// 2. Handle Keep-Alive messages (length 0)
-	if length == 0 {
-		return nil, nil
-	}
-
-	// 3. Read the rest of the message (ID + Payload)
-	messageBuf := make([]byte, length)
-	_, err = io.ReadFull(r, messageBuf)
-	if err != nil {
-		return nil, err
+// SetPiece marks a piece as present, e.g. after a have message.
+func (b Bitfield) SetPiece(index int) {
+	byteIndex := index / 8
+	bitIndex := index % 8
+	if byteIndex < 0 || byteIndex >= len(b) {
+		return
 	}
-
-	return &Message{
-		ID:      messageBuf[0],
-		Payload: messageBuf[1:],
-	}, nil
+	b[byteIndex] |= 1 << (7 - bitIndex)
 }
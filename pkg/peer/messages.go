@@ -0,0 +1,271 @@
+package peer
+
+import (
+	"encoding/binary"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/pcode"
+)
+
+// Msg is a decoded peer message. Every message type of the wire protocol
+// has its own type below; Read and Decode return them, and Encode turns
+// them back into bytes. Messages with an ID we don't know come back as the
+// raw *Message.
+type Msg interface {
+	// Message returns the wire form, nil for a keep-alive.
+	Message() *Message
+}
+
+// Encode serializes a message with its length prefix.
+func Encode(m Msg) []byte {
+	return m.Message().Serialize()
+}
+
+type (
+	KeepAlive     struct{}
+	Choke         struct{}
+	Unchoke       struct{}
+	Interested    struct{}
+	NotInterested struct{}
+	// HaveAll and HaveNone replace the bitfield of a peer that has every
+	// piece or none (BEP 6).
+	HaveAll  struct{}
+	HaveNone struct{}
+)
+
+// Have announces that the sender got a piece.
+type Have struct{ Index int }
+
+// Suggest advises downloading a piece, typically one in the sender's cache (BEP 6).
+type Suggest struct{ Index int }
+
+// AllowedFast lets the receiver request a piece even while choked (BEP 6).
+type AllowedFast struct{ Index int }
+
+// Request asks for a block of a piece.
+type Request struct{ Index, Begin, Length int }
+
+// Cancel withdraws an earlier request.
+type Cancel struct{ Index, Begin, Length int }
+
+// Reject tells the peer a request won't be answered (BEP 6). It carries the
+// fields of the request.
+type Reject struct{ Index, Begin, Length int }
+
+// Piece carries a block of a piece.
+type Piece struct {
+	Index, Begin int
+	Block        []byte
+}
+
+// Port gives the sender's DHT port (BEP 5).
+type Port struct{ Port uint16 }
+
+// Extended is an extension protocol message (BEP 10). ID 0 is the extended
+// handshake; the others are assigned in it.
+type Extended struct {
+	ID      uint8
+	Payload []byte
+}
+
+// HashReject refuses a hash request (BEP 52).
+type HashReject struct{ HashRequest }
+
+// Hashes answers a hash request with the requested hashes followed by the proof (BEP 52).
+type Hashes struct {
+	HashRequest
+	Hashes [][32]byte
+}
+
+func (KeepAlive) Message() *Message     { return nil }
+func (Choke) Message() *Message         { return &Message{ID: pcode.MsgChoke} }
+func (Unchoke) Message() *Message       { return &Message{ID: pcode.MsgUnchoke} }
+func (Interested) Message() *Message    { return &Message{ID: pcode.MsgInterested} }
+func (NotInterested) Message() *Message { return &Message{ID: pcode.MsgNotInterested} }
+func (HaveAll) Message() *Message       { return &Message{ID: pcode.MsgHaveAll} }
+func (HaveNone) Message() *Message      { return &Message{ID: pcode.MsgHaveNone} }
+
+func (m Have) Message() *Message        { return indexMessage(pcode.MsgHave, m.Index) }
+func (m Suggest) Message() *Message     { return indexMessage(pcode.MsgSuggest, m.Index) }
+func (m AllowedFast) Message() *Message { return indexMessage(pcode.MsgAllowedFast, m.Index) }
+
+func (m Request) Message() *Message {
+	return blockMessage(pcode.MsgRequest, m.Index, m.Begin, m.Length)
+}
+
+func (m Cancel) Message() *Message {
+	return blockMessage(pcode.MsgCancel, m.Index, m.Begin, m.Length)
+}
+
+func (m Reject) Message() *Message {
+	return blockMessage(pcode.MsgReject, m.Index, m.Begin, m.Length)
+}
+
+func (m Piece) Message() *Message {
+	payload := make([]byte, 8+len(m.Block))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(m.Index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(m.Begin))
+	copy(payload[8:], m.Block)
+	return &Message{ID: pcode.MsgPiece, Payload: payload}
+}
+
+func (m Port) Message() *Message {
+	return &Message{ID: pcode.MsgPort, Payload: binary.BigEndian.AppendUint16(nil, m.Port)}
+}
+
+func (m Extended) Message() *Message {
+	return &Message{ID: pcode.MsgExtended, Payload: append([]byte{m.ID}, m.Payload...)}
+}
+
+func (b Bitfield) Message() *Message {
+	return &Message{ID: pcode.MsgBitfield, Payload: b}
+}
+
+func (r HashRequest) Message() *Message {
+	return &Message{ID: pcode.MsgHashRequest, Payload: r.payload()}
+}
+
+func (m HashReject) Message() *Message {
+	return &Message{ID: pcode.MsgHashReject, Payload: m.payload()}
+}
+
+func (m Hashes) Message() *Message {
+	payload := m.payload()
+	for _, h := range m.Hashes {
+		payload = append(payload, h[:]...)
+	}
+	return &Message{ID: pcode.MsgHashes, Payload: payload}
+}
+
+// Message makes an undecoded message a Msg, for IDs Decode doesn't know.
+func (m *Message) Message() *Message { return m }
+
+func indexMessage(id uint8, index int) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return &Message{ID: id, Payload: payload}
+}
+
+func blockMessage(id uint8, index, begin, length int) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return &Message{ID: id, Payload: payload}
+}
+
+// Decode turns a raw message into its typed form, checking that the payload
+// has exactly the length its type calls for; a wrong length is a
+// ProtocolError. A nil message is a keep-alive.
+// Bitfields are returned as they are: only the caller knows how many pieces
+// there are. Messages and payloads alias m.
+func Decode(m *Message) (Msg, error) {
+	if m == nil {
+		return KeepAlive{}, nil
+	}
+	p := m.Payload
+	switch m.ID {
+	case pcode.MsgChoke, pcode.MsgUnchoke, pcode.MsgInterested, pcode.MsgNotInterested,
+		pcode.MsgHaveAll, pcode.MsgHaveNone:
+		if err := wantLen(m, 0); err != nil {
+			return nil, err
+		}
+		switch m.ID {
+		case pcode.MsgChoke:
+			return Choke{}, nil
+		case pcode.MsgUnchoke:
+			return Unchoke{}, nil
+		case pcode.MsgInterested:
+			return Interested{}, nil
+		case pcode.MsgNotInterested:
+			return NotInterested{}, nil
+		case pcode.MsgHaveAll:
+			return HaveAll{}, nil
+		default:
+			return HaveNone{}, nil
+		}
+
+	case pcode.MsgHave, pcode.MsgSuggest, pcode.MsgAllowedFast:
+		if err := wantLen(m, 4); err != nil {
+			return nil, err
+		}
+		index := int(binary.BigEndian.Uint32(p))
+		switch m.ID {
+		case pcode.MsgHave:
+			return Have{index}, nil
+		case pcode.MsgSuggest:
+			return Suggest{index}, nil
+		default:
+			return AllowedFast{index}, nil
+		}
+
+	case pcode.MsgRequest, pcode.MsgCancel, pcode.MsgReject:
+		if err := wantLen(m, 12); err != nil {
+			return nil, err
+		}
+		index := int(binary.BigEndian.Uint32(p[0:4]))
+		begin := int(binary.BigEndian.Uint32(p[4:8]))
+		length := int(binary.BigEndian.Uint32(p[8:12]))
+		switch m.ID {
+		case pcode.MsgRequest:
+			return Request{index, begin, length}, nil
+		case pcode.MsgCancel:
+			return Cancel{index, begin, length}, nil
+		default:
+			return Reject{index, begin, length}, nil
+		}
+
+	case pcode.MsgBitfield:
+		return Bitfield(p), nil
+
+	case pcode.MsgPiece:
+		if len(p) < 8 {
+			return nil, ProtocolErrorf("piece message: payload too short: %d", len(p))
+		}
+		return Piece{
+			Index: int(binary.BigEndian.Uint32(p[0:4])),
+			Begin: int(binary.BigEndian.Uint32(p[4:8])),
+			Block: p[8:],
+		}, nil
+
+	case pcode.MsgPort:
+		if err := wantLen(m, 2); err != nil {
+			return nil, err
+		}
+		return Port{binary.BigEndian.Uint16(p)}, nil
+
+	case pcode.MsgExtended:
+		if len(p) < 1 {
+			return nil, ProtocolErrorf("extended message without an extended ID")
+		}
+		return Extended{ID: p[0], Payload: p[1:]}, nil
+
+	case pcode.MsgHashRequest, pcode.MsgHashReject:
+		if err := wantLen(m, hashRequestLen); err != nil {
+			return nil, err
+		}
+		r := parseHashRequest(p)
+		if m.ID == pcode.MsgHashReject {
+			return HashReject{r}, nil
+		}
+		return r, nil
+
+	case pcode.MsgHashes:
+		if len(p) < hashRequestLen || (len(p)-hashRequestLen)%32 != 0 {
+			return nil, ProtocolErrorf("hashes message: payload of %d bytes is not a hash request and whole hashes", len(p))
+		}
+		rest := p[hashRequestLen:]
+		hashes := make([][32]byte, len(rest)/32)
+		for i := range hashes {
+			copy(hashes[i][:], rest[i*32:])
+		}
+		return Hashes{parseHashRequest(p), hashes}, nil
+	}
+	return m, nil
+}
+
+func wantLen(m *Message, n int) error {
+	if len(m.Payload) != n {
+		return ProtocolErrorf("message %d: payload must be %d bytes, got %d", m.ID, n, len(m.Payload))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package peer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestDecodeLengths round-trips every message that has a fixed payload
+// length, then checks that a byte more or less is a ProtocolError.
+func TestDecodeLengths(t *testing.T) {
+	req := HashRequest{PiecesRoot: [32]byte{1}, BaseLayer: 0, Index: 4, Length: 2, ProofLayers: 3}
+	for _, tt := range []struct {
+		name string
+		msg  Msg
+	}{
+		{"choke", Choke{}},
+		{"unchoke", Unchoke{}},
+		{"interested", Interested{}},
+		{"not interested", NotInterested{}},
+		{"have", Have{7}},
+		{"request", Request{1, 16384, 16384}},
+		{"cancel", Cancel{1, 16384, 16384}},
+		{"port", Port{6881}},
+		{"suggest", Suggest{3}},
+		{"have all", HaveAll{}},
+		{"have none", HaveNone{}},
+		{"reject", Reject{2, 0, 16384}},
+		{"allowed fast", AllowedFast{9}},
+		{"hash request", req},
+		{"hash reject", HashReject{req}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.msg.Message()
+			got, err := Decode(m)
+			if err != nil || !reflect.DeepEqual(got, tt.msg) {
+				t.Fatalf("Decode(%x) = %#v, %v, want %#v", m.Payload, got, err, tt.msg)
+			}
+			payloads := [][]byte{append(m.Payload[:len(m.Payload):len(m.Payload)], 0)}
+			if len(m.Payload) > 0 {
+				payloads = append(payloads, m.Payload[:len(m.Payload)-1], nil)
+			}
+			for _, p := range payloads {
+				checkProtocolError(t, &Message{ID: m.ID, Payload: p})
+			}
+		})
+	}
+}
+
+// TestDecodeShort checks the messages of variable length against their minimum.
+func TestDecodeShort(t *testing.T) {
+	for _, m := range []*Message{
+		Piece{Index: 1, Begin: 2}.Message(),
+		Extended{ID: 1}.Message(),
+		Hashes{HashRequest{Length: 2}, [][32]byte{{1}, {2}}}.Message(),
+	} {
+		if _, err := Decode(m); err != nil {
+			t.Fatalf("Decode(%d, %x): %v", m.ID, m.Payload, err)
+		}
+		checkProtocolError(t, &Message{ID: m.ID, Payload: m.Payload[:len(m.Payload)-1]})
+	}
+}
+
+func checkProtocolError(t *testing.T, m *Message) {
+	t.Helper()
+	msg, err := Decode(m)
+	var pe *ProtocolError
+	if !errors.As(err, &pe) {
+		t.Errorf("Decode of message %d with %d payload bytes = %#v, %v, want a ProtocolError", m.ID, len(m.Payload), msg, err)
+	}
+}
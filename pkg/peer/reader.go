@@ -0,0 +1,77 @@
+package peer
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/pcode"
+)
+
+// Reader reads messages from one stream without allocating a buffer per
+// message. Payloads are read into a buffer that the next Read reuses, and
+// piece blocks can be read straight into the piece they belong to.
+type Reader struct {
+	r      io.Reader
+	limits Limits
+	head   [5]byte
+	buf    []byte
+
+	// Dest, if set, is asked where the block of a piece message goes before
+	// the block is read. It returns a slice of exactly length bytes to read
+	// the block into, or nil to read it into the reused buffer like any
+	// other payload.
+	Dest func(index, begin, length int) []byte
+}
+
+// NewReader returns a Reader for r that refuses messages longer than limits
+// allow.
+func NewReader(r io.Reader, limits Limits) *Reader {
+	return &Reader{r: r, limits: limits}
+}
+
+// Read reads and decodes the next message, like Limits.Read. The message
+// aliases the reader's buffer and is only valid until the next call, except
+// for a piece block read into a slice from Dest.
+func (r *Reader) Read() (Msg, error) {
+	// read the length prefix (4 bytes) and the message ID (1 byte)
+	if _, err := io.ReadFull(r.r, r.head[:4]); err != nil {
+		return nil, err
+	}
+	//BitTorrent uses Big Endian for all integers.
+	length := binary.BigEndian.Uint32(r.head[:4])
+	if length == 0 {
+		// This is a keep-alive message (no ID, no payload)
+		return KeepAlive{}, nil
+	}
+	if _, err := io.ReadFull(r.r, r.head[4:]); err != nil {
+		return nil, err
+	}
+	id := r.head[4]
+	if max := r.limits.Max(id); length > max {
+		return nil, ProtocolErrorf("message %d of %d bytes exceeds the limit of %d", id, length, max)
+	}
+
+	n := int(length - 1)
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	payload := r.buf[:n]
+	if id == pcode.MsgPiece && n >= 8 && r.Dest != nil {
+		if _, err := io.ReadFull(r.r, payload[:8]); err != nil {
+			return nil, err
+		}
+		index := int(binary.BigEndian.Uint32(payload[0:4]))
+		begin := int(binary.BigEndian.Uint32(payload[4:8]))
+		if dst := r.Dest(index, begin, n-8); len(dst) == n-8 {
+			if _, err := io.ReadFull(r.r, dst); err != nil {
+				return nil, err
+			}
+			return Piece{Index: index, Begin: begin, Block: dst}, nil
+		}
+		payload = payload[8:]
+	}
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, err
+	}
+	return Decode(&Message{ID: id, Payload: r.buf[:n]})
+}
@@ -0,0 +1,58 @@
+package peer
+
+import (
+	"bytes"
+	"testing"
+)
+
+// pieceStream is 1 MiB of piece data as 16 KiB blocks on the wire.
+func pieceStream() []byte {
+	var stream []byte
+	block := make([]byte, MaxBlockSize)
+	for begin := 0; begin < 1<<20; begin += MaxBlockSize {
+		stream = append(stream, Encode(Piece{Index: 0, Begin: begin, Block: block})...)
+	}
+	return stream
+}
+
+// BenchmarkReadPiece reads a megabyte of blocks with Read, which allocates
+// every payload; allocs/op is allocations per MB.
+func BenchmarkReadPiece(b *testing.B) {
+	stream := pieceStream()
+	piece := make([]byte, 1<<20)
+	b.SetBytes(int64(len(piece)))
+	b.ReportAllocs()
+	for b.Loop() {
+		r := bytes.NewReader(stream)
+		for r.Len() > 0 {
+			msg, err := Read(r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			p := msg.(Piece)
+			copy(piece[p.Begin:], p.Block)
+		}
+	}
+}
+
+// BenchmarkReaderPiece reads the same megabyte with a Reader that puts the
+// blocks straight into the piece.
+func BenchmarkReaderPiece(b *testing.B) {
+	stream := pieceStream()
+	piece := make([]byte, 1<<20)
+	src := bytes.NewReader(stream)
+	r := NewReader(src, DefaultLimits)
+	r.Dest = func(index, begin, length int) []byte {
+		return piece[begin : begin+length]
+	}
+	b.SetBytes(int64(len(piece)))
+	b.ReportAllocs()
+	for b.Loop() {
+		src.Reset(stream)
+		for src.Len() > 0 {
+			if _, err := r.Read(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import "fmt"
+
+// AllocationMode controls how the payload files are laid out on disk before
+// any piece is written.
+type AllocationMode int
+
+const (
+	// AllocSparse creates the files at their final size without reserving
+	// blocks. The filesystem only allocates space as pieces land.
+	AllocSparse AllocationMode = iota
+	// AllocFull reserves every block up front so the files are contiguous
+	// and a full disk is discovered before the download starts.
+	AllocFull
+)
+
+func (m AllocationMode) String() string {
+	switch m {
+	case AllocSparse:
+		return "sparse"
+	case AllocFull:
+		return "full"
+	}
+	return fmt.Sprintf("AllocationMode(%d)", int(m))
+}
+
+// ParseAllocationMode turns a command line value ("sparse" or "full") into an AllocationMode.
+func ParseAllocationMode(s string) (AllocationMode, error) {
+	switch s {
+	case "", "sparse":
+		return AllocSparse, nil
+	case "full":
+		return AllocFull, nil
+	}
+	return 0, fmt.Errorf("unknown allocation mode %q (want sparse or full)", s)
+}
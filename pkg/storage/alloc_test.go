@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sparseFile creates a file of size bytes holding only data at off, and
+// skips the test when the filesystem doesn't keep it sparse.
+func sparseFile(t *testing.T, path string, size int64, data []byte, off int64) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(data, off); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := f.Stat(); err != nil {
+		t.Fatal(err)
+	} else if allocated(st) >= size {
+		t.Skip("the filesystem doesn't keep files sparse")
+	}
+}
+
+// TestSparseToFull switches a sparse file from an earlier run to full
+// allocation, through fallocate where the platform has it and through the
+// zero-filling fallback.
+func TestSparseToFull(t *testing.T) {
+	const size = 8 << 20
+	data := bytes.Repeat([]byte("downloaded"), 1000)
+	const off = 3<<20 + 5
+
+	for _, tt := range []struct {
+		name     string
+		allocate func(t *testing.T, dir string) error
+	}{
+		{"preallocate", func(t *testing.T, dir string) error {
+			s, err := New(dir, []File{{Path: "a", Length: size}}, Options{Allocation: AllocFull})
+			if err != nil {
+				return err
+			}
+			return s.Close()
+		}},
+		{"zeroFill", func(t *testing.T, dir string) error {
+			f, err := os.OpenFile(filepath.Join(dir, "a"), os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return zeroFill(f, size)
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "a")
+			sparseFile(t, path, size, data, off)
+			if err := tt.allocate(t, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			st, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Size() != size || allocated(st) < size {
+				t.Errorf("%d of %d bytes allocated after full allocation", allocated(st), st.Size())
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got[off:off+len(data)], data) {
+				t.Error("the downloaded bytes did not survive")
+			}
+			if bytes.ContainsFunc(got[:off], func(r rune) bool { return r != 0 }) {
+				t.Error("the hole before the data reads as non-zero")
+			}
+		})
+	}
+}
+
+// TestCheckFreeSpaceSparse checks that the holes of an existing sparse file
+// are still counted as needed: its size says nothing about the disk it holds.
+func TestCheckFreeSpaceSparse(t *testing.T) {
+	dir := t.TempDir()
+	avail, err := freeSpace(dir)
+	if errors.Is(err, errFreeSpaceUnknown) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := avail + 1<<30
+	sparseFile(t, filepath.Join(dir, "big"), size, []byte{1}, 0)
+	if err := CheckFreeSpace(dir, []File{{Path: "big", Length: size}}); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("CheckFreeSpace for a sparse file larger than the disk: %v", err)
+	}
+
+	// A file that is fully written needs nothing more, however big.
+	if err := os.WriteFile(filepath.Join(dir, "small"), make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckFreeSpace(dir, []File{{Path: "small", Length: 1 << 20}}); err != nil {
+		t.Errorf("CheckFreeSpace for a complete file: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes for f using fallocate(2). Filesystems that
+// don't support it (tmpfs on old kernels, some network mounts) fall back to
+// writing zeros.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return zeroFill(f, size)
+	}
+	return err
+}
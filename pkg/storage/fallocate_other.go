@@ -0,0 +1,11 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// preallocate reserves size bytes for f. Without fallocate(2) the only portable
+// way to make the filesystem hand out real blocks is to write them.
+func preallocate(f *os.File, size int64) error {
+	return zeroFill(f, size)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Move relocates every file created so far, and the parts file, from the
+// storage directory to dir, dropping the incomplete suffix on the way.
+// Within one filesystem each file is renamed atomically; across filesystems
+// it is copied and the original removed. The files are reopened at their new
+// location, so reads and writes keep working after Move returns.
+//
+// When a file can't be moved, the ones already moved are put back and
+// everything is reopened where it was. Should that fail as well, the Storage
+// refuses every further read and write rather than sending them elsewhere.
+func (s *Storage) Move(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	oldDir, oldSuffix := s.dir, s.suffix
+	from := func(f *file) string { return filepath.Join(oldDir, f.Path+oldSuffix) }
+	to := func(f *file) string { return filepath.Join(dir, f.Path) }
+
+	var open []*file
+	var err error
+	for _, f := range s.files {
+		if f.f == nil {
+			continue
+		}
+		open = append(open, f)
+		if cerr := f.f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		f.f = nil
+	}
+	moved := 0
+	for ; err == nil && moved < len(open); moved++ {
+		if merr := moveFile(from(open[moved]), to(open[moved])); merr != nil {
+			err = fmt.Errorf("could not move %s: %v", open[moved].Path, merr)
+			break
+		}
+	}
+	if err != nil {
+		for _, f := range open[:moved] {
+			if merr := moveFile(to(f), from(f)); merr != nil {
+				return s.fail(fmt.Errorf("%v, and could not move %s back: %v", err, f.Path, merr))
+			}
+		}
+		if rerr := s.reopen(open); rerr != nil {
+			return s.fail(fmt.Errorf("%v, and could not reopen the files: %v", err, rerr))
+		}
+		return err
+	}
+
+	for _, f := range open {
+		removeEmptyParents(filepath.Dir(from(f)), oldDir)
+	}
+	s.dir, s.suffix = dir, ""
+	if err := s.reopen(open); err != nil {
+		return s.fail(err)
+	}
+
+	if s.parts.f != nil {
+		rel, err := filepath.Rel(oldDir, s.parts.path)
+		if err != nil {
+			return err
+		}
+		if err := s.parts.move(filepath.Join(dir, rel)); err != nil {
+			err = fmt.Errorf("could not move parts file: %v", err)
+			if s.parts.f == nil {
+				return s.fail(err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// reopen opens files, which already exist, at their current path.
+func (s *Storage) reopen(files []*file) error {
+	for _, f := range files {
+		fh, err := os.OpenFile(s.path(f), os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		f.f = fh
+	}
+	return nil
+}
+
+// fail marks the storage unusable after files were lost track of, so that
+// writes can't silently land in the parts file instead, and returns why.
+func (s *Storage) fail(err error) error {
+	s.err = fmt.Errorf("storage unusable: %w", err)
+	return s.err
+}
+
+// rename is os.Rename, replaceable in tests to simulate moves across filesystems.
+var rename = os.Rename
+
+// moveFile renames from to to, copying instead when they sit on different filesystems.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	err := rename(from, to)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// Copy to a temporary name first so a crash never leaves a truncated file under the final name.
+	tmp := to + ".moving"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, to); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// removeEmptyParents deletes dir and its parents up to (not including) root while they are empty.
+func removeEmptyParents(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// moveStorage creates storage for a (100 bytes), sub/b (200) and a skipped c
+// (300) under dir with a ".part" suffix, and fills it with payload. Pieces
+// are 256 bytes, so piece 1 straddles b and c and parks c's share in the
+// parts file.
+func moveStorage(t *testing.T, dir string) (*Storage, []byte) {
+	t.Helper()
+	files := []File{{Path: "a", Length: 100}, {Path: "sub/b", Length: 200}, {Path: "c", Length: 300}}
+	s, err := New(dir, files, Options{PieceLength: 256, Skip: []bool{false, false, true}, Suffix: ".part"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	payload := make([]byte, 512)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	if _, err := s.WriteAt(payload, 0); err != nil {
+		t.Fatal(err)
+	}
+	return s, payload
+}
+
+// checkMoved checks that the files, and only them, sit under dir with the
+// given suffix and that reads and writes reach them there. The write test
+// leaves its bytes in payload.
+func checkMoved(t *testing.T, s *Storage, dir, suffix string, payload []byte) {
+	t.Helper()
+	if s.Dir() != dir {
+		t.Errorf("Dir() = %s, want %s", s.Dir(), dir)
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"a", payload[:100]}, {"sub/b", payload[100:300]}} {
+		got, err := os.ReadFile(filepath.Join(dir, f.name+suffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, f.data) {
+			t.Errorf("%s holds the wrong bytes", f.name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c"+suffix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the skipped file exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".parts")); err != nil {
+		t.Errorf("the parts file is not in %s: %v", dir, err)
+	}
+
+	got := make([]byte, len(payload))
+	if _, err := s.ReadAt(got, 0); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("reading back the payload: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("new"), 150); err != nil {
+		t.Fatal(err)
+	}
+	copy(payload[150:], "new")
+	b, err := os.ReadFile(filepath.Join(dir, "sub/b"+suffix))
+	if err != nil || string(b[50:53]) != "new" {
+		t.Errorf("a write after Move did not reach %s: %v", filepath.Join(dir, "sub/b"+suffix), err)
+	}
+}
+
+// crossDevice makes every rename except the last one of a copy fail as if
+// the paths were on different filesystems, for the duration of the test.
+func crossDevice(t *testing.T) {
+	rename = func(from, to string) error {
+		if strings.HasSuffix(from, ".moving") {
+			return os.Rename(from, to)
+		}
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { rename = os.Rename })
+}
+
+func TestMove(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"rename", func(t *testing.T) {}},
+		{"copy", crossDevice},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			root := t.TempDir()
+			from, to := filepath.Join(root, "incomplete"), filepath.Join(root, "complete")
+			s, payload := moveStorage(t, from)
+			if err := s.Move(to); err != nil {
+				t.Fatal(err)
+			}
+			checkMoved(t, s, to, "", payload)
+			if entries, err := os.ReadDir(from); err != nil || len(entries) != 0 {
+				t.Errorf("left behind in the old directory: %v, %v", entries, err)
+			}
+			if matches, _ := filepath.Glob(filepath.Join(to, "*.moving")); len(matches) > 0 {
+				t.Errorf("temporary copies left behind: %v", matches)
+			}
+		})
+	}
+}
+
+// TestMoveFailure makes the second file impossible to move and checks that
+// the first one comes back and the storage carries on in the old directory.
+func TestMoveFailure(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		setup    func(t *testing.T)
+		obstacle string // a non-empty directory blocking the move of sub/b
+	}{
+		{"rename", func(t *testing.T) {}, "sub/b"},
+		{"copy", crossDevice, "sub/b.moving"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			root := t.TempDir()
+			from, to := filepath.Join(root, "incomplete"), filepath.Join(root, "complete")
+			s, payload := moveStorage(t, from)
+			if err := os.MkdirAll(filepath.Join(to, tt.obstacle, "x"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Move(to); err == nil {
+				t.Fatal("Move succeeded over a directory in the way")
+			}
+			checkMoved(t, s, from, ".part", payload)
+			if _, err := os.Stat(filepath.Join(to, "a")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("a was not moved back: %v", err)
+			}
+
+			// Once the way is clear, the move goes through.
+			if err := os.RemoveAll(filepath.Join(to, strings.Split(tt.obstacle, "/")[0])); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Move(to); err != nil {
+				t.Fatal(err)
+			}
+			checkMoved(t, s, to, "", payload)
+		})
+	}
+}
+
+// TestMoveUnrecoverable fails both the move and the way back, after which
+// the storage must refuse reads and writes instead of misplacing them.
+func TestMoveUnrecoverable(t *testing.T) {
+	root := t.TempDir()
+	from, to := filepath.Join(root, "incomplete"), filepath.Join(root, "complete")
+	s, _ := moveStorage(t, from)
+	rename = func(old, new string) error {
+		if strings.HasPrefix(old, from) && strings.HasSuffix(old, "a.part") {
+			return os.Rename(old, new)
+		}
+		return &os.LinkError{Op: "rename", Old: old, New: new, Err: syscall.EACCES}
+	}
+	t.Cleanup(func() { rename = os.Rename })
+
+	if err := s.Move(to); err == nil {
+		t.Fatal("Move succeeded")
+	}
+	if _, err := s.WriteAt([]byte{1}, 0); err == nil {
+		t.Error("WriteAt succeeded after the files were lost track of")
+	}
+	if _, err := s.ReadAt(make([]byte, 1), 0); err == nil {
+		t.Error("ReadAt succeeded after the files were lost track of")
+	}
+	if err := s.SetSkip(2, false); err == nil {
+		t.Error("SetSkip succeeded after the files were lost track of")
+	}
+	if err := s.Move(to); err == nil {
+		t.Error("a second Move succeeded")
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// partsFile holds the bytes of boundary pieces that belong to skipped files.
+// A piece overlapping both a wanted and a skipped file still has to be
+// downloaded and hashed in full, but the skipped half must not create the
+// skipped file. Each such piece gets a piece-sized slot in the parts file.
+type partsFile struct {
+	path        string
+	pieceLength int64
+	f           *os.File
+	slots       map[int]int64 // piece index -> slot number
+}
+
+func newPartsFile(path string, pieceLength int64) *partsFile {
+	return &partsFile{path: path, pieceLength: pieceLength, slots: map[int]int64{}}
+}
+
+// writeAt stores b, which starts at payload offset off, in the slots of the pieces it covers.
+func (p *partsFile) writeAt(b []byte, off int64) (int, error) {
+	if p.f == nil {
+		f, err := os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return 0, fmt.Errorf("could not create parts file: %v", err)
+		}
+		p.f = f
+	}
+	return p.each(b, off, func(piece int, chunk []byte, within int64) (int, error) {
+		slot, ok := p.slots[piece]
+		if !ok {
+			slot = int64(len(p.slots))
+			p.slots[piece] = slot
+		}
+		return p.f.WriteAt(chunk, slot*p.pieceLength+within)
+	})
+}
+
+// readAt reads back bytes previously stored with writeAt.
+func (p *partsFile) readAt(b []byte, off int64) (int, error) {
+	return p.each(b, off, func(piece int, chunk []byte, within int64) (int, error) {
+		slot, ok := p.slots[piece]
+		if !ok || p.f == nil {
+			return 0, fmt.Errorf("piece %d is not in the parts file", piece)
+		}
+		return p.f.ReadAt(chunk, slot*p.pieceLength+within)
+	})
+}
+
+// has reports whether any bytes of piece are stored in the parts file.
+func (p *partsFile) has(piece int) bool {
+	_, ok := p.slots[piece]
+	return ok
+}
+
+func (p *partsFile) each(b []byte, off int64, fn func(piece int, chunk []byte, within int64) (int, error)) (int, error) {
+	done := 0
+	for done < len(b) {
+		piece := int(off / p.pieceLength)
+		within := off % p.pieceLength
+		n := min(int64(len(b)-done), p.pieceLength-within)
+		m, err := fn(piece, b[done:done+int(n)], within)
+		done += m
+		off += int64(m)
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// close closes and deletes the parts file. The slot table only lives in memory,
+// so the bytes can't be found again after a restart anyway.
+func (p *partsFile) close() error {
+	if p.f == nil {
+		return nil
+	}
+	err := p.f.Close()
+	p.f = nil
+	p.slots = map[int]int64{}
+	if rmErr := os.Remove(p.path); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// move renames the parts file to path and reopens it there. On failure it
+// stays open at its old path when it can.
+func (p *partsFile) move(path string) error {
+	if p.f != nil {
+		if err := p.f.Close(); err != nil {
+			return err
+		}
+		p.f = nil
+	}
+	if err := moveFile(p.path, path); err != nil {
+		// Keep using it where it is.
+		if f, rerr := os.OpenFile(p.path, os.O_RDWR, 0); rerr == nil {
+			p.f = f
+		}
+		return err
+	}
+	p.path = path
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	p.f = f
+	return nil
+}
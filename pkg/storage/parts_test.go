@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSkipParts writes a boundary piece that half belongs to a skipped file,
+// then unskips the file, using the layout of moveStorage: piece 1 covers
+// bytes 256-511, of which 300-511 are c's.
+func TestSkipParts(t *testing.T) {
+	dir := t.TempDir()
+	s, payload := moveStorage(t, dir)
+	c := filepath.Join(dir, "c.part")
+	if _, err := os.Stat(c); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the skipped file was created: %v", err)
+	}
+	if st, err := os.Stat(filepath.Join(dir, ".parts")); err != nil || st.Size() < 256 {
+		t.Fatalf("the parts file does not hold a piece slot: %v", err)
+	}
+	got := make([]byte, 212)
+	if _, err := s.ReadAt(got, 300); err != nil || !bytes.Equal(got, payload[300:]) {
+		t.Fatalf("c's share of piece 1 read back from the parts file: %v", err)
+	}
+
+	if err := s.SetSkip(2, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 300 || !bytes.Equal(data[:212], payload[300:]) || bytes.ContainsFunc(data[212:], func(r rune) bool { return r != 0 }) {
+		t.Errorf("after unskipping, c holds %d bytes starting %x", len(data), data[:8])
+	}
+
+	// The file now takes its own writes, and skipping it again keeps it.
+	if _, err := s.WriteAt([]byte("tail"), 596); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSkip(2, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(c); err != nil || string(data[296:]) != "tail" {
+		t.Errorf("a write to the unskipped file did not reach it: %v", err)
+	}
+}
+
+// TestSkipOnly checks that a payload made only of skipped files creates
+// nothing at all.
+func TestSkipOnly(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, []File{{Path: "a", Length: 10}, {Path: "b", Length: 10}}, Options{PieceLength: 16, Skip: []bool{true, true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("created %v for skipped files", entries)
+	}
+}
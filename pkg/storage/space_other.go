@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+import "os"
+
+// freeSpace is not implemented on this platform. CheckFreeSpace treats
+// errFreeSpaceUnknown as "don't know" and lets the download go ahead.
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnknown
+}
+
+// allocated can't tell holes from data on this platform and takes the file's
+// size as allocated.
+func allocated(st os.FileInfo) int64 {
+	return st.Size()
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File describes one file of the torrent payload, relative to the storage directory.
+type File struct {
+	Path   string
+	Length int64
+	// Padding marks the filler that aligns the next file to a piece boundary
+	// (BEP 47 pad files, and the implicit gaps of v2 torrents). It is never
+	// created on disk: writes are dropped and reads return zeros.
+	Padding bool
+}
+
+// ErrInsufficientSpace is returned by New and CheckFreeSpace when the target
+// filesystem can't hold the remaining payload.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+var errFreeSpaceUnknown = errors.New("free space unknown on this platform")
+
+// Options configures how New lays the payload out on disk.
+type Options struct {
+	// Allocation decides between sparse files and full preallocation.
+	Allocation AllocationMode
+	// PieceLength is the torrent's piece size. It's only needed when some
+	// files are skipped, to size the slots of the parts file.
+	PieceLength int64
+	// Skip marks files that must never be created. Bytes of boundary pieces
+	// that fall into them go to the parts file instead.
+	Skip []bool
+	// PartsPath is where the parts file lives, relative to the storage directory.
+	PartsPath string
+	// Suffix is appended to every file name until Move is called, e.g. ".part",
+	// so other tools can tell the files aren't complete yet.
+	Suffix string
+}
+
+// Storage maps the torrent's contiguous payload (all files back to back, the
+// way pieces are hashed) onto the files on disk.
+type Storage struct {
+	mu     sync.Mutex
+	dir    string
+	suffix string
+	mode   AllocationMode
+	files  []*file
+	size   int64
+	parts  *partsFile
+	err    error // set once a failed Move leaves files unaccounted for
+}
+
+type file struct {
+	File
+	offset int64    // position of the file's first byte in the payload
+	f      *os.File // nil while the file is skipped and has never been wanted
+}
+
+// New creates (or reopens) every wanted file under dir and lays them out according to opts.
+// The free-space check runs first so a full disk is reported before any file is touched.
+func New(dir string, files []File, opts Options) (*Storage, error) {
+	var wanted []File
+	for i, fi := range files {
+		if !skipped(opts.Skip, i) && !fi.Padding {
+			wanted = append(wanted, File{Path: fi.Path + opts.Suffix, Length: fi.Length})
+		}
+	}
+	if err := CheckFreeSpace(dir, wanted); err != nil {
+		return nil, err
+	}
+
+	partsPath := opts.PartsPath
+	if partsPath == "" {
+		partsPath = ".parts"
+	}
+	pieceLength := opts.PieceLength
+	if pieceLength <= 0 {
+		pieceLength = 1 << 20
+	}
+
+	s := &Storage{
+		dir:    dir,
+		suffix: opts.Suffix,
+		mode:   opts.Allocation,
+		parts:  newPartsFile(filepath.Join(dir, partsPath), pieceLength),
+	}
+	for _, fi := range files {
+		s.files = append(s.files, &file{File: fi, offset: s.size})
+		s.size += fi.Length
+	}
+
+	for i, f := range s.files {
+		if skipped(opts.Skip, i) || f.Padding {
+			continue
+		}
+		if err := s.open(f); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func skipped(skip []bool, i int) bool {
+	return i < len(skip) && skip[i]
+}
+
+// path returns where f lives on disk right now.
+func (s *Storage) path(f *file) string {
+	return filepath.Join(s.dir, f.Path+s.suffix)
+}
+
+func (s *Storage) open(f *file) error {
+	path := s.path(f)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not create file: %v", err)
+	}
+	f.f = fh
+
+	switch s.mode {
+	case AllocFull:
+		err = preallocate(fh, f.Length)
+	default:
+		// Truncate only ever grows the file here, so existing data survives a restart.
+		var st os.FileInfo
+		st, err = fh.Stat()
+		if err == nil && st.Size() < f.Length {
+			err = fh.Truncate(f.Length)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not allocate %s: %v", f.Path, err)
+	}
+	return nil
+}
+
+// SetSkip changes whether file i is skipped. Unskipping creates the file and
+// moves in whatever boundary-piece bytes were parked in the parts file.
+// Skipping a file that already exists leaves it alone: its bytes keep going to
+// the file, and nothing already written is lost.
+func (s *Storage) SetSkip(i int, skip bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < 0 || i >= len(s.files) {
+		return fmt.Errorf("file index %d out of range", i)
+	}
+	if s.err != nil {
+		return s.err
+	}
+	f := s.files[i]
+	if skip || f.f != nil || f.Padding {
+		return nil
+	}
+	if err := CheckFreeSpace(s.dir, []File{{Path: f.Path + s.suffix, Length: f.Length}}); err != nil {
+		return err
+	}
+	if err := s.open(f); err != nil {
+		return err
+	}
+
+	// Copy the file's share of every parked piece out of the parts file.
+	pl := s.parts.pieceLength
+	for piece := f.offset / pl; piece*pl < f.offset+f.Length; piece++ {
+		if !s.parts.has(int(piece)) {
+			continue
+		}
+		start := max(piece*pl, f.offset)
+		end := min((piece+1)*pl, f.offset+f.Length)
+		buf := make([]byte, end-start)
+		if _, err := s.parts.readAt(buf, start); err != nil {
+			return err
+		}
+		if _, err := f.f.WriteAt(buf, start-f.offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckFreeSpace fails with ErrInsufficientSpace when the filesystem holding dir
+// has less room than the files still need. Blocks already allocated by an
+// earlier run are not counted twice; the holes of a sparse file are.
+func CheckFreeSpace(dir string, files []File) error {
+	var need int64
+	for _, fi := range files {
+		need += fi.Length
+		if st, err := os.Stat(filepath.Join(dir, fi.Path)); err == nil {
+			need -= min(allocated(st), fi.Length)
+		}
+	}
+	if need <= 0 {
+		return nil
+	}
+
+	// Statfs needs an existing path, so walk up until we find one.
+	probe := dir
+	for {
+		if _, err := os.Stat(probe); err == nil {
+			break
+		}
+		parent := filepath.Dir(probe)
+		if parent == probe {
+			break
+		}
+		probe = parent
+	}
+
+	avail, err := freeSpace(probe)
+	if errors.Is(err, errFreeSpaceUnknown) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not check free space in %s: %v", dir, err)
+	}
+	if avail < need {
+		return fmt.Errorf("%w in %s: need %d bytes, %d available", ErrInsufficientSpace, dir, need, avail)
+	}
+	return nil
+}
+
+// WriteAt writes p at payload offset off, splitting it across file boundaries as needed.
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.span(p, off, func(f *file, b []byte, at int64) (int, error) {
+		if f.Padding {
+			return len(b), nil
+		}
+		if f.f == nil {
+			return s.parts.writeAt(b, f.offset+at)
+		}
+		return f.f.WriteAt(b, at)
+	})
+}
+
+// ReadAt reads len(p) bytes from payload offset off.
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.span(p, off, func(f *file, b []byte, at int64) (int, error) {
+		if f.Padding {
+			clear(b)
+			return len(b), nil
+		}
+		if f.f == nil {
+			return s.parts.readAt(b, f.offset+at)
+		}
+		return f.f.ReadAt(b, at)
+	})
+}
+
+// span walks the files overlapping [off, off+len(p)) and hands each one the slice of p that belongs to it.
+func (s *Storage) span(p []byte, off int64, fn func(f *file, b []byte, at int64) (int, error)) (int, error) {
+	if off < 0 || off+int64(len(p)) > s.size {
+		return 0, fmt.Errorf("range %d+%d outside payload of %d bytes", off, len(p), s.size)
+	}
+	done := 0
+	for _, f := range s.files {
+		if done == len(p) {
+			break
+		}
+		end := f.offset + f.Length
+		if off >= end || f.Length == 0 {
+			continue
+		}
+		chunk := p[done:min(len(p), done+int(end-off))]
+		n, err := fn(f, chunk, off-f.offset)
+		done += n
+		off += int64(n)
+		if err != nil && !(err == io.EOF && n == len(chunk)) {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// Dir returns the directory the files currently live in.
+func (s *Storage) Dir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dir
+}
+
+// Close closes every open file.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first error
+	for _, f := range s.files {
+		if f.f == nil {
+			continue
+		}
+		if err := f.f.Close(); err != nil && first == nil {
+			first = err
+		}
+		f.f = nil
+	}
+	if err := s.parts.close(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// zeroFill makes the filesystem allocate every block of f up to size by
+// writing them. It's the portable way to force block allocation when
+// fallocate isn't available. The holes of a sparse file need filling too, so
+// each chunk is read and written back: holes read as zeros, and bytes already
+// downloaded survive.
+func zeroFill(f *os.File, size int64) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() >= size && allocated(st) >= size {
+		return nil
+	}
+	buf := make([]byte, 1<<20)
+	for pos := int64(0); pos < size; {
+		chunk := buf[:min(int64(len(buf)), size-pos)]
+		n, err := f.ReadAt(chunk, pos)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(chunk[n:])
+		if _, err := f.WriteAt(chunk, pos); err != nil {
+			return err
+		}
+		pos += int64(len(chunk))
+	}
+	return nil
+}
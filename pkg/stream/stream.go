@@ -0,0 +1,79 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
+)
+
+// Handler serves the files of a torrent while it downloads. Each file is
+// available at its path inside the torrent (e.g. /ubuntu.iso or
+// /album/01.flac), and / lists them. Padding files are neither listed nor
+// served. Range requests move the torrent's
+// readahead window to the requested bytes, so a player seeking in a video
+// gets the pieces it needs before the rest of the swarm traffic.
+type Handler struct {
+	t *torrentfile.Torrent
+}
+
+// NewHandler returns an http.Handler streaming the files of t.
+func NewHandler(t *torrentfile.Torrent) *Handler {
+	return &Handler{t: t}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files := h.t.PayloadFiles()
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" || name == "." {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, f := range files {
+			if f.Padding {
+				continue
+			}
+			fmt.Fprintf(w, "/%s\t%d\n", filepath.ToSlash(f.Path), f.Length)
+		}
+		return
+	}
+
+	for i, f := range files {
+		// BEP 47 padding files are not part of the content.
+		if f.Padding || filepath.ToSlash(f.Path) != name {
+			continue
+		}
+		rd, err := h.t.NewFileReader(i)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Setting the type up front stops ServeContent from sniffing the first
+		// 512 bytes, which would block on the first piece for every request.
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		log.Printf("Streaming %s (%s) to %s", name, r.Header.Get("Range"), r.RemoteAddr)
+		http.ServeContent(w, r, name, time.Time{}, rd)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// ListenAndServe serves t on addr until the listener fails.
+func ListenAndServe(addr string, t *torrentfile.Torrent) error {
+	log.Printf("Streaming files over HTTP on %s", addr)
+	return http.ListenAndServe(addr, NewHandler(t))
+}
@@ -0,0 +1,35 @@
+package stream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/torrentfile"
+)
+
+// TestPaddingFiles checks that the BEP 47 padding files of a torrent are
+// neither listed nor served.
+func TestPaddingFiles(t *testing.T) {
+	h := NewHandler(&torrentfile.Torrent{
+		PieceLength: 16384,
+		Files: []storage.File{
+			{Path: "album/01.flac", Length: 10000},
+			{Path: ".pad/6384", Length: 6384, Padding: true},
+			{Path: "album/02.flac", Length: 20000},
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := "/album/01.flac\t10000\n/album/02.flac\t20000\n"; rec.Body.String() != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", rec.Body, want)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.pad/6384", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET of a padding file: status %d, want 404", rec.Code)
+	}
+}
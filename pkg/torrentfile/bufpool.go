@@ -0,0 +1,24 @@
+package torrentfile
+
+import "sync"
+
+// bufPool recycles piece buffers. A buffer goes from a worker, which reads
+// blocks into it, to the result loop, which writes it to storage and hands it
+// back; at full speed the same few buffers go round instead of a fresh piece
+// being allocated, and collected, for every piece downloaded.
+type bufPool struct {
+	pool sync.Pool
+}
+
+// get returns a buffer of n bytes. Its contents are garbage.
+func (p *bufPool) get(n int) []byte {
+	if b, ok := p.pool.Get().(*[]byte); ok && cap(*b) >= n {
+		return (*b)[:n]
+	}
+	return make([]byte, n)
+}
+
+// put returns a buffer to the pool. Nothing may use it afterwards.
+func (p *bufPool) put(b []byte) {
+	p.pool.Put(&b)
+}
@@ -0,0 +1,279 @@
+package torrentfile
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// ErrNoPeers is returned by Download when it has had nobody to download
+// from for noPeersTimeout: every peer failed too often or was banned, and
+// no source added new ones.
+var ErrNoPeers = errors.New("no peers left to download from")
+
+const (
+	// DefaultMaxPeers is the connection target when Torrent.MaxPeers is zero.
+	DefaultMaxPeers = 40
+	// DefaultMaxHalfOpen limits dials in flight when Torrent.MaxHalfOpen is
+	// zero. Many routers and some OSes choke on lots of half-open
+	// connections, and most dials to a tracker's peer list fail anyway.
+	DefaultMaxHalfOpen = 8
+
+	// retryDelay is the wait before the first retry of a failed peer; each
+	// further failure doubles it, up to maxRetryDelay.
+	retryDelay    = 30 * time.Second
+	maxRetryDelay = 30 * time.Minute
+	// maxFailures drops a peer from the pool after this many failures in a
+	// row.
+	maxFailures = 6
+	// noPeersTimeout is how long the pool may have no peer to try and no
+	// connection up before the download gives up. Until then peer sources
+	// have time to come up with new peers.
+	noPeersTimeout = 5 * time.Minute
+)
+
+// poolPeer is a peer of the pool together with how it has done so far.
+type poolPeer struct {
+	peer.Peer
+	infoHash [20]byte // of the swarm it was found in, for the handshake
+
+	busy     bool // being dialled or connected
+	failures int  // in a row; a connection that delivers resets it
+	retryAt  time.Time
+	pieces   int // verified pieces it delivered over all connections
+}
+
+// connManager keeps up to maxConns connections to the peers of a pool that
+// every peer source feeds. Of these, at most maxHalfOpen are dialling at a
+// time. Peers whose dial or connection fails are retried with exponential
+// backoff, and when a slot frees up, peers that delivered pieces before are
+// chosen over new ones.
+type connManager struct {
+	t           *Torrent
+	maxConns    int
+	maxHalfOpen int
+
+	// dial and download connect to a peer and download from it: they are
+	// t.dialPeer and t.downloadFrom, replaced in tests.
+	dial     func(p peer.Peer, infoHash [20]byte) (net.Conn, *peer.Handshake, error)
+	download func(p peer.Peer, conn net.Conn, res *peer.Handshake) int
+	noPeers  time.Duration
+
+	mu       sync.Mutex
+	pool     map[string]*poolPeer
+	active   int // connections, half-open ones included
+	halfOpen int
+	// stranded is since when there was no peer to try and no connection,
+	// or zero.
+	stranded time.Time
+
+	wake chan struct{}
+	done chan struct{}
+	// exhausted is closed once the pool has been stranded for noPeers.
+	exhausted chan struct{}
+}
+
+func newConnManager(t *Torrent, results chan *pieceResult) *connManager {
+	m := &connManager{
+		t:           t,
+		maxConns:    t.MaxPeers,
+		maxHalfOpen: t.MaxHalfOpen,
+		dial:        t.dialPeer,
+		noPeers:     noPeersTimeout,
+		pool:        map[string]*poolPeer{},
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		exhausted:   make(chan struct{}),
+	}
+	m.download = func(p peer.Peer, conn net.Conn, res *peer.Handshake) int {
+		return t.downloadFrom(p, conn, res, results)
+	}
+	if m.maxConns <= 0 {
+		m.maxConns = DefaultMaxPeers
+	}
+	if m.maxHalfOpen <= 0 {
+		m.maxHalfOpen = DefaultMaxHalfOpen
+	}
+	return m
+}
+
+// add puts peers of the swarm infoHash into the pool. Peers already in it
+// are skipped: a peer in both swarms of a hybrid torrent serves the same
+// pieces, so one connection is enough.
+func (m *connManager) add(peers []peer.Peer, infoHash [20]byte) {
+	m.mu.Lock()
+	for _, p := range peers {
+		if _, ok := m.pool[p.String()]; !ok {
+			m.pool[p.String()] = &poolPeer{Peer: p, infoHash: infoHash}
+		}
+	}
+	m.mu.Unlock()
+	m.poke()
+}
+
+func (m *connManager) poke() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run connects to peers until stop is called, filling free slots whenever a
+// connection ends, peers are added, or a backoff runs out.
+func (m *connManager) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		next := m.fill()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-m.done:
+			return
+		case <-m.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (m *connManager) stop() {
+	close(m.done)
+}
+
+// fill starts dials while there are free slots and peers to try. It returns
+// when the earliest backoff still running ends, or when the pool will count
+// as exhausted, zero if neither is pending.
+func (m *connManager) fill() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.active < m.maxConns && m.halfOpen < m.maxHalfOpen {
+		pp := m.best()
+		if pp == nil {
+			break
+		}
+		pp.busy = true
+		m.active++
+		m.halfOpen++
+		go m.connect(pp)
+	}
+
+	var next time.Time
+	now := time.Now()
+	for _, pp := range m.pool {
+		if !pp.busy && pp.retryAt.After(now) && (next.IsZero() || pp.retryAt.Before(next)) {
+			next = pp.retryAt
+		}
+	}
+
+	if m.active > 0 || m.candidates() > 0 {
+		m.stranded = time.Time{}
+		return next
+	}
+	if m.stranded.IsZero() {
+		m.stranded = now
+	}
+	if giveUp := m.stranded.Add(m.noPeers); now.Before(giveUp) {
+		if next.IsZero() || giveUp.Before(next) {
+			next = giveUp
+		}
+	} else {
+		select {
+		case <-m.exhausted:
+		default:
+			log.Printf("No peers to download from for %v, giving up", m.noPeers)
+			close(m.exhausted)
+		}
+	}
+	return next
+}
+
+// candidates counts the peers of the pool that aren't banned, backing off
+// or not. Callers must hold m.mu.
+func (m *connManager) candidates() int {
+	n := 0
+	for _, pp := range m.pool {
+		if !m.t.reputation.banned(pp.IP) {
+			n++
+		}
+	}
+	return n
+}
+
+// best picks the peer to connect to next: among those neither busy, banned
+// nor backing off, the one that delivered the most pieces, then the one that
+// failed the least. Callers must hold m.mu.
+func (m *connManager) best() *poolPeer {
+	now := time.Now()
+	var best *poolPeer
+	for _, pp := range m.pool {
+		if pp.busy || pp.retryAt.After(now) || m.t.reputation.banned(pp.IP) {
+			continue
+		}
+		if best == nil || pp.pieces > best.pieces ||
+			(pp.pieces == best.pieces && pp.failures < best.failures) {
+			best = pp
+		}
+	}
+	return best
+}
+
+// connect dials pp and downloads from it, then frees its slot.
+func (m *connManager) connect(pp *poolPeer) {
+	t := m.t
+	log.Printf("Connecting to peer: %s", pp.String())
+	conn, res, err := m.dial(pp.Peer, pp.infoHash)
+
+	m.mu.Lock()
+	m.halfOpen--
+	if err != nil {
+		m.active--
+		m.failed(pp)
+	}
+	m.mu.Unlock()
+	m.poke()
+	if err != nil {
+		log.Printf("Handshake failed with %s: %v", pp.String(), err)
+		t.misbehaved(pp.Peer, err)
+		return
+	}
+
+	pieces := m.download(pp.Peer, conn, res)
+
+	m.mu.Lock()
+	m.active--
+	pp.pieces += pieces
+	if pieces > 0 {
+		// A peer that delivered and went away is worth another try soon.
+		pp.busy, pp.failures, pp.retryAt = false, 0, time.Now().Add(retryDelay)
+	} else {
+		m.failed(pp)
+	}
+	m.mu.Unlock()
+	m.poke()
+}
+
+// failed frees pp's slot after a connection that got us nothing and sets
+// its backoff, or drops it from the pool once it has failed maxFailures
+// times in a row. Callers must hold m.mu.
+func (m *connManager) failed(pp *poolPeer) {
+	pp.busy = false
+	pp.failures++
+	if pp.failures >= maxFailures {
+		log.Printf("Giving up on peer %s after %d failures", pp.String(), pp.failures)
+		delete(m.pool, pp.String())
+		return
+	}
+	delay := min(retryDelay<<(pp.failures-1), maxRetryDelay)
+	pp.retryAt = time.Now().Add(delay)
+}
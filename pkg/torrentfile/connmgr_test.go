@@ -0,0 +1,226 @@
+package torrentfile
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// fakeDialer stands in for the network under a connManager. Dials fail
+// unless succeed is set, and wait for a token on release when it isn't
+// nil. Connections last until a token arrives on hangUp.
+type fakeDialer struct {
+	succeed bool
+	release chan struct{}
+	hangUp  chan struct{}
+
+	mu                   sync.Mutex
+	dials                int
+	dialing, maxDialing  int
+	connected, maxActive int
+}
+
+func (f *fakeDialer) dial(p peer.Peer, infoHash [20]byte) (net.Conn, *peer.Handshake, error) {
+	f.mu.Lock()
+	f.dials++
+	f.dialing++
+	f.maxDialing = max(f.maxDialing, f.dialing)
+	f.maxActive = max(f.maxActive, f.dialing+f.connected)
+	f.mu.Unlock()
+	if f.release != nil {
+		<-f.release
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dialing--
+	if !f.succeed {
+		return nil, nil, errors.New("connection refused")
+	}
+	f.connected++
+	a, b := net.Pipe()
+	b.Close()
+	return a, &peer.Handshake{}, nil
+}
+
+func (f *fakeDialer) download(p peer.Peer, conn net.Conn, res *peer.Handshake) int {
+	conn.Close()
+	if f.hangUp != nil {
+		<-f.hangUp
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected--
+	return 0
+}
+
+func (f *fakeDialer) stats() (dials, dialing, connected int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dials, f.dialing, f.connected
+}
+
+func newTestManager(maxConns, maxHalfOpen int, f *fakeDialer) *connManager {
+	m := newConnManager(&Torrent{MaxPeers: maxConns, MaxHalfOpen: maxHalfOpen}, nil)
+	m.dial, m.download = f.dial, f.download
+	return m
+}
+
+func testPeers(n int) []peer.Peer {
+	peers := make([]peer.Peer, n)
+	for i := range peers {
+		peers[i] = peer.Peer{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 6881}
+	}
+	return peers
+}
+
+// TestConnManagerBackoff fails every dial to a peer and checks that each
+// retry waits twice as long as the one before, until the peer is dropped.
+func TestConnManagerBackoff(t *testing.T) {
+	f := &fakeDialer{}
+	m := newTestManager(4, 4, f)
+	p := testPeers(1)[0]
+	m.add([]peer.Peer{p}, [20]byte{})
+
+	for failures := 1; failures <= maxFailures; failures++ {
+		m.fill()
+		eventually(t, "the dial fails", func() bool {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			pp := m.pool[p.String()]
+			return pp == nil || !pp.busy && pp.failures == failures
+		})
+		m.mu.Lock()
+		pp := m.pool[p.String()]
+		if failures == maxFailures {
+			m.mu.Unlock()
+			if pp != nil {
+				t.Fatalf("peer still in the pool after %d failures", failures)
+			}
+			break
+		}
+		delay := time.Until(pp.retryAt)
+		want := min(retryDelay<<(failures-1), maxRetryDelay)
+		m.mu.Unlock()
+		if delay > want || delay < want-time.Second {
+			t.Fatalf("retry %v after failure %d, want %v", delay, failures, want)
+		}
+
+		// Nothing is dialled while the backoff runs, and fill says when
+		// it ends.
+		if next := m.fill(); !next.Equal(pp.retryAt) {
+			t.Errorf("fill wakes up at %v, want the end of the backoff %v", next, pp.retryAt)
+		}
+		if dials, _, _ := f.stats(); dials != failures {
+			t.Fatalf("%d dials after %d failures: the backoff was ignored", dials, failures)
+		}
+		m.mu.Lock()
+		pp.retryAt = time.Now() // the backoff is over
+		m.mu.Unlock()
+	}
+}
+
+// TestConnManagerLimits holds every dial open and checks that no more than
+// maxHalfOpen are in flight, and no more than maxConns connections up, as
+// dials complete one by one.
+func TestConnManagerLimits(t *testing.T) {
+	const maxConns, maxHalfOpen = 5, 2
+	f := &fakeDialer{succeed: true, release: make(chan struct{}), hangUp: make(chan struct{})}
+	m := newTestManager(maxConns, maxHalfOpen, f)
+	m.add(testPeers(20), [20]byte{})
+	go m.run()
+	defer m.stop()
+	defer close(f.hangUp)
+
+	for connected := 0; connected < maxConns; connected++ {
+		eventually(t, "dials start", func() bool {
+			_, dialing, _ := f.stats()
+			return dialing == min(maxHalfOpen, maxConns-connected)
+		})
+		f.release <- struct{}{}
+		eventually(t, "the dial connects", func() bool {
+			_, _, c := f.stats()
+			return c == connected+1
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	dials, dialing, connected := f.stats()
+	if dials != maxConns || dialing != 0 || connected != maxConns {
+		t.Errorf("%d dials, %d in flight and %d connected with every slot taken", dials, dialing, connected)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxDialing > maxHalfOpen || f.maxActive > maxConns {
+		t.Errorf("up to %d dials in flight and %d connections, want at most %d and %d", f.maxDialing, f.maxActive, maxHalfOpen, maxConns)
+	}
+}
+
+// TestConnManagerPreference checks the order in which free slots go to
+// peers: those that delivered pieces first, then those that failed least,
+// and banned peers never.
+func TestConnManagerPreference(t *testing.T) {
+	f := &fakeDialer{}
+	m := newTestManager(1, 1, f)
+	peers := testPeers(4)
+	m.add(peers, [20]byte{})
+	m.mu.Lock()
+	m.pool[peers[0].String()].failures = 3
+	m.pool[peers[1].String()].failures = 1
+	m.pool[peers[2].String()].pieces = 5
+	m.pool[peers[2].String()].failures = 2
+	m.t.reputation.ban(peers[3].IP.String())
+	var order []string
+	for pp := m.best(); pp != nil; pp = m.best() {
+		order = append(order, pp.String())
+		pp.busy = true
+	}
+	m.mu.Unlock()
+
+	want := []string{peers[2].String(), peers[1].String(), peers[0].String()}
+	if len(order) != len(want) {
+		t.Fatalf("peers picked in order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("peers picked in order %v, want %v", order, want)
+		}
+	}
+}
+
+// TestConnManagerExhausted checks that a pool whose last peer is dropped
+// gives up after noPeers, and that one which gets a new peer in time
+// doesn't.
+func TestConnManagerExhausted(t *testing.T) {
+	f := &fakeDialer{}
+	m := newTestManager(4, 4, f)
+	m.noPeers = 100 * time.Millisecond
+	p := testPeers(1)[0]
+	m.add([]peer.Peer{p}, [20]byte{})
+	m.mu.Lock()
+	m.pool[p.String()].failures = maxFailures - 1
+	m.mu.Unlock()
+	go m.run()
+	defer m.stop()
+	select {
+	case <-m.exhausted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pool lost its last peer and the manager did not give up")
+	}
+
+	f = &fakeDialer{succeed: true, hangUp: make(chan struct{})}
+	defer close(f.hangUp)
+	m = newTestManager(4, 4, f)
+	m.noPeers = 300 * time.Millisecond
+	go m.run()
+	defer m.stop()
+	time.Sleep(100 * time.Millisecond)
+	m.add([]peer.Peer{p}, [20]byte{})
+	select {
+	case <-m.exhausted:
+		t.Fatal("gave up although a peer came in time")
+	case <-time.After(600 * time.Millisecond):
+	}
+}
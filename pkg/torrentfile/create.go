@@ -0,0 +1,205 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+// Piece lengths chosen by AutoPieceLength stay within these bounds.
+const (
+	MinPieceLength = 16 << 10
+	MaxPieceLength = 16 << 20
+)
+
+// Builder creates a .torrent file from a file or directory on disk.
+type Builder struct {
+	// Path is the file or directory to share. A directory produces a multi-file torrent.
+	Path string
+	// PieceLength is the piece size in bytes. Zero picks one with AutoPieceLength.
+	PieceLength int
+	// Trackers lists the announce URLs in tiers. The first URL becomes
+	// "announce"; all of them go into "announce-list" when there is more than one.
+	Trackers [][]string
+	// WebSeeds are HTTP/FTP URLs serving the same payload (BEP 19 "url-list").
+	WebSeeds     []string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time // zero leaves the field out
+	// Private sets the BEP 27 private flag, limiting peer discovery to the trackers.
+	Private bool
+	// Source is stored in the info dictionary so that cross-seeding the same
+	// payload on different private trackers yields different infohashes.
+	Source string
+	// Workers is the number of hashing goroutines. Zero uses every core.
+	Workers int
+}
+
+// AutoPieceLength picks a power-of-two piece size giving roughly 1500 pieces
+// for a payload of totalLength bytes, clamped to [MinPieceLength, MaxPieceLength].
+func AutoPieceLength(totalLength int64) int {
+	pl := MinPieceLength
+	for pl < MaxPieceLength && totalLength/int64(pl) > 1500 {
+		pl *= 2
+	}
+	return pl
+}
+
+// Build walks Path, hashes every piece and returns the bencoded metainfo.
+func (b *Builder) Build() ([]byte, error) {
+	root, err := filepath.Abs(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info := bencodeInfo{Name: filepath.Base(root), Source: b.Source}
+	if b.Private {
+		info.Private = 1
+	}
+
+	// files holds paths relative to dir, in the order they are hashed.
+	var files []storage.File
+	dir := filepath.Dir(root)
+	if st.IsDir() {
+		dir = root
+		files, err = walkFiles(root)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s contains no files", b.Path)
+		}
+		for _, f := range files {
+			info.Files = append(info.Files, bencodeFile{
+				Length: int(f.Length),
+				Path:   strings.Split(filepath.ToSlash(f.Path), "/"),
+			})
+		}
+	} else {
+		files = []storage.File{{Path: info.Name, Length: st.Size()}}
+		info.Length = int(st.Size())
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%s is empty", b.Path)
+	}
+	info.PieceLength = b.PieceLength
+	if info.PieceLength <= 0 {
+		info.PieceLength = AutoPieceLength(total)
+	}
+
+	pieces, err := hashPieces(newPayloadReader(dir, files), total, info.PieceLength, b.Workers)
+	if err != nil {
+		return nil, err
+	}
+	info.Pieces = string(pieces)
+
+	mi := bencodeTorrent{
+		Comment:   b.Comment,
+		CreatedBy: b.CreatedBy,
+		Info:      info,
+		URLList:   urlList(b.WebSeeds),
+	}
+	if !b.CreationDate.IsZero() {
+		mi.CreationDate = b.CreationDate.Unix()
+	}
+	mi.Announce, mi.AnnounceList = announceFields(b.Trackers)
+
+	return bencode.Marshal(mi)
+}
+
+// walkFiles lists the regular files below root in lexical order, relative to root.
+func walkFiles(root string) ([]storage.File, error) {
+	var files []storage.File
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, storage.File{Path: rel, Length: fi.Size()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].Path) < filepath.ToSlash(files[j].Path)
+	})
+	return files, err
+}
+
+// hashPieces SHA-1 hashes every piece of the payload on workers goroutines
+// and returns the concatenated digests. Pieces span file boundaries.
+func hashPieces(r *payloadReader, total int64, pieceLength, workers int) ([]byte, error) {
+	defer r.Close()
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	numPieces := int((total + int64(pieceLength) - 1) / int64(pieceLength))
+	out := make([]byte, numPieces*sha1.Size)
+
+	indexes := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+			for i := range indexes {
+				begin := int64(i) * int64(pieceLength)
+				n := min(int64(pieceLength), total-begin)
+				if _, err := r.ReadAt(buf[:n], begin); err != nil {
+					errs <- err
+					return
+				}
+				sum := sha1.Sum(buf[:n])
+				copy(out[i*sha1.Size:], sum[:])
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := 0; i < numPieces; i++ {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return out, err
+}
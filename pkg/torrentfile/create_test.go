@@ -0,0 +1,167 @@
+package torrentfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+)
+
+func TestAutoPieceLength(t *testing.T) {
+	for _, tt := range []struct {
+		total int64
+		want  int
+	}{
+		{1, MinPieceLength},
+		{1501*MinPieceLength - 1, MinPieceLength},
+		{1501 * MinPieceLength, 2 * MinPieceLength},
+		{700 << 20, 512 << 10},
+		{1 << 40, MaxPieceLength},
+	} {
+		if got := AutoPieceLength(tt.total); got != tt.want {
+			t.Errorf("AutoPieceLength(%d) = %d, want %d", tt.total, got, tt.want)
+		}
+	}
+}
+
+// writeTree creates the files under dir, each filled from testPayload.
+func writeTree(t *testing.T, dir string, files map[string]int) {
+	t.Helper()
+	for name, size := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, testPayload(size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestBuildRoundTrip creates torrents, parses them back and verifies the
+// payload they were made from against them.
+func TestBuildRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		files       map[string]int // below the shared path; "" is a single file
+		pieceLength int
+		want        []string // paths of FileList, in order
+	}{
+		{"single file", map[string]int{"": 100_000}, 32 << 10, []string{"shared"}},
+		{"auto piece length", map[string]int{"": 100_000}, 0, []string{"shared"}},
+		{
+			"directory",
+			map[string]int{"b.bin": 40_000, "a/z.txt": 1, "a/b/c.dat": 70_000, "c": 16 << 10},
+			16 << 10,
+			[]string{"shared/a/b/c.dat", "shared/a/z.txt", "shared/b.bin", "shared/c"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "shared")
+			if _, single := tt.files[""]; single {
+				if err := os.WriteFile(path, testPayload(tt.files[""]), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				writeTree(t, path, tt.files)
+			}
+
+			b := &Builder{
+				Path:         path,
+				PieceLength:  tt.pieceLength,
+				Trackers:     [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}},
+				WebSeeds:     []string{"http://seed/shared"},
+				Comment:      "comment",
+				CreatedBy:    "test",
+				CreationDate: time.Unix(1700000000, 0),
+				Private:      true,
+				Source:       "SRC",
+				Workers:      3,
+			}
+			data, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bencode.Valid(data); err != nil {
+				t.Fatalf("Build wrote non-canonical bencode: %v", err)
+			}
+			bto, err := Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bto.Announce != "http://a/announce" || !slices.EqualFunc(bto.AnnounceList, b.Trackers, slices.Equal) {
+				t.Errorf("trackers %q and %q", bto.Announce, bto.AnnounceList)
+			}
+			if !slices.Equal(bto.URLList, b.WebSeeds) || bto.Comment != b.Comment || bto.CreatedBy != b.CreatedBy || bto.CreationDate != 1700000000 {
+				t.Errorf("top-level fields lost: %+v", bto)
+			}
+			if bto.Info.Private != 1 || bto.Info.Source != "SRC" || bto.Info.Name != "shared" {
+				t.Errorf("info fields lost: private %d, source %q, name %q", bto.Info.Private, bto.Info.Source, bto.Info.Name)
+			}
+			var got []string
+			total := 0
+			for _, f := range bto.FileList() {
+				got = append(got, filepath.ToSlash(f.Path))
+				total += int(f.Length)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files %q, want %q", got, tt.want)
+			}
+			want := tt.pieceLength
+			if want == 0 {
+				want = AutoPieceLength(int64(total))
+			}
+			if bto.Info.PieceLength != want {
+				t.Errorf("piece length %d, want %d", bto.Info.PieceLength, want)
+			}
+
+			to, err := bto.Torrent()
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := to.Verify(path, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK() || report.Pieces != (total+bto.Info.PieceLength-1)/bto.Info.PieceLength {
+				t.Fatalf("Verify of the source payload: %+v", report)
+			}
+
+			// Flip a byte of the last file: exactly one piece goes bad.
+			last := filepath.Join(path, filepath.FromSlash(tt.want[len(tt.want)-1][len("shared"):]))
+			f, err := os.OpenFile(last, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteAt([]byte{0xff}, 0)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report, err = to.Verify(path, 2); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Corrupt) != 1 {
+				t.Errorf("corrupt pieces %v after flipping one byte, want one", report.Corrupt)
+			}
+		})
+	}
+}
+
+func TestBuildEmpty(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (&Builder{Path: dir}).Build(); err == nil {
+		t.Error("built a torrent of an empty directory")
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Builder{Path: empty}).Build(); err == nil {
+		t.Error("built a torrent of an empty file")
+	}
+}
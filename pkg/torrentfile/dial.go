@@ -0,0 +1,132 @@
+package torrentfile
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/mse"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/utp"
+)
+
+// dialPeer connects to p and exchanges BitTorrent handshakes for infoHash,
+// encrypting the connection as t.Encryption says. Under mse.PolicyPrefer a
+// peer that fails the MSE handshake, which is what peers without MSE do, is
+// dialled again in plaintext.
+func (t *Torrent) dialPeer(p peer.Peer, infoHash [20]byte) (net.Conn, *peer.Handshake, error) {
+	if t.Encryption != mse.PolicyDisabled {
+		conn, res, err := t.dialEncrypted(p, infoHash)
+		if err == nil || t.Encryption == mse.PolicyRequire {
+			return conn, res, err
+		}
+		log.Printf("Encrypted handshake with %s failed, retrying in plaintext: %v", p.String(), err)
+	}
+
+	conn, err := t.dialTransport(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+	res, err := t.handshake(conn, infoHash)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, res, nil
+}
+
+func (t *Torrent) dialEncrypted(p peer.Peer, infoHash [20]byte) (net.Conn, *peer.Handshake, error) {
+	conn, err := t.dialTransport(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+	ec, err := mse.Dial(conn, infoHash, t.Encryption)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	res, err := t.handshake(ec, infoHash)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ec, res, nil
+}
+
+// dialTransport connects to p over TCP and, when t.UTP is set, over uTP at
+// the same time. The first connection to come up is kept and the other one
+// is closed as soon as it arrives.
+func (t *Torrent) dialTransport(p peer.Peer) (net.Conn, error) {
+	const timeout = 5 * time.Second
+	if !t.UTP {
+		return net.DialTimeout("tcp", p.String(), timeout)
+	}
+
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialed, 2)
+	go func() {
+		conn, err := net.DialTimeout("tcp", p.String(), timeout)
+		results <- dialed{conn, err}
+	}()
+	go func() {
+		conn, err := utp.DialTimeout(p.String(), timeout)
+		results <- dialed{conn, err}
+	}()
+
+	var errs []error
+	for range 2 {
+		d := <-results
+		if d.err != nil {
+			errs = append(errs, d.err)
+			continue
+		}
+		if len(errs) == 0 {
+			// The loser may still connect; close it when it does.
+			go func() {
+				if late := <-results; late.err == nil {
+					late.conn.Close()
+				}
+			}()
+		}
+		return d.conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// handshake sends our BitTorrent handshake on conn and reads the peer's,
+// which must be for the same torrent.
+func (t *Torrent) handshake(conn net.Conn, infoHash [20]byte) (*peer.Handshake, error) {
+	hs := peer.Handshake{
+		Pstr:     "BitTorrent protocol",
+		InfoHash: infoHash,
+		PeerID:   t.PeerId,
+	}
+	hs.Reserved[5] |= peer.ReservedExtension
+	hs.Reserved[7] |= peer.ReservedFast
+	if len(t.V2Files) > 0 {
+		hs.Reserved[7] |= peer.ReservedV2
+	}
+	if _, err := conn.Write(hs.Serialize()); err != nil {
+		return nil, fmt.Errorf("sending handshake: %w", err)
+	}
+	res, err := peer.ReadHandshake(conn)
+	if err != nil {
+		return nil, fmt.Errorf("reading handshake: %w", err)
+	}
+	if res.Pstr != hs.Pstr {
+		return nil, peer.ProtocolErrorf("unknown protocol %q", res.Pstr)
+	}
+	if res.InfoHash != infoHash {
+		return nil, peer.ProtocolErrorf("handshake for info hash %x, want %x", res.InfoHash, infoHash)
+	}
+	return res, nil
+}
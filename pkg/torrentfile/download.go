@@ -3,29 +3,126 @@ package torrentfile
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/jyotishmoy12/bittorrent-go/pkg/mse"
 	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
 )
 
 type Torrent struct {
-	Peers       []peer.Peer
-	PeerId      [20]byte
-	InfoHash    [20]byte
+	Peers    []peer.Peer
+	PeerId   [20]byte
+	InfoHash [20]byte
+	// InfoHashV2 is the truncated v2 infohash of a hybrid torrent, whose
+	// metadata is shared by two swarms. PeersV2 were found by announcing it
+	// and are handshaken with it; Peers and InfoHash belong to the v1 swarm.
+	InfoHashV2  [20]byte
+	PeersV2     []peer.Peer
 	PieceHashes [][20]byte
+	// V2Files holds the merkle trees of a BitTorrent v2 torrent. When set,
+	// pieces without a v1 hash are verified against them, and Files must use
+	// the piece-aligned layout from FileList.
+	V2Files     []V2File
 	PieceLength int
 	Length      int
 	Name        string
+	// Files lists the payload files in hash order. Nil means a single file called Name.
+	Files []storage.File
+	// FilePriorities holds one Priority per file. Nil means every file is PriorityNormal.
+	FilePriorities []Priority
+	// Allocation decides whether the output files are sparse or fully preallocated.
+	Allocation storage.AllocationMode
+	// IncompleteDir is where files are written while downloading. Empty means
+	// the working directory.
+	IncompleteDir string
+	// CompleteDir is where files are moved once every wanted piece is in.
+	// Empty means they stay in IncompleteDir.
+	CompleteDir string
+	// PartSuffix names files "<name>.part" until the download completes.
+	PartSuffix bool
+	// Sequential requests pieces in order, for playing a file while it downloads.
+	Sequential bool
+	// Readahead is how many bytes past a Reader's position are fetched first.
+	// Zero means DefaultReadahead.
+	Readahead int
+	// Private marks a BEP 27 private torrent: peers may only come from its
+	// trackers, never from DHT, PEX or local discovery. See AllowsSource.
+	Private bool
+	// Encryption is the MSE/PE policy for peer connections. The zero value
+	// only uses plain handshakes.
+	Encryption mse.Policy
+	// UTP dials every peer over uTP as well as TCP and keeps whichever
+	// connection comes up first.
+	UTP bool
+	// MaxPeers is how many peer connections Download keeps up, and
+	// MaxHalfOpen how many of them may be dialling at once. Zero means
+	// DefaultMaxPeers and DefaultMaxHalfOpen.
+	MaxPeers    int
+	MaxHalfOpen int
+	// BanThreshold is how many corrupt pieces an IP address may send
+	// before it is banned for the session. Zero means DefaultBanThreshold.
+	BanThreshold int
+
+	mu     sync.Mutex
+	picker *picker
+	store  *storage.Storage
+	wake   chan struct{} // pokes the result loop when the set of wanted pieces changes
+	ready  chan struct{} // closed once Download has set up picker and store
+
+	conns *connManager // set while Download runs
+
+	reputation reputation // penalties of misbehaving peers
+	blame      blame      // pieces that failed with data from several peers
+	timeouts   *timeouts  // of peer connections; nil means defaultTimeouts
+	bufs       bufPool    // piece buffers on their way from the workers to storage
 }
 
+// DefaultReadahead is the readahead window used when Torrent.Readahead is zero.
+const DefaultReadahead = 16 << 20
+
+// blockSize is the size of the blocks pieces are requested in.
+const blockSize = 16384
+
 type pieceWork struct {
 	index  int
-	hash   [20]byte
 	length int
+	// partial holds the blocks that arrived from peers that went away
+	// before the piece was complete, so whoever picks the piece up next
+	// only fetches the rest. Nil when there are none.
+	partial *pieceDownload
+}
+
+// pieceDownload is a piece on its way from peers: the buffer its blocks are
+// read into, which blocks arrived and who sent each of them.
+type pieceDownload struct {
+	*pieceWork
+	buf        []byte
+	next       int    // offset of the first block not yet considered for a request
+	have       []bool // by block
+	downloaded int
+	spans      []span // the blocks that arrived and their senders
+}
+
+// nextBlock returns the next block of d to request, skipping those that
+// already arrived, and false when every block was considered.
+func (d *pieceDownload) nextBlock() (block, bool) {
+	for d.next < d.length {
+		b := block{d.index, d.next, min(blockSize, d.length-d.next)}
+		d.next += b.length
+		if !d.have[b.begin/blockSize] {
+			return b, true
+		}
+	}
+	return block{}, false
 }
 
 type pieceResult struct {
@@ -33,170 +130,504 @@ type pieceResult struct {
 	buf   []byte
 }
 
-func (t *Torrent) startDownloadWorker(p peer.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
-	// Log the connection attempt
-	log.Printf("Connecting to peer: %s", p.String())
+// files returns the payload files, falling back to a single file called Name.
+func (t *Torrent) files() []storage.File {
+	if len(t.Files) > 0 {
+		return t.Files
+	}
+	return []storage.File{{Path: t.Name, Length: int64(t.Length)}}
+}
 
-	conn, err := net.DialTimeout("tcp", p.String(), 5*time.Second)
-	if err != nil {
-		return
+// numPieces counts the pieces of the payload. v2-only torrents have no flat
+// list of piece hashes, so the count comes from the length.
+func (t *Torrent) numPieces() int {
+	if len(t.PieceHashes) > 0 {
+		return len(t.PieceHashes)
 	}
-	defer conn.Close()
+	return (t.Length + t.PieceLength - 1) / t.PieceLength
+}
 
-	// 1. Handshake
-	hs := peer.Handshake{
-		Pstr:     "BitTorrent protocol",
-		InfoHash: t.InfoHash,
-		PeerID:   t.PeerId,
+// verifyPiece checks a downloaded piece against its v1 SHA-1 hash, or against
+// the v2 merkle tree when there is no v1 hash for it. Hybrid torrents have
+// both and CheckHybrid ensures they agree, so either one will do.
+func (t *Torrent) verifyPiece(index int, buf []byte) error {
+	if index < len(t.PieceHashes) {
+		hash := sha1.Sum(buf)
+		if !bytes.Equal(hash[:], t.PieceHashes[index][:]) {
+			return fmt.Errorf("expected %x, got %x", t.PieceHashes[index], hash)
+		}
+		return nil
 	}
-	_, err = conn.Write(hs.Serialize())
-	if err != nil {
-		log.Printf("Handshake failed with %s: %v", p.String(), err)
-		return
+	if len(t.V2Files) > 0 {
+		return t.verifyV2(index, buf)
 	}
+	return fmt.Errorf("no hash for piece %d", index)
+}
 
-	res, err := peer.ReadHandshake(conn)
-	if err != nil {
-		log.Printf("Error reading handshake from %s: %v", p.String(), err)
-		return
+// filePriority returns the priority of file i, defaulting to PriorityNormal.
+func (t *Torrent) filePriority(i int) Priority {
+	if i < len(t.FilePriorities) {
+		return t.FilePriorities[i]
 	}
-	log.Printf("Handshake successful with %s | PeerID: %x", p.String(), res.PeerID[:8])
-
-	// 2. Signal Interest
-	interested := peer.Message{ID: 2}
-	conn.Write(interested.Serialize())
+	return PriorityNormal
+}
 
-	// 3. Wait for Unchoke
-	log.Printf("Waiting for unchoke from %s...", p.String())
-	unchoked := false
-	for !unchoked {
-		msg, err := peer.Read(conn)
-		if err != nil {
-			log.Printf("Peer %s disconnected while waiting for unchoke", p.String())
-			return
-		}
-		if msg == nil {
+// piecePriorities gives every piece the highest priority of the files it overlaps.
+func (t *Torrent) piecePriorities() []Priority {
+	prios := make([]Priority, t.numPieces())
+	var offset int64
+	for i, f := range t.files() {
+		start, end := offset, offset+f.Length
+		offset = end
+		if f.Length == 0 || f.Padding {
 			continue
 		}
-		if msg.ID == 1 {
-			unchoked = true
-			log.Printf("Peer %s UNCHOKED us. Starting download...", p.String())
+		prio := t.filePriority(i)
+		for piece := int(start / int64(t.PieceLength)); piece < len(prios) && int64(piece)*int64(t.PieceLength) < end; piece++ {
+			prios[piece] = max(prios[piece], prio)
 		}
 	}
+	return prios
+}
 
-	// 4. Process work
-	for pw := range workQueue {
-		time.Sleep(1 * time.Second)
-		log.Printf("Requesting piece %d (%d bytes) from %s", pw.index, pw.length, p.String())
-
-		buf, err := attemptDownloadPiece(conn, pw)
-		if err != nil {
-			log.Printf("Download failed for piece %d from %s: %v", pw.index, p.String(), err)
-			workQueue <- pw
-			return
+// SetFilePriority changes the priority of file i. It may be called before
+// Download or while it runs; unskipping a file creates it on disk and makes
+// the scheduler request its pieces.
+func (t *Torrent) SetFilePriority(i int, p Priority) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	files := t.files()
+	if i < 0 || i >= len(files) {
+		return fmt.Errorf("file index %d out of range: torrent has %d files", i, len(files))
+	}
+	if len(t.FilePriorities) < len(files) {
+		prios := make([]Priority, len(files))
+		for j := range prios {
+			prios[j] = t.filePriority(j)
 		}
+		t.FilePriorities = prios
+	}
+	t.FilePriorities[i] = p
 
-		hash := sha1.Sum(buf)
-		if !bytes.Equal(hash[:], pw.hash[:]) {
-			log.Printf("Integrity Check Failed: Piece %d from %s. Expected %x, got %x", pw.index, p.String(), pw.hash, hash)
-			workQueue <- pw
-			continue
+	if t.store != nil {
+		if err := t.store.SetSkip(i, p == PrioritySkip); err != nil {
+			return err
+		}
+	}
+	if t.picker != nil {
+		t.picker.setPriorities(t.piecePriorities())
+		select {
+		case t.wake <- struct{}{}:
+		default:
 		}
+	}
+	return nil
+}
 
-		// Log success
-		log.Printf("Piece %d verified from %s", pw.index, p.String())
-		results <- &pieceResult{pw.index, buf}
+// readaheadPieces converts the readahead window from bytes to whole pieces.
+func (t *Torrent) readaheadPieces() int {
+	readahead := t.Readahead
+	if readahead <= 0 {
+		readahead = DefaultReadahead
 	}
+	return max(1, (readahead+t.PieceLength-1)/t.PieceLength)
 }
 
-func attemptDownloadPiece(c net.Conn, pw *pieceWork) ([]byte, error) {
-	c.SetDeadline(time.Now().Add(30 * time.Second))
-	defer c.SetDeadline(time.Time{})
+// readyChan returns the channel closed when Download has started. Callers must hold t.mu.
+func (t *Torrent) readyChan() chan struct{} {
+	if t.ready == nil {
+		t.ready = make(chan struct{})
+	}
+	return t.ready
+}
 
-	buf := make([]byte, pw.length)
-	var requested int
-	var downloaded int
-	const maxBacklog = 5
-	const blockSize = 16384
+// connTimeouts returns the time limits for the torrent's peer connections.
+func (t *Torrent) connTimeouts() timeouts {
+	if t.timeouts != nil {
+		return *t.timeouts
+	}
+	return defaultTimeouts
+}
 
-	for downloaded < pw.length {
-		// 1. PIPELINING: Keep 5 requests in flight
-		for requested < pw.length && (requested-downloaded) < maxBacklog*blockSize {
-			curBlockSize := blockSize
-			if pw.length-requested < curBlockSize {
-				curBlockSize = pw.length - requested
-			}
+// downloadFrom downloads pieces from a peer we finished the handshake res
+// with, until the peer has nothing more for us or the connection fails. It
+// returns the number of verified pieces the peer delivered.
+func (t *Torrent) downloadFrom(p peer.Peer, conn net.Conn, res *peer.Handshake, results chan *pieceResult) (pieces int) {
+	defer conn.Close()
+	log.Printf("Handshake successful with %s | PeerID: %x", p.String(), res.PeerID[:8])
+	pc := newPeerConn(conn, p.String(), t.numPieces(), res, t.connTimeouts())
+	defer pc.close()
+
+	// 2. Signal Interest, and tell a peer with the extension protocol who we
+	// are. We offer no extensions.
+	pc.send(peer.Interested{})
+	if pc.extensions {
+		pc.send(peer.ExtendedHandshake{V: clientVersion})
+	}
 
-			// FIXED: Using RequestMessage as defined in your peer package
-			msg := peer.RequestMessage(pw.index, requested, curBlockSize)
-			_, err := c.Write(msg.Serialize())
+	// 3. Process work. While choked we only read messages, except that with
+	// the fast extension allowed fast pieces can be requested right away.
+	// A peer that keeps us choked for unchokeTimeout, or leaves our requests
+	// unanswered for requestTimeout, is left for another.
+	log.Printf("Waiting for unchoke from %s...", p.String())
+	hasAllowedFast := t.trusted(p, pc.hasAllowedFast)
+	hasSuggested := t.trusted(p, pc.hasSuggested)
+	has := t.trusted(p, pc.has)
+	pick := func() *pieceWork {
+		if pc.choked {
+			return t.picker.tryNext(hasAllowedFast)
+		}
+		if pw := t.picker.tryNext(hasSuggested); pw != nil {
+			return pw
+		}
+		return t.picker.tryNext(has)
+	}
+	defer func() {
+		for _, d := range pc.pieces {
+			t.shelve(d)
+		}
+	}()
+	for {
+		if t.reputation.banned(p.IP) {
+			log.Printf("Disconnecting banned peer %s", p.String())
+			return
+		}
+		if err := t.fill(pc, p, pick); err != nil {
+			log.Printf("Requesting from %s failed: %v", p.String(), err)
+			t.misbehaved(p, err)
+			return
+		}
+		if len(pc.pieces) > 0 {
+			pc.starved = time.Time{}
+		}
+		if len(pc.pieces) == 0 && !pc.choked {
+			// Nothing to fetch from this peer right now. Keep reading what
+			// it sends, since a have may give it a piece we need, and look
+			// at the picker again every pickInterval for pieces other peers
+			// gave back.
+			if t.picker.stopped() {
+				return
+			}
+			if pc.starved.IsZero() {
+				pc.starved = time.Now()
+			} else if time.Since(pc.starved) >= pc.timeouts.starved {
+				log.Printf("Peer %s had nothing we need for %v, disconnecting", p.String(), pc.timeouts.starved)
+				return
+			}
+			ready, err := pc.wait(pc.timeouts.pick)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("Peer %s sent nothing for %v, disconnecting", p.String(), pc.timeouts.idle)
+				return
+			}
 			if err != nil {
-				return nil, err
+				log.Printf("Download from %s failed: %v", p.String(), err)
+				return
+			}
+			if !ready {
+				continue
 			}
-			requested += curBlockSize
 		}
 
-		// 2. RECEIVE: Read from wire
-		msg, err := peer.Read(c)
+		var deadline time.Time
+		switch {
+		case len(pc.requested) > 0:
+			deadline = pc.progress.Add(pc.timeouts.request)
+		case pc.choked:
+			deadline = pc.chokedAt.Add(pc.timeouts.unchoke)
+		}
+		pc.deadline = deadline
+		msg, err := pc.read()
+		pc.deadline = time.Time{}
+		wasChoked := pc.choked
+		var verified bool
+		if err == nil {
+			verified, err = t.process(pc, p, msg, results)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && !deadline.IsZero() && !time.Now().Before(deadline) {
+			if len(pc.requested) > 0 {
+				log.Printf("Peer %s answered none of our requests in %v, disconnecting", p.String(), pc.timeouts.request)
+			} else {
+				log.Printf("Peer %s did not unchoke us in %v, disconnecting", p.String(), pc.timeouts.unchoke)
+			}
+			return
+		}
 		if err != nil {
-			return nil, err
+			log.Printf("Download from %s failed: %v", p.String(), err)
+			t.misbehaved(p, err)
+			return
+		}
+		if wasChoked && !pc.choked {
+			log.Printf("Peer %s UNCHOKED us. Starting download...", p.String())
 		}
+		if verified {
+			pieces++
+		}
+	}
+}
 
-		if msg == nil || msg.ID != 7 { // 7 is MsgPiece
+// fill keeps as many requests in flight as the peer's link holds. Once the
+// pieces under way are all requested it starts on the next one from pick,
+// so the queue doesn't drain at piece boundaries and can grow past the
+// blocks of a single piece.
+func (t *Torrent) fill(pc *peerConn, p peer.Peer, pick func() *pieceWork) error {
+	for len(pc.requested) < pc.pipeline.depth() {
+		d := pc.unrequested()
+		if d == nil {
+			pw := pick()
+			if pw == nil {
+				return nil
+			}
+			if ok, err := t.start(pc, p, pw); !ok {
+				return err
+			}
 			continue
 		}
+		if b, ok := d.nextBlock(); ok {
+			if err := pc.request(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-		// 3. PARSE & COPY
-		begin, data, err := peer.ParsePiece(pw.index, buf, msg)
-		if err != nil {
-			return nil, err
+// start takes on piece pw. A v2 piece whose piece layer we lack needs the
+// layer fetched first, which has to wait until no blocks are in flight;
+// until then pw goes back to the picker and start reports false.
+func (t *Torrent) start(pc *peerConn, p peer.Peer, pw *pieceWork) (bool, error) {
+	if f := t.missingLayer(pw.index); f != nil {
+		if len(pc.requested) > 0 {
+			t.picker.requeue(pw)
+			return false, nil
+		}
+		log.Printf("Requesting piece layer of %s from %s", f.Path, p.String())
+		if err := t.fetchLayer(pc, f); err != nil {
+			t.picker.requeue(pw)
+			return false, fmt.Errorf("could not get piece layer of %s: %w", f.Path, err)
+		}
+	}
+	pc.dropSuggestion(pw.index)
+	d := pw.partial
+	if d != nil {
+		pw.partial = nil
+		log.Printf("Requesting the rest of piece %d (%d of %d bytes) from %s", pw.index, pw.length-d.downloaded, pw.length, p.String())
+	} else {
+		d = &pieceDownload{
+			pieceWork: pw,
+			buf:       t.bufs.get(pw.length),
+			have:      make([]bool, (pw.length+blockSize-1)/blockSize),
+		}
+		log.Printf("Requesting piece %d (%d bytes) from %s", pw.index, pw.length, p.String())
+	}
+	pc.pieces = append(pc.pieces, d)
+	return true, nil
+}
+
+// process applies a message from p to the connection and the pieces under
+// way. It reports whether the message completed a piece that verified and
+// went to results.
+func (t *Torrent) process(pc *peerConn, p peer.Peer, msg peer.Msg, results chan *pieceResult) (bool, error) {
+	piece, ok := msg.(peer.Piece)
+	if !ok {
+		if err := pc.handle(msg); err != nil {
+			return false, err
+		}
+		switch m := msg.(type) {
+		case peer.Reject:
+			if d := pc.download(m.Index); d != nil {
+				log.Printf("Piece %d from %s: %v", d.index, p.String(), errRejected)
+				t.release(pc, d)
+			}
+		case peer.Choke:
+			for _, d := range slices.Clone(pc.pieces) {
+				if !pc.canRequest(d.index) {
+					log.Printf("Piece %d from %s: %v", d.index, p.String(), errChoked)
+					t.release(pc, d)
+				}
+			}
 		}
+		return false, nil
+	}
 
-		copy(buf[begin:], data)
-		downloaded += len(data)
+	// A block answering a live request was read straight into the buffer
+	// of its piece by pc.dest.
+	live, err := pc.received(piece)
+	if err != nil || !live {
+		return false, err
+	}
+	d := pc.download(piece.Index)
+	d.have[piece.Begin/blockSize] = true
+	d.downloaded += len(piece.Block)
+	d.spans = append(d.spans, span{piece.Begin, len(piece.Block), p.IP.String()})
+	if d.downloaded < d.length {
+		return false, nil
 	}
-	return buf, nil
+	pc.remove(d)
+	return t.finish(p, d, results), nil
+}
+
+// release gives up on d: its outstanding requests are cancelled and the
+// piece goes back to the picker.
+func (t *Torrent) release(pc *peerConn, d *pieceDownload) {
+	pc.cancelPiece(d.index)
+	pc.remove(d)
+	t.shelve(d)
+}
+
+// shelve hands an unfinished piece back to the picker. The blocks that
+// arrived stay with it, to be completed by whichever peer picks it next.
+func (t *Torrent) shelve(d *pieceDownload) {
+	if d.downloaded == 0 {
+		t.bufs.put(d.buf)
+	} else {
+		d.next = 0
+		d.pieceWork.partial = d
+	}
+	t.picker.requeue(d.pieceWork)
+}
+
+// finish verifies a piece that has arrived in full and hands it to results.
+// It reports whether the piece was good.
+func (t *Torrent) finish(p peer.Peer, d *pieceDownload, results chan *pieceResult) bool {
+	if err := t.verifyPiece(d.index, d.buf); err != nil {
+		log.Printf("Integrity Check Failed: Piece %d from %s: %v", d.index, p.String(), err)
+		t.corrupted(d.index, t.blame.failed(d.index, d.buf, d.spans))
+		t.bufs.put(d.buf)
+		t.picker.requeue(d.pieceWork)
+		return false
+	}
+	t.corrupted(d.index, t.blame.verified(d.index, d.buf))
+
+	// Log success
+	log.Printf("Piece %d verified from %s", d.index, p.String())
+	results <- &pieceResult{d.index, d.buf}
+	return true
 }
 
 func (t *Torrent) Download() error {
 	log.Printf("Starting download for %s (Total size: %d bytes)...", t.Name, t.Length)
-	out, err := os.Create(t.Name)
+
+	t.mu.Lock()
+	files := t.files()
+	skip := make([]bool, len(files))
+	for i := range files {
+		skip[i] = t.filePriority(i) == PrioritySkip
+	}
+	suffix := ""
+	if t.PartSuffix {
+		suffix = ".part"
+	}
+	// Open storage before any peer is contacted so a full disk fails fast.
+	out, err := storage.New(t.incompleteDir(), files, storage.Options{
+		Allocation:  t.Allocation,
+		PieceLength: int64(t.PieceLength),
+		Skip:        skip,
+		PartsPath:   "." + t.Name + ".parts",
+		Suffix:      suffix,
+	})
 	if err != nil {
-		return fmt.Errorf("could not create file: %v", err)
+		close(t.readyChan()) // readers waiting for the start get an error instead of hanging
+		t.mu.Unlock()
+		return err
 	}
-	defer out.Close()
-	workQueue := make(chan *pieceWork, len(t.PieceHashes))
-	results := make(chan *pieceResult)
 
-	for index, hash := range t.PieceHashes {
+	work := make([]*pieceWork, t.numPieces())
+	for index := range work {
 		begin := index * t.PieceLength
 		end := begin + t.PieceLength
 		if end > t.Length {
 			end = t.Length
 		}
-		workQueue <- &pieceWork{index, hash, end - begin}
+		work[index] = &pieceWork{index: index, length: end - begin}
 	}
+	t.store = out
+	t.picker = newPicker(work, t.piecePriorities())
+	t.picker.sequential = t.Sequential
+	t.picker.readahead = t.readaheadPieces()
+	t.wake = make(chan struct{}, 1)
+	close(t.readyChan())
+	t.mu.Unlock()
+	defer t.picker.close()
 
-	for _, p := range t.Peers {
-		go t.startDownloadWorker(p, workQueue, results)
-	}
-	doneCount := 0
-	for doneCount < len(t.PieceHashes) {
-		res := <-results
-		time.Sleep(500 * time.Millisecond)
+	results := make(chan *pieceResult)
+	conns := newConnManager(t, results)
+	t.mu.Lock()
+	t.conns = conns
+	conns.add(t.Peers, t.InfoHash)
+	conns.add(t.PeersV2, t.InfoHashV2)
+	t.mu.Unlock()
+	go conns.run()
+	defer conns.stop()
+	for t.picker.remaining() > 0 {
+		var res *pieceResult
+		select {
+		case res = <-results:
+		case <-t.wake:
+			continue
+		case <-conns.exhausted:
+			return ErrNoPeers
+		}
 		begin := res.index * t.PieceLength
 		_, err := out.WriteAt(res.buf, int64(begin))
+		t.bufs.put(res.buf)
 		if err != nil {
 			log.Printf("Failed to write piece %d to disk: %v", res.index, err)
+			t.picker.requeue(&pieceWork{index: res.index})
 			continue
 		}
-		doneCount++
+		t.picker.markDone(res.index)
+
+		wanted := t.picker.wanted()
+		doneCount := wanted - t.picker.remaining()
+		percent := float64(doneCount) / float64(wanted) * 100
+		log.Printf("Overall Progress: %.2f%% (%d/%d pieces)", percent, doneCount, wanted)
+	}
 
-		percent := float64(doneCount) / float64(len(t.PieceHashes)) * 100
-		log.Printf("Overall Progress: %.2f%% (%d/%d pieces)", percent, doneCount, len(t.PieceHashes))
+	if t.CompleteDir != "" || t.PartSuffix {
+		// Readers keep working across the move: storage reopens the files in place.
+		if err := out.Move(t.completeDir()); err != nil {
+			return fmt.Errorf("could not move completed files: %v", err)
+		}
 	}
-	close(workQueue)
 
-	log.Printf("Download complete! File saved as: %s", t.Name)
+	log.Printf("Download complete! All wanted files saved under: %s", filepath.Join(out.Dir(), t.Name))
 	return nil
 }
+
+func (t *Torrent) incompleteDir() string {
+	if t.IncompleteDir == "" {
+		return "."
+	}
+	return t.IncompleteDir
+}
+
+func (t *Torrent) completeDir() string {
+	if t.CompleteDir == "" {
+		return t.incompleteDir()
+	}
+	return t.CompleteDir
+}
+
+// Dir returns the directory holding the payload: IncompleteDir while
+// downloading, CompleteDir once the files have been moved there.
+func (t *Torrent) Dir() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.store != nil {
+		return t.store.Dir()
+	}
+	return t.incompleteDir()
+}
+
+// Close releases the files opened by Download. Storage stays open after the
+// download completes so readers can keep streaming from it.
+func (t *Torrent) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.store == nil {
+		return nil
+	}
+	err := t.store.Close()
+	t.store = nil
+	return err
+}
@@ -0,0 +1,176 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// blockServer plays a seeder of zeroed pieces. It answers every request
+// after delay, from frames encoded up front so the seeder adds next to
+// nothing to the allocation counts. Requests are delayed independently,
+// like a link with that latency rather than a slow peer.
+type blockServer struct {
+	delay time.Duration
+	// reqq, when set, is announced in an extended handshake and caps the
+	// requests the downloader keeps in flight.
+	reqq   int
+	frames map[block][]byte
+
+	mu                    sync.Mutex
+	inFlight, maxInFlight int
+}
+
+func newBlockServer(pieceLength, numPieces int, delay time.Duration, reqq int) *blockServer {
+	s := &blockServer{delay: delay, reqq: reqq, frames: map[block][]byte{}}
+	zeros := make([]byte, blockSize)
+	for index := range numPieces {
+		for begin := 0; begin < pieceLength; begin += blockSize {
+			b := block{index, begin, min(blockSize, pieceLength-begin)}
+			s.frames[b] = peer.Encode(peer.Piece{Index: index, Begin: begin, Block: zeros[:b.length]})
+		}
+	}
+	return s
+}
+
+func (s *blockServer) serve(conn net.Conn) {
+	s.send(conn, peer.Encode(peer.Unchoke{}), false)
+	if s.reqq > 0 {
+		s.send(conn, peer.Encode(peer.ExtendedHandshake{Reqq: s.reqq}), false)
+	}
+	r := peer.NewReader(conn, peer.DefaultLimits)
+	for {
+		msg, err := r.Read()
+		if err != nil {
+			return
+		}
+		req, ok := msg.(peer.Request)
+		if !ok {
+			continue
+		}
+		frame := s.frames[block{req.Index, req.Begin, req.Length}]
+		s.mu.Lock()
+		s.inFlight++
+		s.maxInFlight = max(s.maxInFlight, s.inFlight)
+		s.mu.Unlock()
+		if s.delay == 0 {
+			s.send(conn, frame, true)
+		} else {
+			time.AfterFunc(s.delay, func() { s.send(conn, frame, true) })
+		}
+	}
+}
+
+func (s *blockServer) send(conn net.Conn, frame []byte, answer bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if answer {
+		s.inFlight--
+	}
+	conn.Write(frame)
+}
+
+// benchmarkDownload runs a download worker against a blockServer on the
+// loopback interface. The pieces go back to the picker as soon as they
+// verify, so the worker keeps going for as long as the benchmark needs.
+// allocs/op is allocations per piece, and depth is the most requests the
+// server saw in flight at once.
+func benchmarkDownload(b *testing.B, pieceLength int, delay time.Duration, reqq int) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Enough pieces to fill the deepest queue with room to spare.
+	numPieces := max(4, 2*defaultReqq*blockSize/pieceLength)
+	server := newBlockServer(pieceLength, numPieces, delay, reqq)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			server.serve(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	t := &Torrent{Name: "bench", PieceLength: pieceLength, Length: pieceLength * numPieces}
+	work := make([]*pieceWork, numPieces)
+	sum := sha1.Sum(make([]byte, pieceLength))
+	for i := range work {
+		work[i] = &pieceWork{index: i, length: pieceLength}
+		t.PieceHashes = append(t.PieceHashes, sum)
+	}
+	t.picker = newPicker(work, t.piecePriorities())
+
+	var res peer.Handshake
+	res.Reserved[5] |= peer.ReservedExtension
+	results := make(chan *pieceResult)
+	done := make(chan struct{})
+	go func() {
+		t.downloadFrom(peer.Peer{IP: net.IPv4(127, 0, 0, 1)}, conn, &res, results)
+		close(done)
+	}()
+
+	b.SetBytes(int64(pieceLength))
+	b.ReportAllocs()
+	for b.Loop() {
+		r := <-results
+		t.bufs.put(r.buf)
+		t.picker.requeue(work[r.index])
+	}
+	b.StopTimer()
+	server.mu.Lock()
+	b.ReportMetric(float64(server.maxInFlight), "depth")
+	server.mu.Unlock()
+
+	t.picker.close()
+	conn.Close()
+	for {
+		select {
+		case r := <-results:
+			t.bufs.put(r.buf)
+			continue
+		case <-done:
+		}
+		break
+	}
+}
+
+func BenchmarkDownloadPiece(b *testing.B) {
+	benchmarkDownload(b, 1<<20, 0, 0)
+}
+
+// BenchmarkPipelineLatency shows the request queue growing with the
+// latency of the peer, which keeps the rate up where a fixed queue of five
+// requests would manage 80 KiB per round trip.
+func BenchmarkPipelineLatency(b *testing.B) {
+	for _, delay := range []time.Duration{time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond} {
+		b.Run(fmt.Sprint(delay), func(b *testing.B) {
+			benchmarkDownload(b, 1<<20, delay, 0)
+		})
+	}
+}
+
+// BenchmarkPipelineSmallPieces downloads 32 KiB pieces, two blocks each,
+// over a 50 ms link from peers announcing different reqq limits. Requests
+// run on into the next pieces, so throughput follows the queue depth the
+// peer allows rather than stopping at two blocks per round trip.
+func BenchmarkPipelineSmallPieces(b *testing.B) {
+	for _, reqq := range []int{8, 32, 128, defaultReqq} {
+		b.Run(fmt.Sprint("reqq=", reqq), func(b *testing.B) {
+			benchmarkDownload(b, 32<<10, 50*time.Millisecond, reqq)
+		})
+	}
+}
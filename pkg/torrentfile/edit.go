@@ -0,0 +1,126 @@
+package torrentfile
+
+import (
+	"fmt"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+)
+
+// Edit lists changes to make to an existing .torrent file. Nil fields are
+// left alone; pointing at an empty value removes the key.
+type Edit struct {
+	// Trackers replaces announce and announce-list, in tiers as for Builder.
+	Trackers *[][]string
+	// WebSeeds replaces url-list.
+	WebSeeds  *[]string
+	Comment   *string
+	CreatedBy *string
+
+	// Private and Source live in the info dictionary: setting either one
+	// changes the infohash, making a new torrent with a new swarm.
+	Private *bool
+	Source  *string
+}
+
+// ChangesInfo reports whether e touches the info dictionary and so the infohash.
+func (e *Edit) ChangesInfo() bool {
+	return e.Private != nil || e.Source != nil
+}
+
+// EditTorrent applies e to the contents of a .torrent file. Keys e doesn't
+// mention, including ones this package doesn't know, are kept as they are,
+// and unless ChangesInfo is true the info dictionary is copied byte for
+// byte, so the infohash stays the same.
+func EditTorrent(data []byte, e Edit) ([]byte, error) {
+	var top map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	if top["info"] == nil {
+		return nil, fmt.Errorf("torrent has no info dictionary")
+	}
+
+	if e.Trackers != nil {
+		announce, list := announceFields(*e.Trackers)
+		if err := setKey(top, "announce", announce, announce == ""); err != nil {
+			return nil, err
+		}
+		if err := setKey(top, "announce-list", list, len(list) == 0); err != nil {
+			return nil, err
+		}
+	}
+	if e.WebSeeds != nil {
+		if err := setKey(top, "url-list", *e.WebSeeds, len(*e.WebSeeds) == 0); err != nil {
+			return nil, err
+		}
+	}
+	if e.Comment != nil {
+		if err := setKey(top, "comment", *e.Comment, *e.Comment == ""); err != nil {
+			return nil, err
+		}
+	}
+	if e.CreatedBy != nil {
+		if err := setKey(top, "created by", *e.CreatedBy, *e.CreatedBy == ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.ChangesInfo() {
+		var info map[string]bencode.RawMessage
+		if err := bencode.Unmarshal(top["info"], &info); err != nil {
+			return nil, fmt.Errorf("info: %v", err)
+		}
+		if e.Private != nil {
+			if err := setKey(info, "private", 1, !*e.Private); err != nil {
+				return nil, err
+			}
+		}
+		if e.Source != nil {
+			if err := setKey(info, "source", *e.Source, *e.Source == ""); err != nil {
+				return nil, err
+			}
+		}
+		raw, err := bencode.Marshal(info)
+		if err != nil {
+			return nil, err
+		}
+		top["info"] = raw
+	}
+
+	return bencode.Marshal(top)
+}
+
+// setKey encodes v into dict[key], or deletes the key when remove is true.
+func setKey(dict map[string]bencode.RawMessage, key string, v any, remove bool) error {
+	if remove {
+		delete(dict, key)
+		return nil
+	}
+	raw, err := bencode.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	dict[key] = raw
+	return nil
+}
+
+// announceFields turns tracker tiers into the announce and announce-list
+// values: the first URL is the announce URL, and the list is only written
+// when there is more than one tracker.
+func announceFields(tiers [][]string) (announce string, list [][]string) {
+	var all []string
+	for _, tier := range tiers {
+		for _, u := range tier {
+			if u != "" {
+				all = append(all, u)
+			}
+		}
+	}
+	if len(all) > 0 {
+		announce = all[0]
+	}
+	if len(all) > 1 {
+		list = tiers
+	}
+	return announce, list
+}
@@ -0,0 +1,145 @@
+package torrentfile
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+)
+
+// editTorrent returns a torrent carrying keys this package doesn't model,
+// both at the top level and in the info dictionary.
+func editTorrent(t *testing.T) []byte {
+	t.Helper()
+	data, err := bencode.Marshal(map[string]any{
+		"announce":   "http://old/announce",
+		"comment":    "old",
+		"created by": "mktorrent 1.1",
+		"url-list":   []string{"http://old/seed"},
+		"x-unknown":  "kept",
+		"info": map[string]any{
+			"length":       100,
+			"md5sum":       "0123456789abcdef0123456789abcdef",
+			"name":         "a",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", 20),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// TestEditKeepsInfo makes every edit that stays outside the info dictionary
+// and checks that its bytes, and so the infohash, come out unchanged.
+func TestEditKeepsInfo(t *testing.T) {
+	data := editTorrent(t)
+	orig, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origInfo, _ := orig.InfoBytes()
+	origHash, _ := orig.InfoHash()
+
+	for _, tt := range []struct {
+		name  string
+		edit  Edit
+		check func(b bencodeTorrent) bool
+	}{
+		{"trackers", Edit{Trackers: &[][]string{{"http://new/announce"}, {"udp://backup:80"}}}, func(b bencodeTorrent) bool {
+			return b.Announce == "http://new/announce" && len(b.AnnounceList) == 2
+		}},
+		{"one tracker", Edit{Trackers: &[][]string{{"http://new/announce"}}}, func(b bencodeTorrent) bool {
+			return b.Announce == "http://new/announce" && b.AnnounceList == nil
+		}},
+		{"no trackers", Edit{Trackers: &[][]string{}}, func(b bencodeTorrent) bool {
+			return b.Announce == "" && b.AnnounceList == nil
+		}},
+		{"web seeds", Edit{WebSeeds: &[]string{"http://a/", "http://b/"}}, func(b bencodeTorrent) bool {
+			return slices.Equal(b.URLList, []string{"http://a/", "http://b/"})
+		}},
+		{"no web seeds", Edit{WebSeeds: &[]string{}}, func(b bencodeTorrent) bool {
+			return b.URLList == nil
+		}},
+		{"comment", Edit{Comment: ptr("new"), CreatedBy: ptr("")}, func(b bencodeTorrent) bool {
+			return b.Comment == "new" && b.CreatedBy == ""
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.edit.ChangesInfo() {
+				t.Fatal("ChangesInfo is true for an edit outside the info dictionary")
+			}
+			out, err := EditTorrent(data, tt.edit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bencode.Valid(out); err != nil {
+				t.Fatalf("EditTorrent wrote non-canonical bencode: %v", err)
+			}
+			b, err := Parse(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(b) {
+				t.Errorf("edit not applied: %+v", b)
+			}
+			info, _ := b.InfoBytes()
+			if hash, _ := b.InfoHash(); !bytes.Equal(info, origInfo) || hash != origHash {
+				t.Errorf("info dictionary changed:\n%q\nwant\n%q", info, origInfo)
+			}
+			if !bytes.Contains(out, []byte("9:x-unknown4:kept")) {
+				t.Error("an unknown top-level key was dropped")
+			}
+		})
+	}
+}
+
+// TestEditInfo sets the keys that live in the info dictionary, which makes
+// a new torrent but must keep the info keys it doesn't touch.
+func TestEditInfo(t *testing.T) {
+	data := editTorrent(t)
+	orig, _ := Parse(data)
+	origHash, _ := orig.InfoHash()
+
+	e := Edit{Private: ptr(true), Source: ptr("SRC")}
+	if !e.ChangesInfo() {
+		t.Fatal("ChangesInfo is false for private and source")
+	}
+	out, err := EditTorrent(data, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := b.InfoHash(); hash == origHash {
+		t.Error("infohash unchanged after making the torrent private")
+	}
+	if b.Info.Private != 1 || b.Info.Source != "SRC" {
+		t.Errorf("private %d, source %q", b.Info.Private, b.Info.Source)
+	}
+	if info, _ := b.InfoBytes(); !bytes.Contains(info, []byte("6:md5sum")) {
+		t.Error("an unknown info key was dropped")
+	}
+
+	// Undoing both edits gives back the original torrent.
+	back, err := EditTorrent(out, Edit{Private: ptr(false), Source: ptr("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Errorf("undoing the edit gave\n%q\nwant\n%q", back, data)
+	}
+}
+
+func TestEditNoInfo(t *testing.T) {
+	if _, err := EditTorrent([]byte("d8:announce1:xe"), Edit{Comment: ptr("c")}); err == nil {
+		t.Error("edited a torrent without an info dictionary")
+	}
+}
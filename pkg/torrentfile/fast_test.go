@@ -0,0 +1,114 @@
+package torrentfile
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// TestRejectRequeues has a peer reject a request. The block must be asked
+// for again right away, not after the piece times out.
+func TestRejectRequeues(t *testing.T) {
+	const pieceLen = 2 * blockSize
+	payload := testPayload(4 * pieceLen)
+	var (
+		mu                  sync.Mutex
+		rejected, requested time.Time
+	)
+	p := seed(t, "127.0.0.1", payload, pieceLen, true, func(c *seedConn) {
+		c.send(peer.Unchoke{})
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			req, ok := msg.(peer.Request)
+			if !ok {
+				continue
+			}
+			if req.Index == 1 && req.Begin == 0 {
+				mu.Lock()
+				first := rejected.IsZero()
+				if first {
+					rejected = time.Now()
+				} else if requested.IsZero() {
+					requested = time.Now()
+				}
+				mu.Unlock()
+				if first {
+					c.send(peer.Reject(req))
+					continue
+				}
+			}
+			c.send(c.piece(req))
+		}
+	})
+	to := testTorrent(t, payload, pieceLen, p)
+	wait(t, startDownload(t, to), 10*time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if rejected.IsZero() || requested.IsZero() {
+		t.Fatal("the rejected block was not requested again")
+	}
+	if d := requested.Sub(rejected); d > time.Second {
+		t.Errorf("rejected block requested again after %v", d)
+	}
+}
+
+// TestAllowedFastWhileChoked has a peer keep us choked but allow piece 2
+// to be fetched anyway (BEP 6). It unchokes only once it served piece 2,
+// and nothing else may be requested until then.
+func TestAllowedFastWhileChoked(t *testing.T) {
+	const pieceLen = 2 * blockSize
+	payload := testPayload(4 * pieceLen)
+	var (
+		mu     sync.Mutex
+		choked []peer.Request // requests received while choking
+	)
+	p := seed(t, "127.0.0.1", payload, pieceLen, true, func(c *seedConn) {
+		c.send(peer.AllowedFast{Index: 2})
+		served := 0
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			req, ok := msg.(peer.Request)
+			if !ok {
+				continue
+			}
+			if served < pieceLen {
+				mu.Lock()
+				choked = append(choked, req)
+				mu.Unlock()
+				if req.Index != 2 {
+					c.send(peer.Reject(req))
+					continue
+				}
+				served += req.Length
+				c.send(c.piece(req))
+				if served == pieceLen {
+					c.send(peer.Unchoke{})
+				}
+				continue
+			}
+			c.send(c.piece(req))
+		}
+	})
+	to := testTorrent(t, payload, pieceLen, p)
+	wait(t, startDownload(t, to), 10*time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(choked) != 2 {
+		t.Errorf("%d requests while choked, want the 2 blocks of piece 2: %v", len(choked), choked)
+	}
+	for _, req := range choked {
+		if req.Index != 2 {
+			t.Errorf("requested %d+%d of piece %d while choked", req.Begin, req.Length, req.Index)
+		}
+	}
+}
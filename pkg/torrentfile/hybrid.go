@@ -0,0 +1,74 @@
+package torrentfile
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// InfoHashes returns the 20-byte infohash of every swarm the torrent can join:
+// the SHA-1 one for v1 metadata, then the truncated SHA-256 one for v2
+// metadata. A hybrid torrent has both and is announced and shared under each.
+func (b *bencodeTorrent) InfoHashes() ([][20]byte, error) {
+	var hashes [][20]byte
+	if b.IsV1() || !b.IsV2() {
+		h, err := b.InfoHash()
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	if v2, ok := b.InfoHashV2(); ok && b.IsV2() {
+		var h [20]byte
+		copy(h[:], v2[:20])
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
+
+// CheckHybrid makes sure the v1 and v2 halves of a hybrid torrent describe
+// the same payload: the same files in the same order, each aligned to a piece
+// boundary by padding files, and as many SHA-1 piece hashes as the v2 layout
+// has pieces. Pieces are verified by whichever hash is at hand, so a mismatch
+// would let one swarm's data fail the other's check. Torrents that aren't
+// hybrid always pass.
+func (b *bencodeTorrent) CheckHybrid() error {
+	if !b.IsV1() || !b.IsV2() {
+		return nil
+	}
+	v2, err := b.V2Files()
+	if err != nil {
+		return err
+	}
+	pl := int64(b.Info.PieceLength)
+
+	var offset int64
+	next := 0
+	for _, f := range b.FileList() {
+		start := offset
+		offset += f.Length
+		if f.Padding {
+			continue
+		}
+		if next >= len(v2) {
+			return fmt.Errorf("hybrid: v1 file %s is not in the v2 file tree", f.Path)
+		}
+		want := v2[next]
+		next++
+		if filepath.Clean(f.Path) != filepath.Clean(want.Path) || f.Length != want.Length {
+			return fmt.Errorf("hybrid: v1 file %s (%d bytes) does not match v2 file %s (%d bytes)",
+				f.Path, f.Length, want.Path, want.Length)
+		}
+		if f.Length > 0 && start%pl != 0 {
+			return fmt.Errorf("hybrid: %s starts at offset %d, not on a piece boundary", f.Path, start)
+		}
+	}
+	if next != len(v2) {
+		return fmt.Errorf("hybrid: v2 file %s is missing from the v1 file list", v2[next].Path)
+	}
+
+	numPieces := int((offset + pl - 1) / pl)
+	if got := len(b.Info.Pieces) / 20; got != numPieces {
+		return fmt.Errorf("hybrid: %d v1 piece hashes for %d pieces", got, numPieces)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package torrentfile
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func openFixture(t *testing.T, name string) bencodeTorrent {
+	t.Helper()
+	b, err := Open(filepath.Join("..", "bencode", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestInfoHashes checks which swarms each kind of torrent joins: v1 under
+// its SHA-1 infohash, v2 under the truncated SHA-256 one, hybrid under both.
+func TestInfoHashes(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		v1, v2  bool
+	}{
+		{"debian-13.3.0-amd64-netinst.iso.torrent", true, false},
+		{"v2.torrent", false, true},
+		{"hybrid.torrent", true, true},
+	} {
+		b := openFixture(t, tt.fixture)
+		var want [][20]byte
+		if tt.v1 {
+			h, err := b.InfoHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, h)
+		}
+		if tt.v2 {
+			full, ok := b.InfoHashV2()
+			if !ok {
+				t.Fatalf("%s: no v2 infohash", tt.fixture)
+			}
+			want = append(want, [20]byte(full[:20]))
+		}
+		got, err := b.InfoHashes()
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("%s: InfoHashes = %x, %v, want %x", tt.fixture, got, err, want)
+		}
+		if err := b.CheckHybrid(); err != nil {
+			t.Errorf("%s: CheckHybrid: %v", tt.fixture, err)
+		}
+	}
+}
+
+// TestCheckHybrid breaks the v1 half of the hybrid fixture, whose files are
+// big.bin, padding, empty, small.txt, padding and sub/mid.bin, so that it no
+// longer matches the v2 file tree.
+func TestCheckHybrid(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		edit  func(info *bencodeInfo)
+		error string
+	}{
+		{"renamed file", func(info *bencodeInfo) { info.Files[3].Path = []string{"other.txt"} }, "does not match v2 file"},
+		{"wrong length", func(info *bencodeInfo) { info.Files[5].Length-- }, "does not match v2 file"},
+		{"reordered", func(info *bencodeInfo) { info.Files[0], info.Files[5] = info.Files[5], info.Files[0] }, "does not match v2 file"},
+		{"missing padding", func(info *bencodeInfo) { info.Files = slices.Delete(info.Files, 4, 5) }, "not on a piece boundary"},
+		{"missing file", func(info *bencodeInfo) { info.Files = info.Files[:5] }, "missing from the v1 file list"},
+		{"extra file", func(info *bencodeInfo) {
+			info.Files = append(info.Files, bencodeFile{Length: 10, Path: []string{"extra"}})
+		}, "not in the v2 file tree"},
+		{"missing piece hash", func(info *bencodeInfo) { info.Pieces = info.Pieces[:len(info.Pieces)-20] }, "v1 piece hashes"},
+		{"extra piece hash", func(info *bencodeInfo) { info.Pieces += strings.Repeat("x", 20) }, "v1 piece hashes"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := openFixture(t, "hybrid.torrent")
+			tt.edit(&b.Info)
+			if err := b.CheckHybrid(); err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("CheckHybrid = %v, want an error about %q", err, tt.error)
+			}
+		})
+	}
+}
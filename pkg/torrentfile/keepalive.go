@@ -0,0 +1,118 @@
+package torrentfile
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+const (
+	// keepAliveInterval is how long we stay silent before sending a
+	// keep-alive. Peers commonly drop connections quiet for two minutes or
+	// more, so this is a little less.
+	keepAliveInterval = 110 * time.Second
+	// idleTimeout drops a peer that sent nothing, not even a keep-alive,
+	// for this long.
+	idleTimeout = 3 * time.Minute
+	// unchokeTimeout is how long we wait to be unchoked before leaving the
+	// peer for a better one.
+	unchokeTimeout = 2 * time.Minute
+	// requestTimeout drops a peer that answers none of our outstanding
+	// requests for this long.
+	requestTimeout = 30 * time.Second
+	// starvedTimeout drops an unchoking peer that had nothing we need for
+	// this long, freeing its slot for one that has.
+	starvedTimeout = 2 * time.Minute
+	// pickInterval is how often a worker with nothing to fetch from its
+	// peer looks for pieces that other peers gave back.
+	pickInterval = time.Second
+	// writeTimeout bounds a write to a peer that stopped reading.
+	writeTimeout = 30 * time.Second
+)
+
+// timeouts are the time limits of a peer connection, the constants above
+// unless a test shortens them.
+type timeouts struct {
+	keepAlive time.Duration
+	idle      time.Duration
+	unchoke   time.Duration
+	request   time.Duration
+	starved   time.Duration
+	pick      time.Duration
+	write     time.Duration
+}
+
+var defaultTimeouts = timeouts{
+	keepAlive: keepAliveInterval,
+	idle:      idleTimeout,
+	unchoke:   unchokeTimeout,
+	request:   requestTimeout,
+	starved:   starvedTimeout,
+	pick:      pickInterval,
+	write:     writeTimeout,
+}
+
+// writer is the single way messages go out on a peer connection. Writes
+// from the worker and from the keep-alive timer are serialized, which
+// matters for more than framing: an encrypted connection's cipher state
+// advances with every write. A silence of keepAliveInterval gets a
+// keep-alive.
+type writer struct {
+	conn     net.Conn
+	done     chan struct{}
+	interval time.Duration // keepAliveInterval outside tests
+	timeout  time.Duration // writeTimeout outside tests
+
+	mu   sync.Mutex
+	last time.Time // of the last write
+}
+
+func newWriter(conn net.Conn, to timeouts) *writer {
+	w := &writer{conn: conn, done: make(chan struct{}), interval: to.keepAlive, timeout: to.write, last: time.Now()}
+	go w.keepAlive()
+	return w
+}
+
+// send writes a message.
+func (w *writer) send(msg peer.Msg) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(msg)
+}
+
+func (w *writer) write(msg peer.Msg) error {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	_, err := w.conn.Write(peer.Encode(msg))
+	w.last = time.Now()
+	return err
+}
+
+func (w *writer) keepAlive() {
+	timer := time.NewTimer(w.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-timer.C:
+		}
+		w.mu.Lock()
+		quiet := time.Since(w.last)
+		if quiet >= w.interval {
+			if err := w.write(peer.KeepAlive{}); err != nil {
+				w.mu.Unlock()
+				return // the reader notices the broken connection too
+			}
+			quiet = 0
+		}
+		w.mu.Unlock()
+		timer.Reset(w.interval - quiet)
+	}
+}
+
+// close stops the keep-alives. It doesn't close the connection.
+func (w *writer) close() {
+	close(w.done)
+}
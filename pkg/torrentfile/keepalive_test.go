@@ -0,0 +1,155 @@
+package torrentfile
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// shortTimeouts are timeouts a test can sit out.
+func shortTimeouts() *timeouts {
+	to := defaultTimeouts
+	to.keepAlive = 50 * time.Millisecond
+	to.idle = 200 * time.Millisecond
+	to.unchoke = 100 * time.Millisecond
+	to.request = 100 * time.Millisecond
+	to.starved = 100 * time.Millisecond
+	to.pick = 10 * time.Millisecond
+	return &to
+}
+
+// TestKeepAlive reads the writer's side of a pipe and checks that a
+// keep-alive follows every silence of the keep-alive interval, and none
+// comes after close.
+func TestKeepAlive(t *testing.T) {
+	to := shortTimeouts()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	w := newWriter(a, *to)
+
+	// next reads one message, reports whether it is a keep-alive and how
+	// long it took.
+	r := peer.NewReader(b, peer.DefaultLimits)
+	next := func() (bool, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		b.SetReadDeadline(start.Add(time.Second))
+		msg, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := msg.(peer.KeepAlive)
+		return ok, time.Since(start)
+	}
+	for range 2 {
+		if ok, d := next(); !ok || d < to.keepAlive*9/10 {
+			t.Fatalf("got a message after %v, want a keep-alive after %v", d, to.keepAlive)
+		}
+	}
+
+	// A message resets the silence.
+	time.Sleep(to.keepAlive / 2)
+	go w.send(peer.Interested{})
+	if ok, _ := next(); ok {
+		t.Fatal("got a keep-alive in place of the message")
+	}
+	if ok, d := next(); !ok || d < to.keepAlive*9/10 {
+		t.Errorf("keep-alive %v after %v following a message, want one after %v", ok, d, to.keepAlive)
+	}
+
+	w.close()
+	b.SetReadDeadline(time.Now().Add(3 * to.keepAlive))
+	if _, err := io.ReadFull(b, make([]byte, 4)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read %v after close, want silence", err)
+	}
+}
+
+// TestIdleTimeout checks that reads and waits fail once the peer has sent
+// nothing for the idle timeout, and that its keep-alives keep it alive.
+func TestIdleTimeout(t *testing.T) {
+	to := shortTimeouts()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	pc := newPeerConn(a, "test", 1, &peer.Handshake{}, *to)
+	defer pc.close()
+	go io.Copy(io.Discard, b) // our keep-alives
+
+	// The peer keeps talking for a while: no timeout.
+	go func() {
+		for range 6 {
+			time.Sleep(to.idle / 4)
+			b.Write(peer.Encode(peer.KeepAlive{}))
+		}
+	}()
+	start := time.Now()
+	for range 6 {
+		if msg, err := pc.read(); err != nil || msg != (peer.KeepAlive{}) {
+			t.Fatalf("read %#v, %v while the peer sent keep-alives", msg, err)
+		}
+	}
+	if d := time.Since(start); d < to.idle {
+		t.Fatalf("keep-alives took %v, want longer than the idle timeout %v", d, to.idle)
+	}
+
+	// A short wait comes back empty, then the peer falls silent for good.
+	if ready, err := pc.wait(to.idle / 10); ready || err != nil {
+		t.Fatalf("wait = %v, %v, want false, nil", ready, err)
+	}
+	for {
+		ready, err := pc.wait(to.idle / 10)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if ready || err != nil {
+			t.Fatalf("wait = %v, %v on a silent peer", ready, err)
+		}
+	}
+	if d := time.Since(pc.lastRead); d < to.idle || d > 2*to.idle {
+		t.Errorf("wait gave up %v after the last message, want %v", d, to.idle)
+	}
+	start = time.Now()
+	if _, err := pc.read(); !errors.Is(err, os.ErrDeadlineExceeded) || time.Since(start) > to.idle/10 {
+		t.Errorf("read of an idle peer = %v after %v, want an immediate timeout", err, time.Since(start))
+	}
+}
+
+// TestUnchokeTimeout fills the only connection slot with a peer that never
+// unchokes and checks that the slot goes to a seed once the unchoke
+// timeout runs out.
+func TestUnchokeTimeout(t *testing.T) {
+	payload := testPayload(2 * 32768)
+	to := testTorrent(t, payload, 32768)
+	to.MaxPeers = 1
+	to.timeouts = shortTimeouts()
+	choked := make(chan struct{}, 10)
+	to.Peers = []peer.Peer{seed(t, "127.0.0.1", payload, 32768, false, func(c *seedConn) {
+		choked <- struct{}{}
+		for {
+			if _, err := c.read(); err != nil {
+				return
+			}
+		}
+	})}
+	done := startDownload(t, to)
+	select {
+	case <-choked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the choking peer was never connected")
+	}
+	start := time.Now()
+	s := seed(t, "127.0.0.2", payload, 32768, false, serveAll)
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{s}); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, done, 5*time.Second)
+	if d := time.Since(start); d < to.timeouts.unchoke/2 {
+		t.Errorf("the seed got a slot after %v, before the choking peer timed out", d)
+	}
+}
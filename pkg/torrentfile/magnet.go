@@ -0,0 +1,163 @@
+package torrentfile
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Magnet is the content of a magnet URI (BEP 9, with BEP 52's btmh for v2).
+type Magnet struct {
+	// InfoHash is the v1 infohash (xt=urn:btih). Zero when the link only has a v2 hash.
+	InfoHash [20]byte
+	// InfoHashV2 is the full SHA-256 v2 infohash (xt=urn:btmh). Zero for v1-only links.
+	InfoHashV2 [32]byte
+	Name       string   // dn
+	Length     int64    // xl, zero when unknown
+	Trackers   []string // tr
+	WebSeeds   []string // ws
+}
+
+// multihashSHA256 prefixes a SHA-256 digest in a btmh multihash.
+const multihashSHA256 = "1220"
+
+// Magnet describes the torrent as a magnet link, carrying both infohashes of
+// a hybrid torrent so that either swarm can be joined.
+func (b *bencodeTorrent) Magnet() (Magnet, error) {
+	m := Magnet{
+		Name:     b.Info.Name,
+		Length:   int64(b.TotalLength()),
+		Trackers: b.Trackers(),
+		WebSeeds: b.URLList,
+	}
+	if b.IsV1() || !b.IsV2() {
+		h, err := b.InfoHash()
+		if err != nil {
+			return Magnet{}, err
+		}
+		m.InfoHash = h
+	}
+	if h, ok := b.InfoHashV2(); ok && b.IsV2() {
+		m.InfoHashV2 = h
+	}
+	return m, nil
+}
+
+// String encodes m as a magnet URI. Every value except the infohashes is
+// percent-encoded, spaces as %20 since not every client reads "+" as a space.
+func (m Magnet) String() string {
+	var params []string
+	add := func(key, value string) {
+		params = append(params, key+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+	}
+	if m.InfoHash != [20]byte{} {
+		params = append(params, "xt=urn:btih:"+hex.EncodeToString(m.InfoHash[:]))
+	}
+	if m.InfoHashV2 != [32]byte{} {
+		params = append(params, "xt=urn:btmh:"+multihashSHA256+hex.EncodeToString(m.InfoHashV2[:]))
+	}
+	if m.Name != "" {
+		add("dn", m.Name)
+	}
+	if m.Length > 0 {
+		params = append(params, "xl="+strconv.FormatInt(m.Length, 10))
+	}
+	for _, tr := range m.Trackers {
+		add("tr", tr)
+	}
+	for _, ws := range m.WebSeeds {
+		add("ws", ws)
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// ParseMagnet decodes a magnet URI. It accepts hex and base32 btih hashes,
+// SHA-256 btmh hashes and numbered keys such as "tr.1", and requires at least
+// one infohash.
+func ParseMagnet(uri string) (Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return Magnet{}, err
+	}
+	if u.Scheme != "magnet" {
+		return Magnet{}, fmt.Errorf("not a magnet URI: %q", uri)
+	}
+
+	var m Magnet
+	var haveV1, haveV2 bool
+	// Walk the pairs in order so that trackers keep theirs, even when
+	// numbered ("tr.1", "tr.2").
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return Magnet{}, fmt.Errorf("magnet: %s: %v", rawKey, err)
+		}
+		key, _, _ := strings.Cut(rawKey, ".")
+
+		switch key {
+		case "xt":
+			switch {
+			case strings.HasPrefix(value, "urn:btih:"):
+				h, err := parseBTIH(strings.TrimPrefix(value, "urn:btih:"))
+				if err != nil {
+					return Magnet{}, err
+				}
+				m.InfoHash, haveV1 = h, true
+			case strings.HasPrefix(value, "urn:btmh:"):
+				mh := strings.TrimPrefix(value, "urn:btmh:")
+				if !strings.HasPrefix(mh, multihashSHA256) || len(mh) != len(multihashSHA256)+64 {
+					return Magnet{}, fmt.Errorf("magnet: unsupported btmh %q (want a SHA-256 multihash)", mh)
+				}
+				raw, err := hex.DecodeString(mh[len(multihashSHA256):])
+				if err != nil {
+					return Magnet{}, fmt.Errorf("magnet: invalid btmh %q", mh)
+				}
+				copy(m.InfoHashV2[:], raw)
+				haveV2 = true
+			}
+		case "dn":
+			m.Name = value
+		case "xl":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return Magnet{}, fmt.Errorf("magnet: invalid xl %q", value)
+			}
+			m.Length = n
+		case "tr":
+			m.Trackers = append(m.Trackers, value)
+		case "ws":
+			m.WebSeeds = append(m.WebSeeds, value)
+		}
+	}
+	if !haveV1 && !haveV2 {
+		return Magnet{}, fmt.Errorf("magnet: no btih or btmh infohash")
+	}
+	return m, nil
+}
+
+// parseBTIH decodes a v1 infohash written as 40 hex digits or 32 base32 characters.
+func parseBTIH(s string) ([20]byte, error) {
+	var h [20]byte
+	var raw []byte
+	var err error
+	switch len(s) {
+	case 40:
+		raw, err = hex.DecodeString(s)
+	case 32:
+		raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		err = fmt.Errorf("wrong length")
+	}
+	if err != nil {
+		return h, fmt.Errorf("magnet: invalid btih %q: %v", s, err)
+	}
+	copy(h[:], raw)
+	return h, nil
+}
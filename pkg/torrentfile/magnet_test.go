@@ -0,0 +1,123 @@
+package torrentfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMagnetRoundTrip encodes magnets for each kind of torrent and checks
+// that ParseMagnet gives back what went in.
+func TestMagnetRoundTrip(t *testing.T) {
+	v1 := [20]byte{0x86, 0xf6, 0x35, 0x03, 19: 0xde}
+	v2 := [32]byte{0x5a, 0x70, 0xf2, 0x42, 31: 0x02}
+	for _, tt := range []struct {
+		name string
+		m    Magnet
+		xt   []string
+	}{
+		{"v1", Magnet{InfoHash: v1}, []string{"xt=urn:btih:86f63503" + strings.Repeat("0", 30) + "de"}},
+		{"v2", Magnet{InfoHashV2: v2}, []string{"xt=urn:btmh:12205a70f242" + strings.Repeat("0", 54) + "02"}},
+		{"hybrid", Magnet{
+			InfoHash:   v1,
+			InfoHashV2: v2,
+			Name:       "a b&c=d/é+100%",
+			Length:     141400,
+			Trackers:   []string{"http://a/announce?x=1&y=2", "udp://b:80", "udp://c:80"},
+			WebSeeds:   []string{"http://seed/a b"},
+		}, []string{"xt=urn:btih:", "xt=urn:btmh:1220"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			uri := tt.m.String()
+			for _, xt := range tt.xt {
+				if !strings.Contains(uri, xt) {
+					t.Errorf("%s does not carry %s", uri, xt)
+				}
+			}
+			if strings.ContainsAny(uri[len("magnet:?"):], " +é") {
+				t.Errorf("%s has unescaped characters", uri)
+			}
+			got, err := ParseMagnet(uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.m) {
+				t.Errorf("ParseMagnet(%s) = %+v, want %+v", uri, got, tt.m)
+			}
+		})
+	}
+}
+
+// TestParseMagnet reads links as other clients write them.
+func TestParseMagnet(t *testing.T) {
+	const hash = "86f635034839f1ebe81ab96bee4ac59f61db9dde"
+	m, err := ParseMagnet("magnet:?xt=urn:btih:q33dka2ihhy6x2a2xfv64swft5q5xho6&dn=debian+13%2E3%20%28netinst%29" +
+		"&tr.1=http%3A%2F%2Fa%2Fannounce&tr.2=udp%3A%2F%2Fb%3A80&tr=udp://c:80&x.pe=1.2.3.4:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.String(); !strings.HasPrefix(got, "magnet:?xt=urn:btih:"+hash+"&") {
+		t.Errorf("base32 btih read as %s, want %s", got, hash)
+	}
+	if m.Name != "debian 13.3 (netinst)" {
+		t.Errorf("dn %q", m.Name)
+	}
+	if want := []string{"http://a/announce", "udp://b:80", "udp://c:80"}; !reflect.DeepEqual(m.Trackers, want) {
+		t.Errorf("trackers %q, want %q", m.Trackers, want)
+	}
+	if m.InfoHashV2 != [32]byte{} {
+		t.Error("a v1 link has a v2 infohash")
+	}
+}
+
+func TestParseMagnetErrors(t *testing.T) {
+	const hash = "86f635034839f1ebe81ab96bee4ac59f61db9dde"
+	for _, uri := range []string{
+		"http://example.com/?xt=urn:btih:" + hash,
+		"magnet:?dn=no+hash",
+		"magnet:?xt=urn:btih:" + hash[:39],
+		"magnet:?xt=urn:btih:" + hash[:39] + "z",
+		"magnet:?xt=urn:btih:" + strings.Repeat("1", 32), // not base32
+		"magnet:?xt=urn:btmh:1114" + strings.Repeat("ab", 20),
+		"magnet:?xt=urn:btmh:1220" + strings.Repeat("ab", 31),
+		"magnet:?xt=urn:btmh:1220" + strings.Repeat("zz", 32),
+		"magnet:?xt=urn:btih:" + hash + "&xl=-1",
+		"magnet:?xt=urn:btih:" + hash + "&dn=%zz",
+	} {
+		if m, err := ParseMagnet(uri); err == nil {
+			t.Errorf("ParseMagnet(%s) = %+v, want an error", uri, m)
+		}
+	}
+}
+
+// TestTorrentMagnet checks which infohashes the magnet of each fixture
+// carries: the v1 hash for v1, the v2 hash for v2 and both for a hybrid.
+func TestTorrentMagnet(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		v1, v2  bool
+	}{
+		{"debian-13.3.0-amd64-netinst.iso.torrent", true, false},
+		{"v2.torrent", false, true},
+		{"hybrid.torrent", true, true},
+	} {
+		b := openFixture(t, tt.fixture)
+		m, err := b.Magnet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (m.InfoHash != [20]byte{}) != tt.v1 || (m.InfoHashV2 != [32]byte{}) != tt.v2 {
+			t.Errorf("%s: magnet %s, want v1 %v and v2 %v", tt.fixture, m, tt.v1, tt.v2)
+		}
+		if h, _ := b.InfoHashV2(); tt.v2 && m.InfoHashV2 != h {
+			t.Errorf("%s: btmh %x, want %x", tt.fixture, m.InfoHashV2, h)
+		}
+		if m.Name != b.Info.Name || !reflect.DeepEqual(m.Trackers, b.Trackers()) {
+			t.Errorf("%s: name %q and trackers %q", tt.fixture, m.Name, m.Trackers)
+		}
+		back, err := ParseMagnet(m.String())
+		if err != nil || !reflect.DeepEqual(back, m) {
+			t.Errorf("%s: %s parsed back as %+v, %v", tt.fixture, m, back, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package torrentfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+// payloadReader reads a payload that already exists on disk, treating its
+// files as one contiguous stream the way pieces are hashed. Unlike storage it
+// never creates or grows anything, so it works on read-only trees.
+type payloadReader struct {
+	dir   string
+	files []storage.File
+
+	mu      sync.Mutex
+	handles map[int]*os.File
+}
+
+func newPayloadReader(dir string, files []storage.File) *payloadReader {
+	return &payloadReader{dir: dir, files: files, handles: map[int]*os.File{}}
+}
+
+// ReadAt fills p from payload offset off. os.File.ReadAt is safe for
+// concurrent use, so hashing workers share the handles.
+func (r *payloadReader) ReadAt(p []byte, off int64) (int, error) {
+	done := 0
+	var start int64
+	for i, f := range r.files {
+		end := start + f.Length
+		if done < len(p) && off < end && f.Padding {
+			n := min(len(p)-done, int(end-off))
+			clear(p[done : done+n])
+			done += n
+			off += int64(n)
+		} else if done < len(p) && off < end && f.Length > 0 {
+			fh, err := r.handle(i)
+			if err != nil {
+				return done, err
+			}
+			chunk := p[done:min(len(p), done+int(end-off))]
+			n, err := fh.ReadAt(chunk, off-start)
+			done += n
+			off += int64(n)
+			if n < len(chunk) {
+				return done, fmt.Errorf("%s: %v", f.Path, err)
+			}
+		}
+		start = end
+	}
+	return done, nil
+}
+
+func (r *payloadReader) handle(i int) (*os.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fh, ok := r.handles[i]; ok {
+		return fh, nil
+	}
+	fh, err := os.Open(filepath.Join(r.dir, r.files[i].Path))
+	if err != nil {
+		return nil, err
+	}
+	r.handles[i] = fh
+	return fh, nil
+}
+
+func (r *payloadReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, fh := range r.handles {
+		fh.Close()
+		delete(r.handles, i)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package torrentfile
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/pcode"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+var (
+	// errRejected means the peer refused a request (BEP 6). The piece goes
+	// straight back to the picker and the connection stays up.
+	errRejected = errors.New("peer rejected the request")
+	// errChoked means the peer choked us with pieces under way that we may
+	// no longer request. They go back to the picker for other peers.
+	errChoked = errors.New("peer choked us")
+)
+
+// clientVersion names us in the extended handshake.
+const clientVersion = "bittorrent-go"
+
+// peerConn is one connection to a peer together with what the peer has told
+// us about itself: whether it is choking us and which pieces it has.
+type peerConn struct {
+	conn      net.Conn
+	addr      string
+	numPieces int
+	timeouts  timeouts
+
+	// fast is set when both handshakes carried peer.ReservedFast, and
+	// extensions when both carried peer.ReservedExtension.
+	fast       bool
+	extensions bool
+	choked     bool
+	chokedAt   time.Time // when choked last became true
+
+	// bitfield holds the pieces announced with bitfield and have messages.
+	// haveAll stands for a full bitfield (have all, BEP 6). Until the peer
+	// says anything about its pieces, known is false and every piece is
+	// assumed present, as some seeders skip the bitfield entirely.
+	bitfield peer.Bitfield
+	haveAll  bool
+	known    bool
+
+	// allowedFast are the pieces we may request while choked (BEP 6).
+	allowedFast map[int]bool
+	// suggested are pieces the peer advised us to fetch, most recent last.
+	suggested []int
+
+	// requested are the blocks asked for and not yet received, with the
+	// time we asked; cancelled are blocks we withdrew that may still
+	// arrive. A block in neither is one we never asked for.
+	requested map[block]time.Time
+	cancelled map[block]bool
+	pipeline  pipeline
+
+	// buffered sits between conn and reader so that wait can look for the
+	// start of a message without consuming it.
+	buffered *bufio.Reader
+	reader   *peer.Reader
+	writer   *writer
+	// lastRead is when the last message arrived. A peer that sends nothing
+	// for the idle timeout after it is dropped.
+	lastRead time.Time
+	// deadline, when set, ends the current exchange (a piece layer, say)
+	// sooner than the idle timeout would.
+	deadline time.Time
+	// pieces are the pieces being downloaded, oldest first. Requested
+	// blocks are read straight into their buffers. The next piece is
+	// started while blocks of earlier ones are still on the way, so the
+	// request queue neither drains at piece boundaries nor is capped at the
+	// blocks of one piece.
+	pieces []*pieceDownload
+	// progress is when the last requested block arrived, or when requests
+	// went out to an idle peer.
+	progress time.Time
+	// starved is since when the peer, unchoking us, had nothing we need,
+	// or zero while there is something to fetch from it.
+	starved time.Time
+}
+
+// block identifies a requested range of a piece.
+type block struct {
+	index, begin, length int
+}
+
+// newPeerConn starts the state of a connection whose handshake with the
+// peer ended in res.
+func newPeerConn(conn net.Conn, addr string, numPieces int, res *peer.Handshake, to timeouts) *peerConn {
+	pc := &peerConn{
+		conn:        conn,
+		addr:        addr,
+		numPieces:   numPieces,
+		timeouts:    to,
+		fast:        res.SupportsFast(),
+		extensions:  res.SupportsExtensions(),
+		choked:      true,
+		chokedAt:    time.Now(),
+		bitfield:    make(peer.Bitfield, (numPieces+7)/8),
+		allowedFast: map[int]bool{},
+		requested:   map[block]time.Time{},
+		cancelled:   map[block]bool{},
+		pipeline:    newPipeline(),
+		buffered:    bufio.NewReader(conn),
+		lastRead:    time.Now(),
+	}
+	limits := peer.DefaultLimits.With(pcode.MsgBitfield, uint32(1+(numPieces+7)/8))
+	pc.reader = peer.NewReader(pc.buffered, limits)
+	pc.reader.Dest = pc.dest
+	pc.writer = newWriter(conn, to)
+	return pc
+}
+
+// close stops the connection's keep-alives; the caller closes the conn.
+func (pc *peerConn) close() {
+	pc.writer.close()
+}
+
+// send writes a message to the peer.
+func (pc *peerConn) send(msg peer.Msg) error {
+	return pc.writer.send(msg)
+}
+
+// read reads the next message. It is only valid until the next read. A peer
+// that sends nothing for the idle timeout, or by pc.deadline, times out.
+func (pc *peerConn) read() (peer.Msg, error) {
+	deadline := pc.lastRead.Add(pc.timeouts.idle)
+	if !pc.deadline.IsZero() && pc.deadline.Before(deadline) {
+		deadline = pc.deadline
+	}
+	pc.conn.SetReadDeadline(deadline)
+	msg, err := pc.reader.Read()
+	if err == nil {
+		pc.lastRead = time.Now()
+	}
+	return msg, err
+}
+
+// wait blocks until the peer starts sending a message or d passes, and
+// reports whether a message is there to read. Nothing is consumed, so a
+// wait that times out leaves the stream intact for the next read. A peer
+// silent for the idle timeout fails with os.ErrDeadlineExceeded.
+func (pc *peerConn) wait(d time.Duration) (bool, error) {
+	idle := pc.lastRead.Add(pc.timeouts.idle)
+	deadline := time.Now().Add(d)
+	if idle.Before(deadline) {
+		deadline = idle
+	}
+	pc.conn.SetReadDeadline(deadline)
+	_, err := pc.buffered.Peek(1)
+	if errors.Is(err, os.ErrDeadlineExceeded) && time.Now().Before(idle) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dest is the peer.Reader destination for blocks: a block we requested goes
+// straight into the buffer of its piece. Only blocks of pieces being
+// downloaded are ever requested, and only within their bounds.
+func (pc *peerConn) dest(index, begin, length int) []byte {
+	d := pc.download(index)
+	if d == nil {
+		return nil
+	}
+	if _, ok := pc.requested[block{index, begin, length}]; !ok {
+		return nil
+	}
+	return d.buf[begin : begin+length]
+}
+
+// download returns the piece index if it is being downloaded, or nil.
+func (pc *peerConn) download(index int) *pieceDownload {
+	for _, d := range pc.pieces {
+		if d.index == index {
+			return d
+		}
+	}
+	return nil
+}
+
+// unrequested returns the oldest piece being downloaded that has blocks
+// left to request and may be requested now, or nil.
+func (pc *peerConn) unrequested() *pieceDownload {
+	for _, d := range pc.pieces {
+		if d.next < d.length && pc.canRequest(d.index) {
+			return d
+		}
+	}
+	return nil
+}
+
+// remove drops d from the pieces being downloaded.
+func (pc *peerConn) remove(d *pieceDownload) {
+	pc.pieces = slices.DeleteFunc(pc.pieces, func(e *pieceDownload) bool { return e == d })
+}
+
+// request asks the peer for a block.
+func (pc *peerConn) request(b block) error {
+	if err := pc.send(peer.Request{Index: b.index, Begin: b.begin, Length: b.length}); err != nil {
+		return err
+	}
+	now := time.Now()
+	if len(pc.requested) == 0 {
+		pc.progress = now
+	}
+	pc.requested[b] = now
+	return nil
+}
+
+// cancelPiece withdraws the outstanding requests for piece index, so the
+// peer doesn't send blocks nobody will use. Those already on the way are
+// dropped when they arrive.
+func (pc *peerConn) cancelPiece(index int) {
+	for b := range pc.requested {
+		if b.index != index {
+			continue
+		}
+		pc.send(peer.Cancel{Index: b.index, Begin: b.begin, Length: b.length})
+		pc.cancelled[b] = true
+		delete(pc.requested, b)
+	}
+}
+
+// received accounts for an arriving block. It reports whether the block
+// answers a live request; a late block of a cancelled request is not, and
+// a block we never asked for is a protocol violation.
+func (pc *peerConn) received(m peer.Piece) (bool, error) {
+	b := block{m.Index, m.Begin, len(m.Block)}
+	if sent, ok := pc.requested[b]; ok {
+		delete(pc.requested, b)
+		now := time.Now()
+		pc.progress = now
+		pc.pipeline.received(b.length, now.Sub(sent), now)
+		return true, nil
+	}
+	if pc.cancelled[b] {
+		delete(pc.cancelled, b)
+		return false, nil
+	}
+	return false, peer.ProtocolErrorf("unrequested block %d+%d of piece %d", m.Begin, len(m.Block), m.Index)
+}
+
+// has reports whether the peer can give us piece index.
+func (pc *peerConn) has(index int) bool {
+	return !pc.known || pc.haveAll || pc.bitfield.HasPiece(index)
+}
+
+// canRequest reports whether a request for piece index may be sent now:
+// always when unchoked, and for allowed fast pieces while choked.
+func (pc *peerConn) canRequest(index int) bool {
+	return !pc.choked || (pc.fast && pc.allowedFast[index])
+}
+
+// hasAllowedFast is the picker filter for pieces we can fetch while choked.
+func (pc *peerConn) hasAllowedFast(index int) bool {
+	return pc.fast && pc.allowedFast[index] && pc.has(index)
+}
+
+// hasSuggested is the picker filter for pieces the peer suggested.
+func (pc *peerConn) hasSuggested(index int) bool {
+	for _, s := range pc.suggested {
+		if s == index {
+			return pc.has(index)
+		}
+	}
+	return false
+}
+
+// handle applies a message to the connection state, dropping block data. Messages that break the protocol return an error and end the
+// connection.
+func (pc *peerConn) handle(msg peer.Msg) error {
+	switch msg.(type) {
+	case peer.HaveAll, peer.HaveNone, peer.Suggest, peer.AllowedFast, peer.Reject:
+		if !pc.fast {
+			// BEP 6: these must not be sent unless both sides enabled the extension.
+			return peer.ProtocolErrorf("fast extension message %d without the fast extension", msg.Message().ID)
+		}
+	}
+
+	switch m := msg.(type) {
+	case peer.Choke:
+		if !pc.choked {
+			pc.choked, pc.chokedAt = true, time.Now()
+		}
+		// Without the fast extension a choke throws away our requests.
+		if !pc.fast {
+			clear(pc.requested)
+			clear(pc.cancelled)
+		}
+	case peer.Unchoke:
+		pc.choked = false
+	case peer.Have:
+		pc.known = true
+		pc.bitfield.SetPiece(m.Index)
+	case peer.Bitfield:
+		if len(m) != len(pc.bitfield) {
+			return peer.ProtocolErrorf("bitfield is %d bytes, want %d", len(m), len(pc.bitfield))
+		}
+		pc.known = true
+		copy(pc.bitfield, m)
+	case peer.Request:
+		// We don't upload, so every request is refused. Without the fast
+		// extension staying choked is refusal enough.
+		if pc.fast {
+			if err := pc.send(peer.Reject(m)); err != nil {
+				return err
+			}
+		}
+	case peer.HaveAll:
+		pc.known, pc.haveAll = true, true
+	case peer.HaveNone:
+		pc.known, pc.haveAll = true, false
+		clear(pc.bitfield)
+	case peer.Suggest:
+		if m.Index < pc.numPieces {
+			pc.suggested = append(pc.suggested, m.Index)
+		}
+	case peer.AllowedFast:
+		if m.Index < pc.numPieces {
+			pc.allowedFast[m.Index] = true
+		}
+	case peer.Reject:
+		b := block{m.Index, m.Begin, m.Length}
+		if _, ok := pc.requested[b]; !ok && !pc.cancelled[b] {
+			return peer.ProtocolErrorf("reject of block %d+%d of piece %d, which we never requested", m.Begin, m.Length, m.Index)
+		}
+		delete(pc.requested, b)
+		delete(pc.cancelled, b)
+	case peer.Extended:
+		if !pc.extensions {
+			return peer.ProtocolErrorf("extended message without the extension protocol")
+		}
+		if m.ID == peer.ExtHandshake {
+			hs, err := peer.ParseExtendedHandshake(m.Payload)
+			if err != nil {
+				return err
+			}
+			if hs.Reqq > 0 {
+				pc.pipeline.reqq = hs.Reqq
+			}
+		}
+	case peer.Piece:
+		// Live blocks are taken by the download loop before they get
+		// here, so this can only be a late one.
+		if _, err := pc.received(m); err != nil {
+			return err
+		}
+		// Keep-alives and messages we don't use are ignored.
+	}
+	return nil
+}
+
+// dropSuggestion forgets a suggestion once the piece has been picked.
+func (pc *peerConn) dropSuggestion(index int) {
+	for i, s := range pc.suggested {
+		if s == index {
+			pc.suggested = append(pc.suggested[:i], pc.suggested[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package torrentfile
+
+import (
+	"fmt"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// PeerSource says how a peer's address was found.
+type PeerSource int
+
+const (
+	SourceTracker PeerSource = iota
+	SourceDHT                // BEP 5
+	SourcePEX                // peer exchange, BEP 11
+	SourceLSD                // local service discovery, BEP 14
+)
+
+func (s PeerSource) String() string {
+	switch s {
+	case SourceTracker:
+		return "tracker"
+	case SourceDHT:
+		return "dht"
+	case SourcePEX:
+		return "pex"
+	case SourceLSD:
+		return "lsd"
+	}
+	return fmt.Sprintf("PeerSource(%d)", int(s))
+}
+
+// AllowsSource reports whether peers found through s may be used. A private
+// torrent (BEP 27) only trusts its own trackers, which is how private
+// trackers account for their users' traffic.
+func (t *Torrent) AllowsSource(s PeerSource) bool {
+	return !t.Private || s == SourceTracker
+}
+
+// AddPeers hands the torrent more peers of the swarm identified by infoHash,
+// which must be InfoHash or, for a hybrid torrent, InfoHashV2. Before
+// Download they are queued; while it runs they go into the pool the
+// connection manager draws from. Private torrents refuse every source but
+// their trackers.
+func (t *Torrent) AddPeers(source PeerSource, infoHash [20]byte, peers []peer.Peer) error {
+	if !t.AllowsSource(source) {
+		return fmt.Errorf("private torrent: refusing %d peers from %s", len(peers), source)
+	}
+	if infoHash != t.InfoHash && (infoHash != t.InfoHashV2 || t.InfoHashV2 == [20]byte{}) {
+		return fmt.Errorf("infohash %x does not belong to this torrent", infoHash)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns != nil {
+		t.conns.add(peers, infoHash)
+		return nil
+	}
+	if infoHash == t.InfoHash {
+		t.Peers = append(t.Peers, peers...)
+	} else {
+		t.PeersV2 = append(t.PeersV2, peers...)
+	}
+	return nil
+}
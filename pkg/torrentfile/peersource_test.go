@@ -0,0 +1,78 @@
+package torrentfile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// TestPrivateSources checks that a private torrent (BEP 27) takes peers from
+// its trackers only, while a public one takes them from anywhere.
+func TestPrivateSources(t *testing.T) {
+	p := []peer.Peer{{Port: 6881}}
+	for _, private := range []bool{false, true} {
+		for _, source := range []PeerSource{SourceTracker, SourceDHT, SourcePEX, SourceLSD} {
+			to := &Torrent{InfoHash: [20]byte{1}, Private: private}
+			err := to.AddPeers(source, to.InfoHash, p)
+			allowed := !private || source == SourceTracker
+			if to.AllowsSource(source) != allowed {
+				t.Errorf("private %v: AllowsSource(%s) = %v", private, source, !allowed)
+			}
+			switch {
+			case allowed && err != nil:
+				t.Errorf("private %v: peers from %s refused: %v", private, source, err)
+			case !allowed && err == nil:
+				t.Errorf("private %v: peers from %s accepted", private, source)
+			case !allowed && len(to.Peers) != 0:
+				t.Errorf("private %v: refused peers from %s were queued", private, source)
+			}
+		}
+	}
+}
+
+func TestAddPeersInfoHash(t *testing.T) {
+	v1, v2 := []peer.Peer{{Port: 1}}, []peer.Peer{{Port: 2}}
+	to := &Torrent{InfoHash: [20]byte{1}}
+	if err := to.AddPeers(SourceTracker, [20]byte{2}, v1); err == nil {
+		t.Error("peers of another swarm accepted")
+	}
+	// Without a v2 half, the zero InfoHashV2 must not match anything.
+	if err := to.AddPeers(SourceTracker, [20]byte{}, v1); err == nil {
+		t.Error("peers for the zero infohash accepted")
+	}
+
+	to.InfoHashV2 = [20]byte{2}
+	if err := to.AddPeers(SourceTracker, to.InfoHash, v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.AddPeers(SourceTracker, to.InfoHashV2, v2); err != nil {
+		t.Fatal(err)
+	}
+	if len(to.Peers) != 1 || to.Peers[0].Port != 1 || len(to.PeersV2) != 1 || to.PeersV2[0].Port != 2 {
+		t.Errorf("peers queued as %v and %v, want each in its own swarm", to.Peers, to.PeersV2)
+	}
+}
+
+// TestAddPeersWhileDownloading starts a download with nobody to download
+// from and adds a seed once it runs.
+func TestAddPeersWhileDownloading(t *testing.T) {
+	payload := testPayload(3 * 32768)
+	to := testTorrent(t, payload, 32768)
+	to.Private = true
+	done := startDownload(t, to)
+
+	s := seed(t, "127.0.0.1", payload, 32768, false, serveAll)
+	if err := to.AddPeers(SourceDHT, to.InfoHash, []peer.Peer{s}); err == nil {
+		t.Fatal("private torrent took a peer from the DHT")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("download ended without a peer: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{s}); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, done, 10*time.Second)
+}
@@ -0,0 +1,204 @@
+package torrentfile
+
+import (
+	"errors"
+	"sync"
+)
+
+var errPickerClosed = errors.New("download stopped before the piece was verified")
+
+type pieceState uint8
+
+const (
+	piecePending pieceState = iota
+	pieceInFlight
+	pieceDone
+)
+
+// picker hands pieces out to the download workers. It replaces a plain work
+// channel so that the order can follow file priorities and pieces belonging
+// only to skipped files are never requested.
+type picker struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	work     []*pieceWork
+	priority []Priority
+	state    []pieceState
+	closed   bool
+
+	// sequential hands pieces out in index order starting at the cursor.
+	sequential bool
+	// cursor is the piece a reader is currently waiting for, or -1. The
+	// readahead pieces starting there are fetched before anything else.
+	cursor    int
+	readahead int
+}
+
+func newPicker(work []*pieceWork, priority []Priority) *picker {
+	p := &picker{
+		work:      work,
+		priority:  priority,
+		state:     make([]pieceState, len(work)),
+		cursor:    -1,
+		readahead: 1,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// tryNext returns a wanted piece nobody is working on and that has reports
+// the peer can provide, or nil when there is none. Pieces inside the
+// readahead window come first, even if their file is skipped, since a reader
+// is blocked on them. After that sequential mode goes in index order from the
+// cursor, and otherwise the highest priority wins with the lowest index among
+// equals.
+func (p *picker) tryNext(has func(int) bool) *pieceWork {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	return p.pick(has)
+}
+
+// stopped reports whether the picker was closed because the download ended.
+func (p *picker) stopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *picker) pick(has func(int) bool) *pieceWork {
+	best := p.pickWindow(has)
+	if best < 0 && p.sequential {
+		best = p.pickSequential(has)
+	}
+	if best < 0 {
+		best = p.pickPriority(has)
+	}
+	if best < 0 {
+		return nil
+	}
+	p.state[best] = pieceInFlight
+	return p.work[best]
+}
+
+func (p *picker) pickWindow(has func(int) bool) int {
+	if p.cursor < 0 {
+		return -1
+	}
+	for i := p.cursor; i < len(p.state) && i < p.cursor+p.readahead; i++ {
+		if p.state[i] == piecePending && has(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *picker) pickSequential(has func(int) bool) int {
+	start := max(p.cursor, 0)
+	for n := 0; n < len(p.state); n++ {
+		i := (start + n) % len(p.state)
+		if p.state[i] == piecePending && p.priority[i] != PrioritySkip && has(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *picker) pickPriority(has func(int) bool) int {
+	best := -1
+	for i, st := range p.state {
+		if st != piecePending || p.priority[i] == PrioritySkip || !has(i) {
+			continue
+		}
+		if best < 0 || p.priority[i] > p.priority[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// setCursor moves the readahead window so that it starts at piece index.
+func (p *picker) setCursor(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cursor != index {
+		p.cursor = index
+		p.cond.Broadcast()
+	}
+}
+
+// waitDone blocks until piece index has been verified and written.
+func (p *picker) waitDone(index int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.state[index] != pieceDone {
+		if p.closed {
+			return errPickerClosed
+		}
+		p.cond.Wait()
+	}
+	return nil
+}
+
+// requeue gives a piece back after a failed or corrupt download.
+func (p *picker) requeue(pw *pieceWork) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state[pw.index] == pieceInFlight {
+		p.state[pw.index] = piecePending
+	}
+	p.cond.Broadcast()
+}
+
+// markDone records that a piece has been verified and written.
+func (p *picker) markDone(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state[index] = pieceDone
+	p.cond.Broadcast()
+}
+
+// setPriorities replaces the per-piece priorities, e.g. after a file priority changed.
+func (p *picker) setPriorities(priority []Priority) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.priority = priority
+	p.cond.Broadcast()
+}
+
+// remaining counts the wanted pieces that aren't done yet.
+func (p *picker) remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for i, st := range p.state {
+		if st != pieceDone && p.priority[i] != PrioritySkip {
+			n++
+		}
+	}
+	return n
+}
+
+// wanted counts all pieces that overlap a wanted file, done or not.
+func (p *picker) wanted() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, prio := range p.priority {
+		if prio != PrioritySkip {
+			n++
+		}
+	}
+	return n
+}
+
+// close ends the download for the workers: tryNext returns nil from now on
+// and readers blocked in waitDone fail.
+func (p *picker) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
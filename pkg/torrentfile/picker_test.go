@@ -0,0 +1,144 @@
+package torrentfile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+func testPicker(prios ...Priority) *picker {
+	work := make([]*pieceWork, len(prios))
+	for i := range work {
+		work[i] = &pieceWork{index: i}
+	}
+	return newPicker(work, prios)
+}
+
+// drain picks until the picker has nothing left for a peer with every piece.
+func drain(p *picker) []int {
+	var order []int
+	for pw := p.tryNext(func(int) bool { return true }); pw != nil; pw = p.tryNext(func(int) bool { return true }) {
+		order = append(order, pw.index)
+	}
+	return order
+}
+
+func TestPickerOrder(t *testing.T) {
+	prios := []Priority{PriorityNormal, PriorityHigh, PrioritySkip, PriorityLow, PriorityHigh, PriorityNormal}
+	for _, tt := range []struct {
+		name       string
+		sequential bool
+		cursor     int
+		readahead  int
+		want       []int
+	}{
+		{"priority", false, -1, 1, []int{1, 4, 0, 5, 3}},
+		{"sequential", true, -1, 1, []int{0, 1, 3, 4, 5}},
+		{"sequential from the cursor", true, 4, 1, []int{4, 5, 0, 1, 3}},
+		{"readahead", false, 2, 2, []int{2, 3, 1, 4, 0, 5}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPicker(prios...)
+			p.sequential = tt.sequential
+			p.readahead = tt.readahead
+			p.setCursor(tt.cursor)
+			if got := drain(p); !slices.Equal(got, tt.want) {
+				t.Errorf("picked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPickerHas checks that only pieces the peer has are picked, and that
+// a piece comes back after requeue and never after markDone.
+func TestPickerHas(t *testing.T) {
+	p := testPicker(PriorityLow, PriorityNormal, PriorityHigh)
+	if pw := p.tryNext(func(i int) bool { return i != 2 }); pw == nil || pw.index != 1 {
+		t.Fatalf("picked %v, want piece 1", pw)
+	}
+	p.requeue(p.work[1])
+	p.markDone(2)
+	if got := drain(p); !slices.Equal(got, []int{1, 0}) {
+		t.Errorf("picked %v after requeue and markDone, want [1 0]", got)
+	}
+	if p.remaining() != 2 || p.wanted() != 3 {
+		t.Errorf("%d remaining of %d wanted, want 2 of 3", p.remaining(), p.wanted())
+	}
+
+	p.setPriorities([]Priority{PriorityLow, PrioritySkip, PriorityHigh})
+	if p.remaining() != 1 {
+		t.Errorf("%d remaining after skipping a piece, want 1", p.remaining())
+	}
+	p.close()
+	if pw := p.tryNext(func(int) bool { return true }); pw != nil || !p.stopped() {
+		t.Errorf("picked %v after close", pw)
+	}
+}
+
+// TestPiecePriorities checks that a piece takes the highest priority of the
+// files it overlaps, and that padding doesn't count.
+func TestPiecePriorities(t *testing.T) {
+	to := &Torrent{
+		PieceLength: 100,
+		Length:      500,
+		Files: []storage.File{
+			{Path: "a", Length: 150},
+			{Path: "b", Length: 100},
+			{Path: ".pad", Length: 50, Padding: true},
+			{Path: "c", Length: 200},
+		},
+		PieceHashes:    make([][20]byte, 5),
+		FilePriorities: []Priority{PriorityLow, PrioritySkip, PriorityHigh, PrioritySkip},
+	}
+	want := []Priority{PriorityLow, PriorityLow, PrioritySkip, PrioritySkip, PrioritySkip}
+	if got := to.piecePriorities(); !slices.Equal(got, want) {
+		t.Errorf("piece priorities %v, want %v", got, want)
+	}
+	if err := to.SetFilePriority(1, PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	want = []Priority{PriorityLow, PriorityHigh, PriorityHigh, PrioritySkip, PrioritySkip}
+	if got := to.piecePriorities(); !slices.Equal(got, want) {
+		t.Errorf("piece priorities %v after raising b, want %v", got, want)
+	}
+}
+
+// TestSkipDownload downloads a torrent whose last file is skipped. Piece 2
+// straddles b and c and is fetched for b's sake, piece 3 is c's alone.
+func TestSkipDownload(t *testing.T) {
+	payload := testPayload(120000)
+	to := testTorrent(t, payload, 32768)
+	to.Files = []storage.File{
+		{Path: "payload/a", Length: 40000},
+		{Path: "payload/b", Length: 50000},
+		{Path: "payload/c", Length: 30000},
+	}
+	to.FilePriorities = []Priority{PriorityNormal, PriorityNormal, PrioritySkip}
+	to.Peers = append(to.Peers, seed(t, "127.0.0.1", payload, 32768, false, serveAll))
+	wait(t, startDownload(t, to), 10*time.Second)
+
+	dir := to.IncompleteDir
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"a", payload[:40000]}, {"b", payload[40000:90000]}} {
+		if got, err := os.ReadFile(filepath.Join(dir, "payload", f.name)); err != nil || !bytes.Equal(got, f.data) {
+			t.Errorf("%s did not download: %v", f.name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "payload", "c")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the skipped file was created: %v", err)
+	}
+	if n := to.picker.remaining(); n != 0 {
+		t.Errorf("%d wanted pieces left", n)
+	}
+	if to.picker.state[3] != piecePending {
+		t.Error("the piece only the skipped file needs was downloaded")
+	}
+}
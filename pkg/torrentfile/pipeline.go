@@ -0,0 +1,75 @@
+package torrentfile
+
+import (
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+const (
+	// minDepth is the request queue of a peer we know nothing about yet, and
+	// the least we keep queued on a fast, close link, where the timing is
+	// mostly scheduling noise.
+	minDepth = 5
+	// defaultReqq caps the queue of peers that don't advertise reqq in
+	// their extended handshake; it is what most clients accept.
+	defaultReqq = 250
+	// minRateWindow is the shortest time bytes are counted for before the
+	// rate is updated; otherwise it is updated every two round trips.
+	minRateWindow = 20 * time.Millisecond
+)
+
+// pipeline sizes a peer's request queue from the bandwidth-delay product:
+// there should be enough requests outstanding to keep the link busy for a
+// round trip. Twice that is asked for, so that a queue the link isn't
+// filling yet doubles every window, like TCP slow start, and one it fills
+// has a round trip of slack.
+type pipeline struct {
+	reqq int // the peer's limit on outstanding requests
+
+	// rtt is the lowest request to block latency seen. The other samples
+	// include the time a block waited behind earlier ones at the peer.
+	rtt time.Duration
+	// rate is a moving average of the download rate in bytes per second.
+	rate float64
+
+	windowStart time.Time
+	windowBytes int
+}
+
+func newPipeline() pipeline {
+	return pipeline{reqq: defaultReqq}
+}
+
+// depth returns how many requests to keep outstanding.
+func (p *pipeline) depth() int {
+	if p.rtt == 0 || p.rate == 0 {
+		return min(minDepth, p.reqq)
+	}
+	bdp := p.rate * p.rtt.Seconds()
+	n := int(2 * bdp / peer.MaxBlockSize)
+	return min(max(n, minDepth), p.reqq)
+}
+
+// received accounts for a block of n bytes that took rtt to arrive.
+func (p *pipeline) received(n int, rtt time.Duration, now time.Time) {
+	if p.rtt == 0 || rtt < p.rtt {
+		p.rtt = max(rtt, time.Microsecond)
+	}
+	if p.windowStart.IsZero() {
+		// The first block only starts the clock: it spent most of its
+		// time in the round trip, not on the wire.
+		p.windowStart = now
+		return
+	}
+	p.windowBytes += n
+	if elapsed := now.Sub(p.windowStart); elapsed >= max(2*p.rtt, minRateWindow) {
+		rate := float64(p.windowBytes) / elapsed.Seconds()
+		if p.rate == 0 {
+			p.rate = rate
+		} else {
+			p.rate += (rate - p.rate) / 2
+		}
+		p.windowStart, p.windowBytes = now, 0
+	}
+}
@@ -0,0 +1,71 @@
+package torrentfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Priority tells the piece scheduler how much we care about a file.
+type Priority int
+
+const (
+	// PrioritySkip files are never requested and never created on disk.
+	PrioritySkip Priority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySkip:
+		return "skip"
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority turns "skip", "low", "normal" or "high" into a Priority.
+func ParsePriority(s string) (Priority, error) {
+	for p := PrioritySkip; p <= PriorityHigh; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q (want skip, low, normal or high)", s)
+}
+
+// ParseFilePriorities parses a comma separated list of index=priority pairs,
+// e.g. "0=skip,3=high", into a slice of numFiles priorities. Files not
+// mentioned stay at PriorityNormal.
+func ParseFilePriorities(s string, numFiles int) ([]Priority, error) {
+	prios := make([]Priority, numFiles)
+	for i := range prios {
+		prios[i] = PriorityNormal
+	}
+	if s == "" {
+		return prios, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		idx, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid file priority %q (want index=priority)", pair)
+		}
+		i, err := strconv.Atoi(idx)
+		if err != nil || i < 0 || i >= numFiles {
+			return nil, fmt.Errorf("invalid file index %q: torrent has %d files", idx, numFiles)
+		}
+		p, err := ParsePriority(name)
+		if err != nil {
+			return nil, err
+		}
+		prios[i] = p
+	}
+	return prios, nil
+}
@@ -0,0 +1,122 @@
+package torrentfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+// Reader reads the torrent payload while it downloads. It implements
+// io.ReadSeeker; Read blocks until the pieces holding the requested bytes have
+// been verified, and every Read or Seek moves the torrent's readahead window
+// to the reader's position so those pieces are fetched first. Several readers
+// may exist at once, but the window follows whichever one moved last.
+type Reader struct {
+	t      *Torrent
+	offset int64 // payload offset of the first byte this reader exposes
+	length int64
+	pos    int64
+}
+
+// NewReader returns a Reader over the whole payload. It may be created before
+// Download is called; reads block until the download has started.
+func (t *Torrent) NewReader() *Reader {
+	return &Reader{t: t, length: int64(t.Length)}
+}
+
+// NewFileReader returns a Reader over file i of the payload.
+func (t *Torrent) NewFileReader(i int) (*Reader, error) {
+	files := t.files()
+	if i < 0 || i >= len(files) {
+		return nil, fmt.Errorf("file index %d out of range: torrent has %d files", i, len(files))
+	}
+	var offset int64
+	for _, f := range files[:i] {
+		offset += f.Length
+	}
+	return &Reader{t: t, offset: offset, length: files[i].Length}, nil
+}
+
+// PayloadFiles returns the files of the payload in hash order.
+func (t *Torrent) PayloadFiles() []storage.File {
+	return t.files()
+}
+
+// Read reads up to len(p) bytes, never crossing a piece boundary in one call.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.length {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	pk, err := r.t.waitReady()
+	if err != nil {
+		return 0, err
+	}
+	off := r.offset + r.pos
+	piece := int(off / int64(r.t.PieceLength))
+	pk.setCursor(piece)
+	if err := pk.waitDone(piece); err != nil {
+		return 0, err
+	}
+
+	pieceEnd := int64(piece+1) * int64(r.t.PieceLength)
+	n := min(int64(len(p)), pieceEnd-off, r.length-r.pos)
+	r.t.mu.Lock()
+	store := r.t.store
+	r.t.mu.Unlock()
+	if store == nil {
+		return 0, errors.New("torrent is closed")
+	}
+	m, err := store.ReadAt(p[:n], off)
+	r.pos += int64(m)
+	return m, err
+}
+
+// Seek sets the offset for the next Read and moves the readahead window there.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.length + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = pos
+
+	if pos < r.length {
+		r.t.mu.Lock()
+		pk := r.t.picker
+		r.t.mu.Unlock()
+		if pk != nil {
+			pk.setCursor(int((r.offset + pos) / int64(r.t.PieceLength)))
+		}
+	}
+	return pos, nil
+}
+
+// waitReady blocks until Download has set up the picker and storage.
+func (t *Torrent) waitReady() (*picker, error) {
+	t.mu.Lock()
+	ready := t.readyChan()
+	t.mu.Unlock()
+	<-ready
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.picker == nil || t.store == nil {
+		return nil, errPickerClosed
+	}
+	return t.picker, nil
+}
@@ -0,0 +1,171 @@
+package torrentfile
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+// readerTorrent is 8 pieces of 16384 bytes in two files: a covers pieces 0-3
+// and ends 848 bytes into piece 3, b the rest. Two pieces are read ahead.
+func readerTorrent(t *testing.T, payload []byte) *Torrent {
+	to := testTorrent(t, payload, 16384)
+	to.Files = []storage.File{{Path: "payload/a", Length: 50000}, {Path: "payload/b", Length: 81072}}
+	to.Readahead = 2 * 16384
+	to.CompleteDir = t.TempDir()
+	return to
+}
+
+// TestReaderBlocks seeks into a download that has no peer yet, and checks
+// that Read waits for the piece, and that the pieces under the reader are
+// the first ones requested once a seed turns up.
+func TestReaderBlocks(t *testing.T) {
+	payload := testPayload(8 * 16384)
+	to := readerTorrent(t, payload)
+	done := startDownload(t, to)
+
+	r := to.NewReader()
+	const pos = 5*16384 + 10
+	if _, err := r.Seek(pos, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		n   int
+		err error
+	}
+	buf := make([]byte, 100000)
+	read := make(chan result, 1)
+	go func() {
+		n, err := r.Read(buf)
+		read <- result{n, err}
+	}()
+	select {
+	case res := <-read:
+		t.Fatalf("Read returned %d, %v before any peer had the piece", res.n, res.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	var mu sync.Mutex
+	var requested []int
+	s := seed(t, "127.0.0.1", payload, 16384, false, func(c *seedConn) {
+		c.send(peer.Unchoke{})
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			if req, ok := msg.(peer.Request); ok {
+				mu.Lock()
+				if !slices.Contains(requested, req.Index) {
+					requested = append(requested, req.Index)
+				}
+				mu.Unlock()
+				c.send(c.piece(req))
+			}
+		}
+	})
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{s}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-read:
+		// A read stops at the end of the piece.
+		if res.err != nil || !bytes.Equal(buf[:res.n], payload[pos:6*16384]) {
+			t.Fatalf("Read returned %d bytes, %v; want the %d to the end of piece 5", res.n, res.err, 6*16384-pos)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Read still blocked after the seed arrived")
+	}
+	wait(t, done, 10*time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) < 2 || !slices.Equal(requested[:2], []int{5, 6}) {
+		t.Errorf("pieces requested in order %v, want the readahead window 5 and 6 first", requested)
+	}
+}
+
+// TestReaderSeek checks that seeking moves the readahead window, and only
+// while the position is inside the payload.
+func TestReaderSeek(t *testing.T) {
+	to := readerTorrent(t, testPayload(8*16384))
+	to.picker = testPicker(make([]Priority, 8)...)
+	r, err := to.NewFileReader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		cursor int
+	}{
+		{0, io.SeekStart, 0, 3},
+		{20000, io.SeekCurrent, 20000, 4},
+		{-1, io.SeekEnd, 81071, 7},
+		{10, io.SeekEnd, 81082, 7},
+	} {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.pos {
+			t.Fatalf("Seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, pos, err, tt.pos)
+		}
+		if to.picker.cursor != tt.cursor {
+			t.Errorf("Seek(%d, %d) put the readahead window at piece %d, want %d", tt.offset, tt.whence, to.picker.cursor, tt.cursor)
+		}
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeked before the start")
+	}
+}
+
+// TestReaderEnd reads the files of a completed download up to their ends.
+func TestReaderEnd(t *testing.T) {
+	payload := testPayload(8 * 16384)
+	to := readerTorrent(t, payload)
+	to.Peers = append(to.Peers, seed(t, "127.0.0.1", payload, 16384, false, serveAll))
+	wait(t, startDownload(t, to), 10*time.Second)
+
+	a, err := to.NewFileReader(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	if n, err := a.Read(buf); n != 100 || err != nil || !bytes.Equal(buf[:n], payload[49900:50000]) {
+		t.Errorf("Read at 100 bytes before the end of a = %d, %v, want the last 100 bytes", n, err)
+	}
+	if n, err := a.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end of a = %d, %v, want io.EOF", n, err)
+	}
+	if _, err := a.Seek(1000, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read past the end of a = %d, %v, want io.EOF", n, err)
+	}
+
+	b, err := to.NewFileReader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(b); err != nil || !bytes.Equal(got, payload[50000:]) {
+		t.Errorf("reading all of b gave %d bytes, %v", len(got), err)
+	}
+	if got, err := io.ReadAll(to.NewReader()); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("reading the payload after the move gave %d bytes, %v", len(got), err)
+	}
+
+	to.Close()
+	if _, err := to.NewReader().Read(buf); err == nil {
+		t.Error("Read succeeded after Close")
+	}
+}
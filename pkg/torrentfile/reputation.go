@@ -0,0 +1,78 @@
+package torrentfile
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+const (
+	// protocolPenalty is charged for every protocol violation.
+	protocolPenalty = 50
+	// banScore is the penalty at which we stop connecting to an address.
+	banScore = 100
+)
+
+// reputation keeps penalty scores of misbehaving peers by IP address; a peer
+// that gets dropped can easily come back from another port, rarely from
+// another address. Scores last as long as the Torrent, and so do counts of
+// the corrupt pieces each address sent.
+type reputation struct {
+	mu      sync.Mutex
+	scores  map[string]int
+	corrupt map[string]int
+}
+
+// penalize adds points to ip's score and reports whether it is now banned.
+func (r *reputation) penalize(ip net.IP, points int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scores == nil {
+		r.scores = map[string]int{}
+	}
+	r.scores[ip.String()] += points
+	return r.scores[ip.String()] >= banScore
+}
+
+// addCorrupt counts a corrupt piece from ip and returns how many it sent.
+func (r *reputation) addCorrupt(ip string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.corrupt == nil {
+		r.corrupt = map[string]int{}
+	}
+	r.corrupt[ip]++
+	return r.corrupt[ip]
+}
+
+// ban bans ip outright.
+func (r *reputation) ban(ip string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scores == nil {
+		r.scores = map[string]int{}
+	}
+	r.scores[ip] = max(r.scores[ip], banScore)
+}
+
+// banned reports whether ip has reached banScore.
+func (r *reputation) banned(ip net.IP) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.scores[ip.String()] >= banScore
+}
+
+// misbehaved charges p if err is a protocol violation. Other errors, like
+// timeouts and resets, aren't the peer's fault as far as we can tell.
+func (t *Torrent) misbehaved(p peer.Peer, err error) {
+	var pe *peer.ProtocolError
+	if !errors.As(err, &pe) {
+		return
+	}
+	if t.reputation.penalize(p.IP, protocolPenalty) {
+		log.Printf("Banning %s: %v", p.IP, err)
+	}
+}
@@ -0,0 +1,168 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// DefaultBanThreshold is the number of corrupt pieces after which a peer's
+// address is banned when Torrent.BanThreshold is zero. One bad piece can be
+// bad luck, a bit flipped in some router; two is a pattern.
+const DefaultBanThreshold = 2
+
+// settleTimeout is how long a failed piece waits for a peer that sent none
+// of it before its senders may fetch it again. In a small swarm they can be
+// the only peers that have it.
+const settleTimeout = 30 * time.Second
+
+// span is a byte range of a piece and the address of the peer that sent it.
+type span struct {
+	begin, length int
+	ip            string
+}
+
+// blame finds the peers behind pieces that fail their hash check. A piece
+// that came from a single peer convicts that peer right away. One that had
+// several senders only tells us one of them lied, so the hash of every span
+// is kept and the piece is fetched again from a peer that sent none of it;
+// once that copy verifies, the spans that differ from it point at the
+// culprits. If no such peer turns up within settleTimeout, the senders get
+// the piece back. Each copy one of them fetches alone settles it as well:
+// a good one exposes the spans that differ, a bad one convicts the sender.
+type blame struct {
+	mu       sync.Mutex
+	suspects map[int]*suspicion // by piece index
+	// settle overrides settleTimeout in tests.
+	settle time.Duration
+}
+
+// suspicion is a failed copy of a piece waiting to be compared with a good one.
+type suspicion struct {
+	spans []suspectSpan
+	at    time.Time // of the failure
+}
+
+type suspectSpan struct {
+	span
+	sum [20]byte
+}
+
+// failed accounts for a piece that failed its hash check, with the spans
+// it was assembled from. It returns the addresses found guilty.
+func (b *blame) failed(index int, buf []byte, spans []span) []string {
+	senders := map[string]bool{}
+	for _, s := range spans {
+		senders[s.ip] = true
+	}
+	if len(senders) == 1 {
+		return []string{spans[0].ip}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.suspects == nil {
+		b.suspects = map[int]*suspicion{}
+	}
+	if _, ok := b.suspects[index]; ok {
+		// Already waiting for a clean copy; the first failure is the one we
+		// can compare against it.
+		return nil
+	}
+	kept := make([]suspectSpan, len(spans))
+	for i, s := range spans {
+		kept[i] = suspectSpan{s, sha1.Sum(buf[s.begin : s.begin+s.length])}
+	}
+	b.suspects[index] = &suspicion{spans: kept, at: time.Now()}
+	return nil
+}
+
+// verified settles a piece that passed its hash check. If an earlier copy
+// of it failed with several senders, it returns the addresses whose spans
+// differ from the good copy.
+func (b *blame) verified(index int, buf []byte) []string {
+	b.mu.Lock()
+	kept, ok := b.suspects[index]
+	delete(b.suspects, index)
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	var guilty []string
+	for _, s := range kept.spans {
+		if sha1.Sum(buf[s.begin:s.begin+s.length]) != s.sum {
+			guilty = append(guilty, s.ip)
+		}
+	}
+	return guilty
+}
+
+// suspect reports whether ip sent part of a failed copy of piece index that
+// hasn't been settled yet.
+func (b *blame) suspect(index int, ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.suspects[index].has(ip.String())
+}
+
+// excludes reports whether ip must leave piece index to other peers: it is
+// a suspect, and the piece failed less than settleTimeout ago.
+func (b *blame) excludes(index int, ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.suspects[index]
+	if !s.has(ip.String()) {
+		return false
+	}
+	settle := b.settle
+	if settle <= 0 {
+		settle = settleTimeout
+	}
+	return time.Since(s.at) < settle
+}
+
+// has reports whether ip sent any span of the failed copy.
+func (s *suspicion) has(ip string) bool {
+	if s == nil {
+		return false
+	}
+	for _, sp := range s.spans {
+		if sp.ip == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Torrent) banThreshold() int {
+	if t.BanThreshold > 0 {
+		return t.BanThreshold
+	}
+	return DefaultBanThreshold
+}
+
+// corrupted charges the addresses that sent corrupt data, banning those
+// that reach the ban threshold.
+func (t *Torrent) corrupted(index int, guilty []string) {
+	for _, ip := range guilty {
+		n := t.reputation.addCorrupt(ip)
+		log.Printf("Piece %d: %s sent corrupt data (%d times)", index, ip, n)
+		if n >= t.banThreshold() {
+			log.Printf("Banning %s for sending corrupt data", ip)
+			t.reputation.ban(ip)
+		}
+	}
+}
+
+// trusted narrows a picker filter for peer p to pieces p may settle: those
+// it didn't contribute to a failed copy of, unless nobody else took the
+// piece in time.
+func (t *Torrent) trusted(p peer.Peer, has func(int) bool) func(int) bool {
+	return func(index int) bool {
+		return has(index) && !t.blame.excludes(index, p.IP)
+	}
+}
@@ -0,0 +1,199 @@
+package torrentfile
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// corrupt returns a copy of the block in p with its first byte flipped.
+func corrupt(p peer.Piece) peer.Piece {
+	p.Block = bytes.Clone(p.Block)
+	p.Block[0] ^= 0xff
+	return p
+}
+
+func TestCorruptedBanThreshold(t *testing.T) {
+	var to Torrent
+	ip := net.IPv4(192, 0, 2, 1)
+	for i := 1; i < DefaultBanThreshold; i++ {
+		to.corrupted(i, []string{ip.String()})
+		if to.reputation.banned(ip) {
+			t.Fatalf("banned after %d corrupt pieces, want %d", i, DefaultBanThreshold)
+		}
+	}
+	to.corrupted(0, []string{ip.String()})
+	if !to.reputation.banned(ip) {
+		t.Fatalf("not banned after %d corrupt pieces", DefaultBanThreshold)
+	}
+}
+
+// TestSmartBanSinglePeer has a peer corrupt every piece it sends. It must
+// be banned once it reaches DefaultBanThreshold, after which an honest
+// peer completes the download.
+func TestSmartBanSinglePeer(t *testing.T) {
+	const pieceLen = 2 * blockSize
+	payload := testPayload(8 * pieceLen)
+	liar := seed(t, "127.0.0.2", payload, pieceLen, false, func(c *seedConn) {
+		c.send(peer.Unchoke{})
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			if req, ok := msg.(peer.Request); ok {
+				c.send(corrupt(c.piece(req)))
+			}
+		}
+	})
+	to := testTorrent(t, payload, pieceLen, liar)
+	done := startDownload(t, to)
+	eventually(t, "the liar is banned", func() bool { return to.reputation.banned(liar.IP) })
+	to.reputation.mu.Lock()
+	n := to.reputation.corrupt[liar.IP.String()]
+	to.reputation.mu.Unlock()
+	if n != DefaultBanThreshold {
+		t.Errorf("liar was banned after %d corrupt pieces, want %d", n, DefaultBanThreshold)
+	}
+
+	honest := seed(t, "127.0.0.3", payload, pieceLen, false, serveAll)
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{honest}); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, done, 30*time.Second)
+	if to.reputation.banned(honest.IP) {
+		t.Error("honest peer was banned")
+	}
+}
+
+// TestSmartBanSharedPiece has two peers build one piece: the liar sends a
+// corrupt first block and leaves, and the helper completes the piece, which
+// fails its hash check. Neither may settle the piece, so a third peer
+// fetches it again, and comparing the good copy with the blocks of the bad
+// one must convict the liar alone.
+func TestSmartBanSharedPiece(t *testing.T) {
+	const pieceLen = 2 * blockSize
+	payload := testPayload(pieceLen)
+	left := make(chan struct{})
+	liar := seed(t, "127.0.0.2", payload, pieceLen, false, func(c *seedConn) {
+		defer close(left)
+		c.send(peer.Unchoke{})
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			req, ok := msg.(peer.Request)
+			if !ok {
+				continue
+			}
+			if req.Begin > 0 {
+				// Leave with half the piece sent. Every request has been
+				// read, so the connection closes cleanly.
+				return
+			}
+			c.send(corrupt(c.piece(req)))
+		}
+	})
+	helper := seed(t, "127.0.0.3", payload, pieceLen, false, serveAll)
+	settler := seed(t, "127.0.0.4", payload, pieceLen, false, serveAll)
+
+	to := testTorrent(t, payload, pieceLen, liar)
+	to.BanThreshold = 1
+	done := startDownload(t, to)
+	<-left
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{helper}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the shared piece fails", func() bool {
+		return to.blame.suspect(0, liar.IP) && to.blame.suspect(0, helper.IP)
+	})
+	if to.reputation.banned(liar.IP) || to.reputation.banned(helper.IP) {
+		t.Fatal("a sender was banned before the piece was settled")
+	}
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{settler}); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, done, 30*time.Second)
+
+	if !to.reputation.banned(liar.IP) {
+		t.Error("liar was not banned")
+	}
+	if to.reputation.banned(helper.IP) {
+		t.Error("helper was banned along with the liar")
+	}
+	if to.reputation.banned(settler.IP) {
+		t.Error("settler was banned")
+	}
+	got, err := os.ReadFile(filepath.Join(to.IncompleteDir, to.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("downloaded payload differs")
+	}
+}
+
+// TestSmartBanTwoPeers builds a corrupt piece from the only two peers of
+// the swarm. With nobody else to settle it, one of the suspects must fetch
+// it again alone, and the good copy still convicts the liar alone.
+func TestSmartBanTwoPeers(t *testing.T) {
+	const pieceLen = 2 * blockSize
+	payload := testPayload(pieceLen)
+	var once sync.Once
+	left := make(chan struct{})
+	liar := seed(t, "127.0.0.2", payload, pieceLen, false, func(c *seedConn) {
+		first := false
+		once.Do(func() { first = true })
+		if !first {
+			return // it lied once and stays away
+		}
+		defer close(left)
+		c.send(peer.Unchoke{})
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			req, ok := msg.(peer.Request)
+			if !ok {
+				continue
+			}
+			if req.Begin > 0 {
+				return
+			}
+			c.send(corrupt(c.piece(req)))
+		}
+	})
+	helper := seed(t, "127.0.0.3", payload, pieceLen, false, serveAll)
+
+	to := testTorrent(t, payload, pieceLen, liar)
+	to.BanThreshold = 1
+	to.blame.settle = 200 * time.Millisecond
+	done := startDownload(t, to)
+	<-left
+	if err := to.AddPeers(SourceTracker, to.InfoHash, []peer.Peer{helper}); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, done, 10*time.Second)
+
+	if !to.reputation.banned(liar.IP) {
+		t.Error("liar was not banned")
+	}
+	if to.reputation.banned(helper.IP) {
+		t.Error("helper was banned along with the liar")
+	}
+	got, err := os.ReadFile(filepath.Join(to.IncompleteDir, to.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("downloaded payload differs")
+	}
+}
@@ -0,0 +1,188 @@
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// seedConn is the seeder's side of a connection with the Torrent under
+// test, after the handshake.
+type seedConn struct {
+	net.Conn
+	payload  []byte
+	pieceLen int
+	r        *peer.Reader
+}
+
+func (c *seedConn) send(msg peer.Msg) error {
+	_, err := c.Write(peer.Encode(msg))
+	return err
+}
+
+// read returns the next message; it is only valid until the next read.
+func (c *seedConn) read() (peer.Msg, error) {
+	return c.r.Read()
+}
+
+// piece answers req with the data from the payload.
+func (c *seedConn) piece(req peer.Request) peer.Piece {
+	off := req.Index*c.pieceLen + req.Begin
+	return peer.Piece{Index: req.Index, Begin: req.Begin, Block: c.payload[off : off+req.Length]}
+}
+
+// serveAll unchokes and answers every request until the connection ends.
+func serveAll(c *seedConn) {
+	c.send(peer.Unchoke{})
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return
+		}
+		if req, ok := msg.(peer.Request); ok {
+			c.send(c.piece(req))
+		}
+	}
+}
+
+// seed starts a peer listening on the loopback address ip that has all of
+// payload, and runs serve on every connection once the handshake is done.
+// Peers on different addresses of 127.0.0.0/8 count as different hosts
+// for bans. fast offers the fast extension, with a have all in place of
+// the bitfield.
+func seed(t *testing.T, ip string, payload []byte, pieceLen int, fast bool, serve func(*seedConn)) peer.Peer {
+	t.Helper()
+	ln, err := net.Listen("tcp", ip+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				hs, err := peer.ReadHandshake(conn)
+				if err != nil {
+					return
+				}
+				res := peer.Handshake{Pstr: hs.Pstr, InfoHash: hs.InfoHash}
+				if fast {
+					res.Reserved[7] |= peer.ReservedFast
+				}
+				if _, err := conn.Write(res.Serialize()); err != nil {
+					return
+				}
+				c := &seedConn{Conn: conn, payload: payload, pieceLen: pieceLen, r: peer.NewReader(conn, peer.DefaultLimits)}
+				if fast {
+					c.send(peer.HaveAll{})
+				}
+				serve(c)
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+// testPayload returns n bytes that differ from piece to piece.
+func testPayload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7 % 251)
+	}
+	return b
+}
+
+// testTorrent returns a v1 Torrent of payload that downloads from peers
+// into a temporary directory.
+func testTorrent(t *testing.T, payload []byte, pieceLen int, peers ...peer.Peer) *Torrent {
+	to := &Torrent{
+		Peers:         peers,
+		InfoHash:      sha1.Sum([]byte(t.Name())),
+		PieceLength:   pieceLen,
+		Length:        len(payload),
+		Name:          "payload",
+		IncompleteDir: t.TempDir(),
+	}
+	for begin := 0; begin < len(payload); begin += pieceLen {
+		to.PieceHashes = append(to.PieceHashes, sha1.Sum(payload[begin:min(begin+pieceLen, len(payload))]))
+	}
+	return to
+}
+
+// startDownload runs to.Download in the background and waits until it has
+// set up. The returned channel yields its result.
+func startDownload(t *testing.T, to *Torrent) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- to.Download() }()
+	t.Cleanup(func() { to.Close() })
+	to.mu.Lock()
+	ready := to.readyChan()
+	to.mu.Unlock()
+	<-ready
+	return done
+}
+
+// wait fails the test unless the download ends well within d.
+func wait(t *testing.T, done <-chan error, d time.Duration) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(d):
+		t.Fatal("download did not finish in time")
+	}
+}
+
+// eventually fails the test unless cond becomes true within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+	}
+}
+
+// TestHaveAfterFailedPick has a peer that starts out with only the first
+// piece and announces the others with haves once that one is done. The
+// worker, finding nothing else to fetch from it, must keep reading to see
+// them.
+func TestHaveAfterFailedPick(t *testing.T) {
+	const pieceLen = 2 * blockSize
+	payload := testPayload(3 * pieceLen)
+	p := seed(t, "127.0.0.1", payload, pieceLen, false, func(c *seedConn) {
+		c.send(peer.Bitfield{0x80})
+		c.send(peer.Unchoke{})
+		served := 0
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return
+			}
+			req, ok := msg.(peer.Request)
+			if !ok {
+				continue
+			}
+			c.send(c.piece(req))
+			if served++; served == pieceLen/blockSize {
+				// The first pick after piece 0 has failed by now.
+				time.Sleep(100 * time.Millisecond)
+				c.send(peer.Have{Index: 1})
+				c.send(peer.Have{Index: 2})
+			}
+		}
+	})
+	to := testTorrent(t, payload, pieceLen, p)
+	wait(t, startDownload(t, to), 5*time.Second)
+}
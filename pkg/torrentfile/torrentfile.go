@@ -1,60 +1,197 @@
 package torrentfile
 
 import (
-	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
 )
 
+// MaxTorrentSize caps how much of a .torrent file Open will read.
+const MaxTorrentSize = 64 << 20
+
 // bencodeTorrent is the internak representation of a torrent file. It is used to unmarshal the bencoded data from the torrent file.
 type bencodeTorrent struct {
-	Announce string      `bencode:"announce"` // The URL of the tracker that coordinates the torrent swarm
-	Info     bencodeInfo `bencode:"info"` // The file metadata, which includes the piece hashes, piece length, total length, and file name
+	Announce     string      `bencode:"announce,omitempty"`      // The URL of the tracker that coordinates the torrent swarm
+	AnnounceList [][]string  `bencode:"announce-list,omitempty"` // Tiers of backup trackers (BEP 12)
+	Comment      string      `bencode:"comment,omitempty"`
+	CreatedBy    string      `bencode:"created by,omitempty"`
+	CreationDate int64       `bencode:"creation date,omitempty"` // Unix time
+	Info         bencodeInfo `bencode:"info"`                    // The file metadata, which includes the piece hashes, piece length, total length, and file name
+	URLList      urlList     `bencode:"url-list,omitempty"`      // Web seeds (BEP 19)
+	// PieceLayers maps each v2 file's pieces root to its concatenated piece hashes (BEP 52)
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+
+	// infoBytes is the info dictionary exactly as it appeared in the file.
+	// Hashing these bytes instead of re-encoding Info keeps keys we don't
+	// model (md5sum, attr, ...) in the infohash.
+	infoBytes bencode.RawMessage
+}
+
+// urlList accepts url-list both as a list and as the single string some creators write.
+type urlList []string
+
+func (u *urlList) UnmarshalBencode(b []byte) error {
+	var one string
+	if err := bencode.Unmarshal(b, &one); err == nil {
+		*u = urlList{one}
+		return nil
+	}
+	return bencode.Unmarshal(b, (*[]string)(u))
 }
 
 // bencodeInfo contains the actual file metadata
 type bencodeInfo struct {
-	Pieces      string `bencode:"pieces"` // A string containing the concatenated SHA-1 hashes of each piece
-	PieceLength int    `bencode:"piece length"` // The length of each piece
-	Length      int    `bencode:"length"` // The total length of the file
-	Name        string `bencode:"name"` // The name of the file being shared
+	Pieces      string        `bencode:"pieces"`                 // A string containing the concatenated SHA-1 hashes of each piece
+	PieceLength int           `bencode:"piece length"`           // The length of each piece
+	Length      int           `bencode:"length,omitempty"`       // The total length of the file (single-file torrents only)
+	Files       []bencodeFile `bencode:"files,omitempty"`        // The file list (multi-file torrents only)
+	Name        string        `bencode:"name"`                   // The name of the file, or of the directory holding the files
+	Private     int           `bencode:"private,omitempty"`      // 1 restricts peer discovery to the trackers (BEP 27)
+	Source      string        `bencode:"source,omitempty"`       // Tag that makes cross-seeded torrents hash differently
+	MetaVersion int           `bencode:"meta version,omitempty"` // 2 for BitTorrent v2 (BEP 52) and hybrid torrents
+	// FileTree is the v2 nested file dictionary, parsed by V2Files
+	FileTree bencode.RawMessage `bencode:"file tree,omitempty"`
+}
+
+// bencodeFile is one entry of the files list in a multi-file torrent
+type bencodeFile struct {
+	Length int      `bencode:"length"`         // The length of the file in bytes
+	Path   []string `bencode:"path"`           // Path components below the torrent's directory
+	Attr   string   `bencode:"attr,omitempty"` // BEP 47 attributes; "p" marks a padding file
 }
 
+// Open reads and parses a .torrent file.
 func Open(path string) (bencodeTorrent, error) {
+	data, err := ReadFile(path)
+	if err != nil {
+		return bencodeTorrent{}, err
+	}
+	return Parse(data)
+}
+
+// ReadFile reads a .torrent file, refusing ones larger than MaxTorrentSize.
+func ReadFile(path string) ([]byte, error) {
 	// open the file from the disk
 	file, err := os.Open(path)
 	if err != nil {
-		return bencodeTorrent{}, err
+		return nil, err
 	}
 	defer file.Close()
 
+	data, err := io.ReadAll(io.LimitReader(file, MaxTorrentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxTorrentSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, MaxTorrentSize)
+	}
+	return data, nil
+}
+
+// Parse decodes the contents of a .torrent file.
+func Parse(data []byte) (bencodeTorrent, error) {
 	//create an empty struct to hold our data
 	bto := bencodeTorrent{}
 
-	// unmarshal the bencoded data from the file into our struct
-	err = bencode.Unmarshal(file, &bto)
-	if err != nil {
+	// unmarshal the bencoded data into our struct
+	if err := bencode.Unmarshal(data, &bto); err != nil {
 		return bencodeTorrent{}, err
 	}
 
+	// a second pass keeps the raw info dictionary for the infohash
+	var raw struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.Unmarshal(data, &raw); err != nil {
+		return bencodeTorrent{}, err
+	}
+	if raw.Info == nil {
+		return bencodeTorrent{}, fmt.Errorf("torrent has no info dictionary")
+	}
+	bto.infoBytes = raw.Info
+
 	return bto, nil
 }
 
 // InfoHash calculates the SHA-1 hash of the bencoded info dictionary.
 // This is the unique ID used to identify the torrent to trackers and peers.
 func (b *bencodeTorrent) InfoHash() ([20]byte, error) {
-	var buf bytes.Buffer
-	// We must marshal the Info section back to bencode to get the correct hash
-	err := bencode.Marshal(&buf, b.Info)
-
+	info, err := b.InfoBytes()
 	if err != nil {
 		return [20]byte{}, err
 	}
 	// generates the 20-byte fingerprint of the file metadata
-	return sha1.Sum(buf.Bytes()), nil
+	return sha1.Sum(info), nil
+}
+
+// InfoHashV2 returns the SHA-256 infohash of a v2 or hybrid torrent. ok is
+// false for v1-only torrents, which don't have one.
+func (b *bencodeTorrent) InfoHashV2() (hash [32]byte, ok bool) {
+	if b.Info.MetaVersion != 2 {
+		return hash, false
+	}
+	info, err := b.InfoBytes()
+	if err != nil {
+		return hash, false
+	}
+	return sha256.Sum256(info), true
+}
+
+// Trackers returns every announce URL: the announce-list tiers in order when
+// present (BEP 12 says to prefer them), otherwise the single announce URL.
+func (b *bencodeTorrent) Trackers() []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, tier := range b.AnnounceList {
+		for _, u := range tier {
+			if u != "" && !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+	if b.Announce != "" && !seen[b.Announce] {
+		urls = append(urls, b.Announce)
+	}
+	return urls
+}
+
+// Tiers returns the announce URLs in BEP 12 tiers, to be tried in order:
+// the announce-list when present, otherwise one tier holding the single
+// announce URL.
+func (b *bencodeTorrent) Tiers() [][]string {
+	var tiers [][]string
+	for _, tier := range b.AnnounceList {
+		var urls []string
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) > 0 {
+			tiers = append(tiers, urls)
+		}
+	}
+	if len(tiers) == 0 && b.Announce != "" {
+		tiers = [][]string{{b.Announce}}
+	}
+	return tiers
+}
+
+// InfoBytes returns the bencoded info dictionary: the original bytes for a
+// parsed torrent, the canonical encoding of Info for one built in memory.
+func (b *bencodeTorrent) InfoBytes() ([]byte, error) {
+	if b.infoBytes != nil {
+		return b.infoBytes, nil
+	}
+	return bencode.Marshal(b.Info)
 }
 
 // SplitPieceHashes breaks the giant Pieces string into a slice of 20-byte hashes.
@@ -75,3 +212,80 @@ func (b *bencodeTorrent) SplitPieceHashes() ([][20]byte, error) {
 	}
 	return hashes, nil
 }
+
+// TotalLength returns the number of bytes the files hold, without any padding.
+func (b *bencodeTorrent) TotalLength() int {
+	total := 0
+	for _, f := range b.FileList() {
+		if !f.Padding {
+			total += int(f.Length)
+		}
+	}
+	return total
+}
+
+// FileList returns the payload files in the order they are hashed. Multi-file
+// torrents live in a directory named after the torrent. Padding files (BEP 47
+// in v1 and hybrid torrents, implied by the layout in v2-only ones) are
+// included and marked, since they take up room in the pieces.
+func (b *bencodeTorrent) FileList() []storage.File {
+	if !b.IsV1() && b.IsV2() {
+		if files, err := b.V2Files(); err == nil {
+			return v2FileList(files, int64(b.Info.PieceLength))
+		}
+	}
+	if len(b.Info.Files) == 0 {
+		return []storage.File{{Path: b.Info.Name, Length: int64(b.Info.Length)}}
+	}
+	files := make([]storage.File, len(b.Info.Files))
+	for i, f := range b.Info.Files {
+		files[i] = storage.File{
+			Path:    filepath.Join(append([]string{b.Info.Name}, f.Path...)...),
+			Length:  int64(f.Length),
+			Padding: strings.Contains(f.Attr, "p"),
+		}
+	}
+	return files
+}
+
+// Torrent returns a Torrent carrying b's payload description: piece hashes,
+// v2 merkle trees and the file layout. Peers and download options are left
+// for the caller to fill in.
+func (b *bencodeTorrent) Torrent() (*Torrent, error) {
+	if err := b.CheckHybrid(); err != nil {
+		return nil, err
+	}
+	infoHash, err := b.WireInfoHash()
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := b.SplitPieceHashes()
+	if err != nil {
+		return nil, err
+	}
+	var v2Files []V2File
+	if b.IsV2() {
+		if v2Files, err = b.V2Files(); err != nil {
+			return nil, err
+		}
+	}
+	if b.Info.PieceLength <= 0 {
+		return nil, fmt.Errorf("invalid piece length %d", b.Info.PieceLength)
+	}
+	files := b.FileList()
+	// v2 files start on piece boundaries, so the layout can be longer than the data.
+	length := 0
+	for _, f := range files {
+		length += int(f.Length)
+	}
+	return &Torrent{
+		InfoHash:    infoHash,
+		PieceHashes: hashes,
+		V2Files:     v2Files,
+		PieceLength: b.Info.PieceLength,
+		Length:      length,
+		Name:        b.Info.Name,
+		Files:       files,
+		Private:     b.Info.Private == 1,
+	}, nil
+}
@@ -0,0 +1,446 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+// merkleBlockSize is the leaf size of BitTorrent v2 merkle trees (BEP 52).
+const merkleBlockSize = 16384
+
+// V2File is one file of a BitTorrent v2 torrent. Each file has its own merkle
+// tree over 16 KiB blocks; PiecesRoot is its root and Layer the hashes at
+// piece granularity, taken from the torrent's "piece layers" or fetched from
+// peers with hash requests.
+type V2File struct {
+	Path       string
+	Length     int64
+	PiecesRoot [32]byte
+	// Layer has one hash per piece of the file. It is nil for files no larger
+	// than a piece, where PiecesRoot is the only hash needed, and for files
+	// whose layer hasn't been obtained yet.
+	Layer [][32]byte
+
+	parts []string // path components as stored in the file tree, for Validate
+}
+
+// IsV2 reports whether the torrent carries v2 metadata (it may also be hybrid).
+func (b *bencodeTorrent) IsV2() bool {
+	return b.Info.MetaVersion == 2 && len(b.Info.FileTree) > 0
+}
+
+// IsV1 reports whether the torrent carries v1 SHA-1 piece hashes.
+func (b *bencodeTorrent) IsV1() bool {
+	return b.Info.Pieces != ""
+}
+
+// Version names the metadata flavour: "v1", "v2" or "hybrid".
+func (b *bencodeTorrent) Version() string {
+	switch {
+	case b.IsV1() && b.IsV2():
+		return "hybrid"
+	case b.IsV2():
+		return "v2"
+	}
+	return "v1"
+}
+
+// WireInfoHash is the 20-byte infohash used in handshakes and announces: the
+// SHA-1 infohash when the torrent has v1 metadata, otherwise the SHA-256
+// infohash truncated to 20 bytes as BEP 52 prescribes.
+func (b *bencodeTorrent) WireInfoHash() ([20]byte, error) {
+	if b.IsV1() || !b.IsV2() {
+		return b.InfoHash()
+	}
+	v2, _ := b.InfoHashV2()
+	var h [20]byte
+	copy(h[:], v2[:20])
+	return h, nil
+}
+
+// NumPieces returns the number of pieces, counting v2 files piece-aligned.
+func (b *bencodeTorrent) NumPieces() int {
+	if b.IsV1() {
+		return len(b.Info.Pieces) / 20
+	}
+	if b.Info.PieceLength <= 0 {
+		return 0
+	}
+	total := 0
+	for _, f := range b.FileList() {
+		total += int(f.Length)
+	}
+	return (total + b.Info.PieceLength - 1) / b.Info.PieceLength
+}
+
+// V2Files parses the file tree and attaches each file's piece layer,
+// checking every layer against its file's pieces root.
+func (b *bencodeTorrent) V2Files() ([]V2File, error) {
+	if !b.IsV2() {
+		return nil, fmt.Errorf("not a v2 torrent")
+	}
+	pl := int64(b.Info.PieceLength)
+	if pl < merkleBlockSize || bits.OnesCount64(uint64(pl)) != 1 {
+		return nil, fmt.Errorf("v2 piece length %d is not a power of two >= 16 KiB", pl)
+	}
+
+	var files []V2File
+	if err := walkFileTree(b.Info.FileTree, nil, &files); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("file tree is empty")
+	}
+	// A torrent whose tree holds a single file at the top level is a
+	// single-file torrent; otherwise everything lives under the torrent's name.
+	if !(len(files) == 1 && filepath.Dir(files[0].Path) == ".") {
+		for i := range files {
+			files[i].Path = filepath.Join(b.Info.Name, files[i].Path)
+		}
+	}
+
+	for i := range files {
+		f := &files[i]
+		if f.Length <= pl {
+			continue
+		}
+		layer, ok := b.PieceLayers[string(f.PiecesRoot[:])]
+		if !ok {
+			// Leave Layer nil; the download fetches it with hash requests.
+			continue
+		}
+		hashes, err := parseLayer(layer, f.Length, pl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Path, err)
+		}
+		if err := checkLayer(hashes, f.PiecesRoot, pl); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Path, err)
+		}
+		f.Layer = hashes
+	}
+	return files, nil
+}
+
+// walkFileTree flattens the nested "file tree" dictionary. A file is a
+// dictionary with a single "" key holding its length and pieces root.
+func walkFileTree(raw bencode.RawMessage, dir []string, files *[]V2File) error {
+	var node map[string]bencode.RawMessage
+	if err := bencode.Unmarshal(raw, &node); err != nil {
+		return fmt.Errorf("file tree: %v", err)
+	}
+	names := make([]string, 0, len(node))
+	for name := range node {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "" {
+			var leaf struct {
+				Length     int64  `bencode:"length"`
+				PiecesRoot []byte `bencode:"pieces root"`
+			}
+			if err := bencode.Unmarshal(node[name], &leaf); err != nil {
+				return fmt.Errorf("file tree entry %v: %v", dir, err)
+			}
+			if len(dir) == 0 {
+				return fmt.Errorf("file tree has a file without a name")
+			}
+			f := V2File{Path: filepath.Join(dir...), Length: leaf.Length, parts: dir}
+			if leaf.Length > 0 {
+				if len(leaf.PiecesRoot) != 32 {
+					return fmt.Errorf("%s: pieces root must be 32 bytes", f.Path)
+				}
+				copy(f.PiecesRoot[:], leaf.PiecesRoot)
+			}
+			*files = append(*files, f)
+			continue
+		}
+		if err := walkFileTree(node[name], append(dir[:len(dir):len(dir)], name), files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseLayer(layer string, length, pieceLength int64) ([][32]byte, error) {
+	numPieces := (length + pieceLength - 1) / pieceLength
+	if int64(len(layer)) != numPieces*32 {
+		return nil, fmt.Errorf("piece layer has %d bytes, want %d", len(layer), numPieces*32)
+	}
+	hashes := make([][32]byte, numPieces)
+	for i := range hashes {
+		copy(hashes[i][:], layer[i*32:])
+	}
+	return hashes, nil
+}
+
+// checkLayer verifies that a file's piece layer hashes up to its pieces root.
+func checkLayer(layer [][32]byte, root [32]byte, pieceLength int64) error {
+	got := merkleRoot(layer, nextPow2(len(layer)), padHash(pieceLayerHeight(pieceLength)))
+	if got != root {
+		return fmt.Errorf("piece layer does not match pieces root")
+	}
+	return nil
+}
+
+// pieceLayerHeight is the number of tree levels between the leaves and the piece layer.
+func pieceLayerHeight(pieceLength int64) int {
+	return bits.TrailingZeros64(uint64(pieceLength / merkleBlockSize))
+}
+
+func nextPow2(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// padHash is the root of a subtree of 2^height all-zero leaf hashes, which is
+// what stands in for hashes past the end of a file at that height.
+func padHash(height int) [32]byte {
+	var h [32]byte
+	for i := 0; i < height; i++ {
+		h = hashPair(h, h)
+	}
+	return h
+}
+
+func hashPair(a, b [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], a[:])
+	copy(buf[32:], b[:])
+	return sha256.Sum256(buf[:])
+}
+
+// merkleRoot computes the root of a tree whose bottom layer is hashes,
+// padded to width entries with pad.
+func merkleRoot(hashes [][32]byte, width int, pad [32]byte) [32]byte {
+	layer := make([][32]byte, width)
+	copy(layer, hashes)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+	for len(layer) > 1 {
+		next := layer[:len(layer)/2]
+		for i := range next {
+			next[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+// blockHashes returns the SHA-256 leaf hashes of data's 16 KiB blocks.
+func blockHashes(data []byte) [][32]byte {
+	hashes := make([][32]byte, 0, (len(data)+merkleBlockSize-1)/merkleBlockSize)
+	for off := 0; off < len(data); off += merkleBlockSize {
+		hashes = append(hashes, sha256.Sum256(data[off:min(len(data), off+merkleBlockSize)]))
+	}
+	return hashes
+}
+
+// v2FileList lays the v2 files out back to back with each file starting on a
+// piece boundary, the way pieces are numbered. The gaps become padding
+// entries, which storage never creates on disk.
+func v2FileList(files []V2File, pieceLength int64) []storage.File {
+	var out []storage.File
+	for i, f := range files {
+		out = append(out, storage.File{Path: f.Path, Length: f.Length})
+		if rem := f.Length % pieceLength; rem != 0 && i < len(files)-1 {
+			out = append(out, storage.File{
+				Path:    fmt.Sprintf(".pad/%d", pieceLength-rem),
+				Length:  pieceLength - rem,
+				Padding: true,
+			})
+		}
+	}
+	return out
+}
+
+// v2Piece locates piece index of a v2 payload: the file it belongs to, the
+// piece's index within that file and how many of its bytes are file data.
+func (t *Torrent) v2Piece(index int) (file *V2File, local int, dataLen int64, err error) {
+	offset := int64(index) * int64(t.PieceLength)
+	var start int64
+	v2 := 0
+	for _, f := range t.files() {
+		end := start + f.Length
+		if f.Padding {
+			start = end
+			continue
+		}
+		if v2 >= len(t.V2Files) {
+			break
+		}
+		if offset >= start && offset < end {
+			file = &t.V2Files[v2]
+			local = int((offset - start) / int64(t.PieceLength))
+			dataLen = min(int64(t.PieceLength), end-offset)
+			return file, local, dataLen, nil
+		}
+		start = end
+		v2++
+	}
+	return nil, 0, 0, fmt.Errorf("piece %d is not inside any v2 file", index)
+}
+
+// verifyV2 checks a piece against its file's merkle tree. The piece buffer may
+// extend past the end of the file into padding; only the file's bytes count.
+func (t *Torrent) verifyV2(index int, buf []byte) error {
+	t.mu.Lock()
+	f, local, dataLen, err := t.v2Piece(index)
+	var root [32]byte
+	var want [32]byte
+	var layer bool
+	if err == nil {
+		root = f.PiecesRoot
+		if f.Length > int64(t.PieceLength) {
+			if f.Layer == nil {
+				err = fmt.Errorf("no piece layer for %s yet", f.Path)
+			} else {
+				want, layer = f.Layer[local], true
+			}
+		}
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	leaves := blockHashes(buf[:dataLen])
+	var got [32]byte
+	if layer {
+		// A full piece subtree, zero leaves past the end of the file.
+		got = merkleRoot(leaves, int(int64(t.PieceLength)/merkleBlockSize), [32]byte{})
+	} else {
+		// The whole file fits in this piece, so its tree is sized to the file.
+		got = merkleRoot(leaves, nextPow2(len(leaves)), [32]byte{})
+		want = root
+	}
+	if !bytes.Equal(got[:], want[:]) {
+		return fmt.Errorf("merkle root mismatch: expected %x, got %x", want, got)
+	}
+	return nil
+}
+
+// missingLayer returns the file of piece index when that file's piece layer
+// still has to be fetched from a peer before the piece can be verified.
+// Pieces with a v1 hash never need one.
+func (t *Torrent) missingLayer(index int) *V2File {
+	if len(t.V2Files) == 0 || index < len(t.PieceHashes) {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, _, _, err := t.v2Piece(index)
+	if err != nil || f.Length <= int64(t.PieceLength) || f.Layer != nil {
+		return nil
+	}
+	return f
+}
+
+// setLayer installs a piece layer received from a peer after checking it against the pieces root.
+func (t *Torrent) setLayer(f *V2File, hashes [][32]byte) error {
+	numPieces := int((f.Length + int64(t.PieceLength) - 1) / int64(t.PieceLength))
+	if len(hashes) < numPieces {
+		return fmt.Errorf("got %d layer hashes, want %d", len(hashes), numPieces)
+	}
+	hashes = hashes[:numPieces]
+	if err := checkLayer(hashes, f.PiecesRoot, int64(t.PieceLength)); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f.Layer = hashes
+	return nil
+}
+
+// maxHashes is the most hashes a single hash request may ask for (BEP 52).
+const maxHashes = 512
+
+// proofRoot hashes a run of hashes from one layer of a tree up to the root,
+// using the uncle hashes of its proof from the bottom up. The run is a
+// power of two long and starts at index, a multiple of its length.
+func proofRoot(hashes [][32]byte, index int, uncles [][32]byte) [32]byte {
+	h := merkleRoot(hashes, len(hashes), [32]byte{})
+	pos := index / len(hashes)
+	for _, u := range uncles {
+		if pos%2 == 0 {
+			h = hashPair(h, u)
+		} else {
+			h = hashPair(u, h)
+		}
+		pos /= 2
+	}
+	return h
+}
+
+// fetchLayer asks the peer for a file's piece layer with BEP 52 hash
+// requests of at most maxHashes hashes each. Every part comes with the
+// uncle hashes proving it against the pieces root, and the layer is
+// installed once all parts are in.
+func (t *Torrent) fetchLayer(pc *peerConn, f *V2File) error {
+	pc.deadline = time.Now().Add(pc.timeouts.request)
+	defer func() { pc.deadline = time.Time{} }()
+
+	numPieces := int((f.Length + int64(t.PieceLength) - 1) / int64(t.PieceLength))
+	width := max(2, nextPow2(numPieces))
+	length := min(width, maxHashes)
+	proofLayers := bits.TrailingZeros(uint(width / length))
+	pending := map[peer.HashRequest]bool{}
+	for index := 0; index < numPieces; index += length {
+		req := peer.HashRequest{
+			PiecesRoot:  f.PiecesRoot,
+			BaseLayer:   uint32(pieceLayerHeight(int64(t.PieceLength))),
+			Index:       uint32(index),
+			Length:      uint32(length),
+			ProofLayers: uint32(proofLayers),
+		}
+		if err := pc.send(req); err != nil {
+			return err
+		}
+		pending[req] = true
+	}
+
+	layer := make([][32]byte, width)
+	for len(pending) > 0 {
+		msg, err := pc.read()
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case peer.Hashes:
+			if !pending[m.HashRequest] {
+				continue // an answer to some other request
+			}
+			if len(m.Hashes) != length+proofLayers {
+				return fmt.Errorf("got %d hashes for a request of %d with %d proof layers", len(m.Hashes), length, proofLayers)
+			}
+			hashes, uncles := m.Hashes[:length], m.Hashes[length:]
+			if proofRoot(hashes, int(m.Index), uncles) != f.PiecesRoot {
+				return fmt.Errorf("layer hashes %d-%d do not prove against the pieces root", m.Index, int(m.Index)+length-1)
+			}
+			copy(layer[m.Index:], hashes)
+			delete(pending, m.HashRequest)
+		case peer.HashReject:
+			if pending[m.HashRequest] {
+				return fmt.Errorf("peer rejected the hash request")
+			}
+		default:
+			if err := pc.handle(msg); err != nil {
+				return err
+			}
+		}
+	}
+	return t.setLayer(f, layer[:numPieces])
+}
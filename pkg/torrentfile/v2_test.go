@@ -0,0 +1,236 @@
+package torrentfile
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/peer"
+)
+
+// tree returns every layer of the merkle tree over base padded to a power
+// of two with pad, from base up to the root.
+func tree(base [][32]byte, pad [32]byte) [][][32]byte {
+	layer := make([][32]byte, nextPow2(len(base)))
+	copy(layer, base)
+	for i := len(base); i < len(layer); i++ {
+		layer[i] = pad
+	}
+	layers := [][][32]byte{layer}
+	for len(layer) > 1 {
+		next := make([][32]byte, len(layer)/2)
+		for i := range next {
+			next[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layers = append(layers, next)
+		layer = next
+	}
+	return layers
+}
+
+// proof returns the uncle hashes proving the run of length hashes starting
+// at index of the bottom layer of layers, as a peer answers a hash request.
+func proof(layers [][][32]byte, index, length int) [][32]byte {
+	var uncles [][32]byte
+	pos := index / length
+	for _, layer := range layers[bits.TrailingZeros(uint(length)) : len(layers)-1] {
+		uncles = append(uncles, layer[pos^1])
+		pos /= 2
+	}
+	return uncles
+}
+
+func randomHashes(n int) [][32]byte {
+	r := rand.New(rand.NewSource(int64(n)))
+	hashes := make([][32]byte, n)
+	for i := range hashes {
+		r.Read(hashes[i][:])
+	}
+	return hashes
+}
+
+func TestPadHash(t *testing.T) {
+	if padHash(0) != [32]byte{} {
+		t.Error("pad hash of a single leaf is not the zero hash")
+	}
+	zero := sha256.Sum256(make([]byte, 64))
+	if padHash(1) != zero {
+		t.Error("pad hash of two leaves is not the hash of two zero hashes")
+	}
+	if padHash(3) != hashPair(hashPair(zero, zero), hashPair(zero, zero)) {
+		t.Error("pad hash of eight leaves is wrong")
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	h := randomHashes(3)
+	var pad [32]byte
+	pad[0] = 1
+	want := hashPair(hashPair(h[0], h[1]), hashPair(h[2], pad))
+	if got := merkleRoot(h, 4, pad); got != want {
+		t.Errorf("merkleRoot of 3 hashes padded to 4 = %x, want %x", got, want)
+	}
+	if got := merkleRoot(h[:1], 1, pad); got != h[0] {
+		t.Errorf("merkleRoot of a single hash = %x, want the hash itself", got)
+	}
+	if got := merkleRoot(h, 8, pad); got != hashPair(want, hashPair(hashPair(pad, pad), hashPair(pad, pad))) {
+		t.Errorf("merkleRoot padded to 8 = %x", got)
+	}
+	// merkleRoot works in place; the caller's hashes must survive it.
+	if again := randomHashes(3); h[0] != again[0] || h[2] != again[2] {
+		t.Error("merkleRoot modified its input")
+	}
+}
+
+// TestPieceLayer builds a file's tree from its 16 KiB blocks and checks
+// that the piece layer, padded with pad hashes of the right height, leads
+// to the same root. That is what lets pieces be verified against a layer.
+func TestPieceLayer(t *testing.T) {
+	const pieceLength = 4 * merkleBlockSize
+	data := testPayload(5*pieceLength + 3*merkleBlockSize + 100)
+	leaves := blockHashes(data)
+	root := merkleRoot(leaves, nextPow2(len(leaves)), [32]byte{})
+
+	var layer [][32]byte
+	var raw strings.Builder
+	for off := 0; off < len(data); off += pieceLength {
+		piece := blockHashes(data[off:min(off+pieceLength, len(data))])
+		h := merkleRoot(piece, pieceLength/merkleBlockSize, [32]byte{})
+		layer = append(layer, h)
+		raw.Write(h[:])
+	}
+	if err := checkLayer(layer, root, pieceLength); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseLayer(raw.String(), int64(len(data)), pieceLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(layer) || parsed[5] != layer[5] {
+		t.Error("parseLayer does not return the layer")
+	}
+	if _, err := parseLayer(raw.String()[32:], int64(len(data)), pieceLength); err == nil {
+		t.Error("parseLayer accepted a layer one hash short")
+	}
+
+	layer[2][0] ^= 1
+	if err := checkLayer(layer, root, pieceLength); err == nil {
+		t.Error("checkLayer accepted a corrupt layer")
+	}
+}
+
+func TestProofRoot(t *testing.T) {
+	layer := randomHashes(1500)
+	layers := tree(layer, padHash(2))
+	root := layers[len(layers)-1][0]
+	for index := 0; index < len(layer); index += maxHashes {
+		hashes := layers[0][index : index+maxHashes]
+		uncles := proof(layers, index, maxHashes)
+		if len(uncles) != 2 {
+			t.Fatalf("%d uncles for a quarter of the layer, want 2", len(uncles))
+		}
+		if got := proofRoot(hashes, index, uncles); got != root {
+			t.Errorf("hashes from %d do not prove against the root", index)
+		}
+		if got := proofRoot(hashes, index^maxHashes, uncles); got == root {
+			t.Errorf("hashes from %d prove at the wrong index", index)
+		}
+	}
+	if got := proofRoot(layers[0], 0, nil); got != root {
+		t.Error("a whole layer without uncles does not hash to the root")
+	}
+}
+
+// TestFetchLayer fetches the piece layer of a file with more pieces than
+// one hash request may ask for.
+func TestFetchLayer(t *testing.T) {
+	const pieceLength = 4 * merkleBlockSize
+	for _, tt := range []struct {
+		name      string
+		numPieces int
+		requests  int
+		tamper    bool
+	}{
+		{"one request", 300, 1, false},
+		{"split", 1300, 3, false},
+		{"bad proof", 1300, 3, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			layer := randomHashes(tt.numPieces)
+			layers := tree(layer, padHash(pieceLayerHeight(pieceLength)))
+			f := &V2File{Path: "big", Length: int64(tt.numPieces) * pieceLength, PiecesRoot: layers[len(layers)-1][0]}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			served := make(chan []peer.HashRequest, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				var reqs []peer.HashRequest
+				defer func() { served <- reqs }()
+				r := peer.NewReader(conn, peer.DefaultLimits)
+				for {
+					msg, err := r.Read()
+					if err != nil {
+						return
+					}
+					req, ok := msg.(peer.HashRequest)
+					if !ok {
+						continue
+					}
+					reqs = append(reqs, req)
+					if req.Length > maxHashes {
+						conn.Write(peer.Encode(peer.HashReject{HashRequest: req}))
+						continue
+					}
+					index, length := int(req.Index), int(req.Length)
+					hashes := append(append([][32]byte(nil), layers[0][index:index+length]...), proof(layers, index, length)...)
+					if tt.tamper && index > 0 {
+						hashes[len(hashes)-1][0] ^= 1
+					}
+					conn.Write(peer.Encode(peer.Hashes{HashRequest: req, Hashes: hashes}))
+				}
+			}()
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pc := newPeerConn(conn, "test", tt.numPieces, &peer.Handshake{}, defaultTimeouts)
+			defer pc.close()
+
+			to := &Torrent{PieceLength: pieceLength}
+			err = to.fetchLayer(pc, f)
+			conn.Close()
+			reqs := <-served
+			if len(reqs) != tt.requests {
+				t.Errorf("%d hash requests, want %d", len(reqs), tt.requests)
+			}
+			for _, req := range reqs {
+				if req.Length > maxHashes || req.Index%req.Length != 0 {
+					t.Errorf("hash request for %d hashes at %d", req.Length, req.Index)
+				}
+			}
+			if tt.tamper {
+				if err == nil {
+					t.Fatal("layer with a bad proof was accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Layer) != tt.numPieces || f.Layer[tt.numPieces-1] != layer[tt.numPieces-1] {
+				t.Error("fetched layer differs")
+			}
+		})
+	}
+}
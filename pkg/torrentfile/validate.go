@@ -0,0 +1,304 @@
+package torrentfile
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+)
+
+// Severity says whether a Problem makes a torrent unusable.
+type Severity int
+
+const (
+	// SeverityWarning problems are legal but likely to cause trouble with some clients.
+	SeverityWarning Severity = iota
+	// SeverityError problems make the torrent unsafe or impossible to download.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Kind says what sort of mistake a Problem is, for callers that want to
+// act on some and not others.
+type Kind int
+
+const (
+	KindParse       Kind = iota // the file doesn't parse at all
+	KindEncoding                // not canonically encoded
+	KindTrackers                // no trackers to announce to
+	KindPath                    // a name or path that is empty or escapes the download directory
+	KindPortability             // a name that is unusable on Windows or collides by case
+	KindDuplicate               // two files with the same path
+	KindLength                  // file lengths that are negative, missing or contradictory
+	KindPieceLength             // a piece length that is invalid or unusual
+	KindPieces                  // piece hashes that don't match the payload
+	KindFileTree                // an invalid v2 file tree
+	KindHybrid                  // the v1 and v2 halves of a hybrid disagree
+	KindVersion                 // an unsupported meta version
+)
+
+var kindNames = [...]string{
+	KindParse:       "parse",
+	KindEncoding:    "encoding",
+	KindTrackers:    "trackers",
+	KindPath:        "path",
+	KindPortability: "portability",
+	KindDuplicate:   "duplicate",
+	KindLength:      "length",
+	KindPieceLength: "piece length",
+	KindPieces:      "pieces",
+	KindFileTree:    "file tree",
+	KindHybrid:      "hybrid",
+	KindVersion:     "version",
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Problem is one issue found by Validate.
+type Problem struct {
+	Severity Severity
+	Kind     Kind
+	// Field names the part of the metainfo at fault, e.g. "info.piece length".
+	Field   string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Field, p.Message)
+}
+
+// HasErrors reports whether any of problems is an error rather than a warning.
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the contents of a .torrent file for the mistakes that Parse
+// lets through: piece counts that don't match the payload, file paths that
+// would escape the download directory, duplicate files, odd piece sizes and
+// non-canonical encoding. A torrent with no problems returns nil.
+func Validate(data []byte) []Problem {
+	var v validator
+	bto, err := Parse(data)
+	if err != nil {
+		v.errorf(KindParse, "", "cannot parse: %v", err)
+		return v.problems
+	}
+
+	// Clients that re-encode the info dictionary compute a different
+	// infohash when it isn't canonical, so that one is an error.
+	if err := bencode.Valid(bto.infoBytes); err != nil {
+		v.errorf(KindEncoding, "info", "not canonically encoded, infohashes will disagree: %v", err)
+	} else if err := bencode.Valid(data); err != nil {
+		v.warnf(KindEncoding, "", "not canonically encoded: %v", err)
+	}
+
+	if len(bto.Trackers()) == 0 {
+		if bto.Info.Private == 1 {
+			v.errorf(KindTrackers, "announce", "private torrent without a tracker can never find peers")
+		} else {
+			v.warnf(KindTrackers, "announce", "no trackers")
+		}
+	}
+
+	v.checkName(bto.Info.Name)
+	v.checkPieceLength(&bto)
+	v.checkFiles(&bto)
+	if bto.IsV1() {
+		v.checkPieces(&bto)
+	}
+	if bto.IsV2() {
+		if _, err := bto.V2Files(); err != nil {
+			v.errorf(KindFileTree, "info.file tree", "%v", err)
+		} else if err := bto.CheckHybrid(); err != nil {
+			v.errorf(KindHybrid, "info", "%v", err)
+		}
+	}
+	if !bto.IsV1() && !bto.IsV2() {
+		v.errorf(KindVersion, "info", "neither pieces nor a v2 file tree")
+	}
+	if bto.Info.MetaVersion != 0 && bto.Info.MetaVersion != 2 {
+		v.errorf(KindVersion, "info.meta version", "unsupported version %d", bto.Info.MetaVersion)
+	}
+	return v.problems
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(sev Severity, kind Kind, field, msg string) {
+	v.problems = append(v.problems, Problem{sev, kind, field, msg})
+}
+
+func (v *validator) errorf(kind Kind, field, format string, args ...any) {
+	v.add(SeverityError, kind, field, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) warnf(kind Kind, field, format string, args ...any) {
+	v.add(SeverityWarning, kind, field, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) checkName(name string) {
+	if name == "" {
+		v.errorf(KindPath, "info.name", "empty")
+		return
+	}
+	if msg, sev, kind := checkComponent(name); msg != "" {
+		v.add(sev, kind, "info.name", fmt.Sprintf("%q %s", name, msg))
+	}
+}
+
+func (v *validator) checkPieceLength(b *bencodeTorrent) {
+	pl := b.Info.PieceLength
+	switch {
+	case pl <= 0:
+		v.errorf(KindPieceLength, "info.piece length", "%d is not positive", pl)
+	case pl < MinPieceLength:
+		v.warnf(KindPieceLength, "info.piece length", "%d is below %d bytes", pl, MinPieceLength)
+	case pl > MaxPieceLength:
+		v.warnf(KindPieceLength, "info.piece length", "%d is above %d bytes", pl, MaxPieceLength)
+	}
+	// v2 requires a power of two; V2Files reports that as an error.
+	if pl > 0 && !b.IsV2() && bits.OnesCount(uint(pl)) != 1 {
+		v.warnf(KindPieceLength, "info.piece length", "%d is not a power of two", pl)
+	}
+}
+
+// checkPieces compares the number of SHA-1 hashes with the payload size.
+func (v *validator) checkPieces(b *bencodeTorrent) {
+	if len(b.Info.Pieces)%20 != 0 {
+		v.errorf(KindPieces, "info.pieces", "length %d is not a multiple of 20", len(b.Info.Pieces))
+		return
+	}
+	if b.Info.PieceLength <= 0 {
+		return
+	}
+	var total int64
+	for _, f := range b.FileList() {
+		total += f.Length
+	}
+	want := (total + int64(b.Info.PieceLength) - 1) / int64(b.Info.PieceLength)
+	if got := int64(len(b.Info.Pieces) / 20); got != want {
+		v.errorf(KindPieces, "info.pieces", "%d hashes, but %d bytes in pieces of %d need %d", got, total, b.Info.PieceLength, want)
+	}
+}
+
+func (v *validator) checkFiles(b *bencodeTorrent) {
+	info := &b.Info
+	if info.Length != 0 && len(info.Files) > 0 {
+		v.errorf(KindLength, "info", "both length and files are set")
+	}
+	if info.Length < 0 {
+		v.errorf(KindLength, "info.length", "%d is negative", info.Length)
+	}
+
+	// paths holds each file's components, relative to the torrent's directory.
+	type entry struct {
+		field string
+		parts []string
+	}
+	var paths []entry
+	var total int64
+	for i, f := range info.Files {
+		field := fmt.Sprintf("info.files[%d]", i)
+		if f.Length < 0 {
+			v.errorf(KindLength, field+".length", "%d is negative", f.Length)
+		}
+		if len(f.Path) == 0 {
+			v.errorf(KindPath, field+".path", "empty")
+			continue
+		}
+		if !strings.Contains(f.Attr, "p") {
+			total += int64(f.Length)
+			paths = append(paths, entry{field + ".path", f.Path})
+		}
+	}
+	if !b.IsV1() && b.IsV2() {
+		// A hybrid torrent's tree must match its file list (CheckHybrid), so
+		// only v2-only torrents need their tree checked here.
+		if files, err := b.V2Files(); err == nil {
+			for _, f := range files {
+				paths = append(paths, entry{"info.file tree", f.parts})
+			}
+		}
+	}
+	if len(info.Files) == 0 {
+		total = int64(info.Length)
+	}
+	if total == 0 && !b.IsV2() {
+		v.errorf(KindLength, "info", "the torrent has no data")
+	}
+
+	seen := map[string]string{}
+	folded := map[string]string{}
+	for _, e := range paths {
+		p := strings.Join(e.parts, "/")
+		if first, ok := seen[p]; ok {
+			v.errorf(KindDuplicate, e.field, "%q duplicates %s", p, first)
+			continue
+		}
+		seen[p] = e.field
+		for _, c := range e.parts {
+			if msg, sev, kind := checkComponent(c); msg != "" {
+				// One complaint per path is enough.
+				v.add(sev, kind, e.field, fmt.Sprintf("%q: component %q %s", p, c, msg))
+				break
+			}
+		}
+		lp := strings.ToLower(p)
+		if first, ok := folded[lp]; ok {
+			v.warnf(KindPortability, e.field, "%q differs only in case from %s and collides on case-insensitive filesystems", p, first)
+		}
+		folded[lp] = e.field
+	}
+}
+
+// reservedNames cannot be used as file names on Windows, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// checkComponent looks at one path component. It returns a description of
+// what's wrong with it, or "" when it's fine.
+func checkComponent(c string) (string, Severity, Kind) {
+	switch {
+	case c == "":
+		return "is empty", SeverityError, KindPath
+	case c == "." || c == "..":
+		return "escapes the download directory", SeverityError, KindPath
+	case strings.ContainsAny(c, `/\`):
+		return "contains a path separator", SeverityError, KindPath
+	case strings.ContainsRune(c, 0):
+		return "contains a NUL byte", SeverityError, KindPath
+	}
+	base, _, _ := strings.Cut(c, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return "is a reserved name on Windows", SeverityWarning, KindPortability
+	}
+	if strings.ContainsAny(c, `<>:"|?*`) || strings.HasSuffix(c, ".") || strings.HasSuffix(c, " ") {
+		return "is not a valid file name on Windows", SeverityWarning, KindPortability
+	}
+	return "", 0, 0
+}
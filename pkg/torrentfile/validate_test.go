@@ -0,0 +1,147 @@
+package torrentfile
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
+)
+
+// validTorrent returns the dictionary of a multi-file v1 torrent that
+// Validate has nothing to say about, for the cases to break.
+func validTorrent() map[string]any {
+	return map[string]any{
+		"announce": "http://tracker/announce",
+		"info": map[string]any{
+			"name":         "dir",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", 20),
+			"files": []any{
+				map[string]any{"length": 100, "path": []string{"a"}},
+				map[string]any{"length": 200, "path": []string{"sub", "b"}},
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type want struct {
+		sev   Severity
+		kind  Kind
+		field string
+	}
+	files := func(m map[string]any, paths ...[]string) {
+		var fs []any
+		for _, p := range paths {
+			fs = append(fs, map[string]any{"length": 100, "path": p})
+		}
+		m["info"].(map[string]any)["files"] = fs
+	}
+	set := func(m map[string]any, key string, v any) { m["info"].(map[string]any)[key] = v }
+
+	for _, tt := range []struct {
+		name string
+		edit func(m map[string]any)
+		want []want
+	}{
+		{"valid", func(m map[string]any) {}, nil},
+		{"dot dot", func(m map[string]any) { files(m, []string{"..", "etc", "passwd"}) },
+			[]want{{SeverityError, KindPath, "info.files[0].path"}}},
+		{"absolute path", func(m map[string]any) { files(m, []string{"/etc/passwd"}) },
+			[]want{{SeverityError, KindPath, "info.files[0].path"}}},
+		{"rooted components", func(m map[string]any) { files(m, []string{"", "etc", "passwd"}) },
+			[]want{{SeverityError, KindPath, "info.files[0].path"}}},
+		{"windows separator", func(m map[string]any) { files(m, []string{`..\..\x`}) },
+			[]want{{SeverityError, KindPath, "info.files[0].path"}}},
+		{"empty path", func(m map[string]any) { files(m, []string{"a"}, []string{}) },
+			[]want{{SeverityError, KindPath, "info.files[1].path"}}},
+		{"name escapes", func(m map[string]any) { set(m, "name", "..") },
+			[]want{{SeverityError, KindPath, "info.name"}}},
+		{"duplicate", func(m map[string]any) { files(m, []string{"a", "b"}, []string{"c"}, []string{"a", "b"}) },
+			[]want{{SeverityError, KindDuplicate, "info.files[2].path"}}},
+		{"case collision", func(m map[string]any) { files(m, []string{"README"}, []string{"readme"}) },
+			[]want{{SeverityWarning, KindPortability, "info.files[1].path"}}},
+		{"reserved name", func(m map[string]any) { files(m, []string{"sub", "con.txt"}) },
+			[]want{{SeverityWarning, KindPortability, "info.files[0].path"}}},
+		{"zero piece length", func(m map[string]any) { set(m, "piece length", 0) },
+			[]want{{SeverityError, KindPieceLength, "info.piece length"}}},
+		{"odd piece length", func(m map[string]any) { set(m, "piece length", 3*16384) },
+			[]want{{SeverityWarning, KindPieceLength, "info.piece length"}}},
+		{"small piece length", func(m map[string]any) {
+			set(m, "piece length", 1024)
+			set(m, "pieces", strings.Repeat("x", 20))
+			files(m, []string{"a"})
+		}, []want{{SeverityWarning, KindPieceLength, "info.piece length"}}},
+		{"bad piece hash length", func(m map[string]any) { set(m, "pieces", strings.Repeat("x", 21)) },
+			[]want{{SeverityError, KindPieces, "info.pieces"}}},
+		{"too many piece hashes", func(m map[string]any) { set(m, "pieces", strings.Repeat("x", 40)) },
+			[]want{{SeverityError, KindPieces, "info.pieces"}}},
+		{"negative length", func(m map[string]any) {
+			m["info"].(map[string]any)["files"] = []any{map[string]any{"length": -1, "path": []string{"a"}}}
+		}, []want{{SeverityError, KindLength, "info.files[0].length"}, {SeverityError, KindPieces, "info.pieces"}}},
+		{"no trackers", func(m map[string]any) { delete(m, "announce") },
+			[]want{{SeverityWarning, KindTrackers, "announce"}}},
+		{"private without trackers", func(m map[string]any) {
+			delete(m, "announce")
+			set(m, "private", 1)
+		}, []want{{SeverityError, KindTrackers, "announce"}}},
+		{"meta version", func(m map[string]any) { set(m, "meta version", 3) },
+			[]want{{SeverityError, KindVersion, "info.meta version"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validTorrent()
+			tt.edit(m)
+			data, err := bencode.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Validate(data)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Validate = %v, want %d problems", got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				if got[i].Severity != w.sev || got[i].Kind != w.kind || got[i].Field != w.field {
+					t.Errorf("problem %d is %v (%v), want %v: %v: %s", i, got[i], got[i].Kind, w.sev, w.kind, w.field)
+				}
+			}
+			if HasErrors(got) != (len(tt.want) > 0 && tt.want[0].sev == SeverityError) {
+				t.Errorf("HasErrors(%v) = %v", got, HasErrors(got))
+			}
+		})
+	}
+}
+
+// TestValidateEncoding feeds Validate bytes that Marshal would never write.
+func TestValidateEncoding(t *testing.T) {
+	const info = "d6:lengthi100e4:name1:a12:piece lengthi16384e6:pieces20:xxxxxxxxxxxxxxxxxxxxe"
+	for _, tt := range []struct {
+		name  string
+		data  string
+		sev   Severity
+		kind  Kind
+		field string
+	}{
+		{"garbage", "not bencode", SeverityError, KindParse, ""},
+		{"unsorted info", "d8:announce1:x4:infod4:name1:a6:lengthi100e12:piece lengthi16384e6:pieces20:xxxxxxxxxxxxxxxxxxxxee",
+			SeverityError, KindEncoding, "info"},
+		{"unsorted top level", "d4:info" + info + "8:announce1:xe", SeverityWarning, KindEncoding, ""},
+	} {
+		got := Validate([]byte(tt.data))
+		if len(got) != 1 || got[0].Severity != tt.sev || got[0].Kind != tt.kind || got[0].Field != tt.field {
+			t.Errorf("%s: Validate = %v, want one %v %v problem on %q", tt.name, got, tt.sev, tt.kind, tt.field)
+		}
+	}
+}
+
+func TestValidateFixtures(t *testing.T) {
+	for _, name := range []string{"debian-13.3.0-amd64-netinst.iso.torrent", "v2.torrent", "hybrid.torrent"} {
+		data, err := ReadFile(filepath.Join("..", "bencode", "testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := Validate(data); problems != nil {
+			t.Errorf("%s: %v", name, problems)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package torrentfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/jyotishmoy12/bittorrent-go/pkg/storage"
+)
+
+// FileStatus is the verdict on one file of a verified payload.
+type FileStatus string
+
+const (
+	FileOK         FileStatus = "ok"
+	FileCorrupt    FileStatus = "corrupt"    // at least one of its pieces fails its hash
+	FileMissing    FileStatus = "missing"    // not on disk at all
+	FileIncomplete FileStatus = "incomplete" // shorter than the torrent says
+	// FileUnverified files look complete, but a piece they share with a
+	// missing or incomplete neighbour could not be hashed.
+	FileUnverified FileStatus = "unverified"
+)
+
+// FileReport describes one payload file. Padding files are left out.
+type FileReport struct {
+	Index  int        `json:"index"`
+	Path   string     `json:"path"`
+	Length int64      `json:"length"`
+	Status FileStatus `json:"status"`
+	// BadPieces counts the corrupt pieces overlapping the file.
+	BadPieces int `json:"bad_pieces,omitempty"`
+}
+
+// VerifyReport is the outcome of Verify, shaped for JSON output.
+type VerifyReport struct {
+	Pieces  int          `json:"pieces"`
+	Good    int          `json:"good"`
+	Corrupt []int        `json:"corrupt_pieces"`
+	Missing []int        `json:"missing_pieces"`
+	Files   []FileReport `json:"files"`
+}
+
+// OK reports whether every piece checked out.
+func (r *VerifyReport) OK() bool {
+	return r.Good == r.Pieces
+}
+
+// Verify hashes a payload that is already on disk against the torrent,
+// without any networking. path is the payload itself: the file of a
+// single-file torrent or the directory of a multi-file one; it doesn't have
+// to be called Name. Pieces are hashed on workers goroutines, zero meaning
+// one per core. Pieces that touch a missing or truncated file are reported
+// missing rather than corrupt.
+func (t *Torrent) Verify(path string, workers int) (*VerifyReport, error) {
+	for i := range t.V2Files {
+		if f := &t.V2Files[i]; len(t.PieceHashes) == 0 && f.Length > int64(t.PieceLength) && f.Layer == nil {
+			return nil, fmt.Errorf("the torrent has no piece layer for %s, so it can only be checked while downloading", f.Path)
+		}
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Map the torrent's paths, which start with Name, onto path.
+	files := append([]storage.File(nil), t.files()...)
+	sizes := make([]int64, len(files)) // -1 when the file doesn't exist
+	for i := range files {
+		rel, err := filepath.Rel(t.Name, files[i].Path)
+		if err != nil {
+			return nil, err
+		}
+		files[i].Path = filepath.Join(path, rel)
+		if files[i].Padding {
+			continue
+		}
+		st, err := os.Stat(files[i].Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			sizes[i] = -1
+		case err != nil:
+			return nil, err
+		case st.IsDir():
+			sizes[i] = -1
+		default:
+			sizes[i] = st.Size()
+		}
+	}
+
+	numPieces := t.numPieces()
+	pieceLength := int64(t.PieceLength)
+	total := int64(t.Length)
+
+	// available reports whether every byte of piece index is on disk.
+	available := func(index int) bool {
+		begin := int64(index) * pieceLength
+		end := min(begin+pieceLength, total)
+		var start int64
+		for i, f := range files {
+			fileEnd := start + f.Length
+			if !f.Padding && begin < fileEnd && end > start && f.Length > 0 {
+				if sizes[i] < min(end, fileEnd)-start {
+					return false
+				}
+			}
+			start = fileEnd
+		}
+		return true
+	}
+
+	r := newPayloadReader("", files)
+	defer r.Close()
+
+	const (
+		pieceGood = iota
+		pieceCorrupt
+		pieceMissing
+	)
+	state := make([]int, numPieces)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, t.PieceLength)
+			for i := range indexes {
+				if !available(i) {
+					state[i] = pieceMissing
+					continue
+				}
+				begin := int64(i) * pieceLength
+				n := min(pieceLength, total-begin)
+				if _, err := r.ReadAt(buf[:n], begin); err != nil {
+					state[i] = pieceMissing
+					continue
+				}
+				if t.verifyPiece(i, buf[:n]) != nil {
+					state[i] = pieceCorrupt
+				}
+			}
+		}()
+	}
+	for i := 0; i < numPieces; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report := &VerifyReport{Pieces: numPieces, Corrupt: []int{}, Missing: []int{}}
+	for i, s := range state {
+		switch s {
+		case pieceGood:
+			report.Good++
+		case pieceCorrupt:
+			report.Corrupt = append(report.Corrupt, i)
+		case pieceMissing:
+			report.Missing = append(report.Missing, i)
+		}
+	}
+
+	var start int64
+	for i, f := range files {
+		fileStart := start
+		start += f.Length
+		if f.Padding {
+			continue
+		}
+		fr := FileReport{Index: i, Path: t.files()[i].Path, Length: f.Length, Status: FileOK}
+		switch {
+		case sizes[i] < 0:
+			fr.Status = FileMissing
+		case sizes[i] < f.Length:
+			fr.Status = FileIncomplete
+		case f.Length > 0:
+			first := int(fileStart / pieceLength)
+			last := int((start - 1) / pieceLength)
+			unverified := false
+			for p := first; p <= last; p++ {
+				switch state[p] {
+				case pieceCorrupt:
+					fr.BadPieces++
+				case pieceMissing:
+					unverified = true
+				}
+			}
+			if fr.BadPieces > 0 {
+				fr.Status = FileCorrupt
+			} else if unverified {
+				fr.Status = FileUnverified
+			}
+		}
+		report.Files = append(report.Files, fr)
+	}
+	return report, nil
+}
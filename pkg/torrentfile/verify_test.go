@@ -0,0 +1,106 @@
+package torrentfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestVerifyStates damages a three-file payload in each way Verify tells
+// apart. With pieces of 16384 bytes, a (20000 bytes) covers pieces 0-1, b
+// (20000) pieces 1-2 and c (30000) pieces 2-4.
+func TestVerifyStates(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "shared")
+	writeTree(t, src, map[string]int{"a": 20000, "b": 20000, "c": 30000})
+	data, err := (&Builder{Path: src, PieceLength: 16384}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bto, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := bto.Torrent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(name string, off int64) func(dir string) error {
+		return func(dir string) error {
+			f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			b := make([]byte, 1)
+			if _, err := f.ReadAt(b, off); err != nil {
+				return err
+			}
+			b[0] ^= 0xff
+			_, err = f.WriteAt(b, off)
+			return err
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		damage  func(dir string) error
+		corrupt []int
+		missing []int
+		status  [3]FileStatus // of a, b and c
+		bad     [3]int
+	}{
+		{"intact", func(string) error { return nil }, nil, nil,
+			[3]FileStatus{FileOK, FileOK, FileOK}, [3]int{}},
+		{"missing", func(dir string) error { return os.Remove(filepath.Join(dir, "b")) }, nil, []int{1, 2},
+			[3]FileStatus{FileUnverified, FileMissing, FileUnverified}, [3]int{}},
+		{"missing as a directory", func(dir string) error {
+			if err := os.Remove(filepath.Join(dir, "b")); err != nil {
+				return err
+			}
+			return os.Mkdir(filepath.Join(dir, "b"), 0o755)
+		}, nil, []int{1, 2}, [3]FileStatus{FileUnverified, FileMissing, FileUnverified}, [3]int{}},
+		{"incomplete", func(dir string) error { return os.Truncate(filepath.Join(dir, "c"), 25000) }, nil, []int{3, 4},
+			[3]FileStatus{FileOK, FileOK, FileIncomplete}, [3]int{}},
+		{"corrupt", flip("a", 0), []int{0}, nil,
+			[3]FileStatus{FileCorrupt, FileOK, FileOK}, [3]int{1, 0, 0}},
+		{"corrupt shared piece", flip("b", 19000), []int{2}, nil,
+			[3]FileStatus{FileOK, FileCorrupt, FileCorrupt}, [3]int{0, 1, 1}},
+		{"corrupt and missing", func(dir string) error {
+			if err := flip("c", 29999)(dir); err != nil {
+				return err
+			}
+			return os.Remove(filepath.Join(dir, "a"))
+		}, []int{4}, []int{0, 1}, [3]FileStatus{FileMissing, FileUnverified, FileCorrupt}, [3]int{0, 0, 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "copy")
+			writeTree(t, dir, map[string]int{"a": 20000, "b": 20000, "c": 30000})
+			if err := tt.damage(dir); err != nil {
+				t.Fatal(err)
+			}
+			r, err := to.Verify(dir, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(r.Corrupt, tt.corrupt) && len(r.Corrupt)+len(tt.corrupt) > 0 {
+				t.Errorf("corrupt pieces %v, want %v", r.Corrupt, tt.corrupt)
+			}
+			if !slices.Equal(r.Missing, tt.missing) && len(r.Missing)+len(tt.missing) > 0 {
+				t.Errorf("missing pieces %v, want %v", r.Missing, tt.missing)
+			}
+			if r.Pieces != 5 || r.Good != 5-len(tt.corrupt)-len(tt.missing) || r.OK() != (r.Good == 5) {
+				t.Errorf("%d of %d pieces good", r.Good, r.Pieces)
+			}
+			if len(r.Files) != 3 {
+				t.Fatalf("%d files reported, want 3", len(r.Files))
+			}
+			for i, f := range r.Files {
+				if f.Status != tt.status[i] || f.BadPieces != tt.bad[i] {
+					t.Errorf("%s is %s with %d bad pieces, want %s with %d", f.Path, f.Status, f.BadPieces, tt.status[i], tt.bad[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package tracker
+
+import (
+	"net/url"
+	"strings"
+)
+
+// secretParams are query parameters private trackers use to identify a user.
+var secretParams = []string{"passkey", "authkey", "torrent_pass", "pk", "key", "token", "auth", "secret", "uid"}
+
+// RedactURL returns announce with anything identifying the user replaced by
+// "REDACTED", so that it can be logged: userinfo passwords, secret query
+// parameters such as passkey, and path segments that look like a passkey,
+// as in "/<passkey>/announce".
+func RedactURL(announce string) string {
+	u, err := url.Parse(announce)
+	if err != nil {
+		// Can't tell what's secret in it, so hide everything past the host.
+		if i := strings.Index(announce, "://"); i >= 0 {
+			if j := strings.IndexAny(announce[i+3:], "/?"); j >= 0 {
+				return announce[:i+3+j] + "/REDACTED"
+			}
+		}
+		return "REDACTED"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		if looksLikePasskey(s) {
+			segments[i] = "REDACTED"
+		}
+	}
+	u.Path = strings.Join(segments, "/")
+	u.RawPath = ""
+
+	if u.RawQuery != "" {
+		q := u.Query()
+		for key := range q {
+			for _, secret := range secretParams {
+				if strings.EqualFold(key, secret) {
+					q.Set(key, "REDACTED")
+				}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// looksLikePasskey spots the random tokens private trackers put in announce
+// paths: long runs of letters and digits that contain both.
+func looksLikePasskey(s string) bool {
+	if len(s) < 16 {
+		return false
+	}
+	var letters, digits bool
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters = true
+		case r == '-' || r == '_':
+		default:
+			return false
+		}
+	}
+	return letters && digits
+}
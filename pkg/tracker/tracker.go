@@ -7,9 +7,12 @@ import (
 	"net/url"
 	"strconv"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/jyotishmoy12/bittorrent-go/pkg/bencode"
 )
 
+// maxResponseSize caps an announce response. Even a few thousand compact peers fit easily.
+const maxResponseSize = 1 << 20
+
 // bencodeTrackerResponse matches the format the tracker sends back
 type bencodeTrackerResponse struct {
 	Interval int    `bencode:"interval"`
@@ -37,32 +40,40 @@ func BuildTrackerURL(announce string, infoHash [20]byte, peerID [20]byte, port u
 		return "", err
 	}
 
-	params := url.Values{
-		"info_hash":  []string{string(infoHash[:])},
-		"peer_id":    []string{string(peerID[:])},
-		"port":       []string{strconv.Itoa(int(port))},
-		"uploaded":   []string{"0"},
-		"downloaded": []string{"0"},
-		"compact":    []string{"1"}, 
-		//compact=1: This tells the tracker to send the peer list in a "compact" binary format 
-		//(6 bytes per peer: 4 for IP, 2 for Port) rather than a bulky list. This is standard for modern clients.
-		"left":       []string{strconv.Itoa(length)},
-	}
+	// Keep whatever the announce URL already carries, such as a private tracker's passkey.
+	params := base.Query()
+	params.Set("info_hash", string(infoHash[:]))
+	params.Set("peer_id", string(peerID[:]))
+	params.Set("port", strconv.Itoa(int(port)))
+	params.Set("uploaded", "0")
+	params.Set("downloaded", "0")
+	//compact=1: This tells the tracker to send the peer list in a "compact" binary format
+	//(6 bytes per peer: 4 for IP, 2 for Port) rather than a bulky list. This is standard for modern clients.
+	params.Set("compact", "1")
+	params.Set("left", strconv.Itoa(length))
 
 	base.RawQuery = params.Encode()
 	return base.String(), nil
 }
 
-func GetPeers(url string) (string, error) {
+func GetPeers(trackerURL string) (string, error) {
 	// Here we would make an HTTP GET request to the tracker URL and parse the response
-	resp, err := http.Get(url)
+	resp, err := http.Get(trackerURL)
 	if err != nil {
+		// The error quotes the URL, passkey and all; it usually ends up in a log.
+		if ue, ok := err.(*url.Error); ok {
+			ue.URL = RedactURL(ue.URL)
+		}
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	trackerResp := bencodeTrackerResponse{}
-	err = bencode.Unmarshal(resp.Body, &trackerResp)
+	// The response comes from the network, so bound what we're willing to parse.
+	dec := bencode.NewDecoder(resp.Body)
+	dec.MaxSize = maxResponseSize
+	dec.MaxDepth = 8
+	err = dec.Decode(&trackerResp)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,173 @@
+package utp
+
+import "time"
+
+const (
+	// target is the queuing delay LEDBAT aims for, in microseconds. Below
+	// it the window grows, above it the window shrinks, so uTP backs off
+	// as soon as it starts filling buffers that other traffic shares.
+	target = 100_000
+	// maxCwndIncrease is the most the window grows in one round trip.
+	maxCwndIncrease = 3000
+	// maxWindow caps the send window.
+	maxWindow = 1 << 20
+
+	minTimeout     = 500 * time.Millisecond
+	initialTimeout = time.Second
+)
+
+// ledbat is the delay-based congestion controller of BEP 29. It compares
+// the one-way delay of our packets, as reported back by the peer, against
+// the lowest delay seen recently; the difference is time spent in queues.
+type ledbat struct {
+	window int // bytes allowed in flight
+
+	// base holds the lowest delay of the current and the two previous
+	// minutes; their minimum is the base delay. The peer's clock is not
+	// ours, so the samples are only meaningful relative to each other.
+	base      [3]uint32
+	haveBase  [3]bool
+	baseStart time.Time
+
+	// lastCut is when the window was last halved for a loss, so that the
+	// losses of one round trip only count once.
+	lastCut time.Time
+
+	rtt, rttVar time.Duration
+	timeout     time.Duration
+}
+
+func newLedbat(packetSize int) *ledbat {
+	return &ledbat{window: 2 * packetSize, timeout: initialTimeout}
+}
+
+// onAck grows or shrinks the window after acked bytes arrived with a delay
+// sample, the peer's timestamp_difference.
+func (l *ledbat) onAck(acked int, delay uint32, now time.Time, minWindow int) {
+	if delay != 0 {
+		l.addDelay(delay, now)
+	}
+	base, ok := l.baseDelay()
+	if !ok {
+		return
+	}
+	ourDelay := float64(int32(delay - base))
+	offTarget := target - ourDelay
+	delayFactor := offTarget / target
+	windowFactor := float64(acked) / float64(l.window)
+	l.window += int(maxCwndIncrease * delayFactor * windowFactor)
+	l.window = min(max(l.window, minWindow), maxWindow)
+}
+
+func (l *ledbat) addDelay(delay uint32, now time.Time) {
+	if l.baseStart.IsZero() {
+		l.baseStart = now
+	}
+	// Every minute the oldest bucket is dropped, so the base delay follows
+	// route changes and clock drift.
+	for now.Sub(l.baseStart) >= time.Minute {
+		l.base[2], l.base[1] = l.base[1], l.base[0]
+		l.haveBase[2], l.haveBase[1], l.haveBase[0] = l.haveBase[1], l.haveBase[0], false
+		l.baseStart = l.baseStart.Add(time.Minute)
+	}
+	if !l.haveBase[0] || int32(delay-l.base[0]) < 0 {
+		l.base[0], l.haveBase[0] = delay, true
+	}
+}
+
+func (l *ledbat) baseDelay() (uint32, bool) {
+	var base uint32
+	found := false
+	for i, d := range l.base {
+		if l.haveBase[i] && (!found || int32(d-base) < 0) {
+			base, found = d, true
+		}
+	}
+	return base, found
+}
+
+// onLoss halves the window, at most once per round trip.
+func (l *ledbat) onLoss(now time.Time, minWindow int) {
+	if now.Sub(l.lastCut) < l.rtt {
+		return
+	}
+	l.lastCut = now
+	l.window = max(l.window/2, minWindow)
+}
+
+// onTimeout collapses the window to a single packet and backs off the timer.
+func (l *ledbat) onTimeout(packetSize int) {
+	l.window = packetSize
+	l.timeout = min(l.timeout*2, 60*time.Second)
+}
+
+// onProgress undoes the timer backoff once acks flow again. The acked
+// packets may all have been retransmissions, which give no RTT sample.
+func (l *ledbat) onProgress() {
+	if l.rtt == 0 {
+		l.timeout = initialTimeout
+		return
+	}
+	l.timeout = max(l.rtt+4*l.rttVar, minTimeout)
+}
+
+// onRTT folds in the round trip time of a packet that was sent only once.
+func (l *ledbat) onRTT(rtt time.Duration) {
+	if l.rtt == 0 {
+		l.rtt, l.rttVar = rtt, rtt/2
+	} else {
+		delta := l.rtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		l.rttVar += (delta - l.rttVar) / 4
+		l.rtt += (rtt - l.rtt) / 8
+	}
+	l.timeout = max(l.rtt+4*l.rttVar, minTimeout)
+}
+
+// UDP payload sizes for the MTU search: 576 and 1500 byte IPv4 packets
+// minus the IP and UDP headers.
+const (
+	mtuFloor   = 548
+	mtuCeiling = 1472
+	// mtuDone stops the search once floor and ceiling are this close.
+	mtuDone = 16
+)
+
+// mtuSearch finds the largest datagram the path carries by binary search:
+// now and then one data packet is sent at the midpoint size, and whether it
+// is acked or lost moves the floor or the ceiling.
+type mtuSearch struct {
+	floor, ceiling int
+	probe          int // size of the probe in flight, zero when none
+}
+
+func newMTUSearch() mtuSearch {
+	return mtuSearch{floor: mtuFloor, ceiling: mtuCeiling}
+}
+
+// packetSize is the datagram size known to get through.
+func (m *mtuSearch) packetSize() int {
+	return m.floor
+}
+
+// nextProbe returns the size to probe with, or zero when no probe is due.
+func (m *mtuSearch) nextProbe() int {
+	if m.probe != 0 || m.ceiling-m.floor <= mtuDone {
+		return 0
+	}
+	return (m.floor + m.ceiling) / 2
+}
+
+func (m *mtuSearch) acked(size int) {
+	if size == m.probe {
+		m.floor, m.probe = size, 0
+	}
+}
+
+func (m *mtuSearch) lost(size int) {
+	if size == m.probe {
+		m.ceiling, m.probe = size-1, 0
+	}
+}
@@ -0,0 +1,151 @@
+package utp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLedbatWindow(t *testing.T) {
+	const (
+		packet = 1000
+		base   = 20_000 // µs
+	)
+	tests := []struct {
+		name  string
+		delay uint32 // of the acked packet
+		want  int
+	}{
+		// With no queuing delay the window grows by maxCwndIncrease times
+		// the share of the window that was acked.
+		{"empty queue", base, 2000 + maxCwndIncrease*1000/2000},
+		{"half target", base + target/2, 2000 + maxCwndIncrease*1000/2000/2},
+		{"on target", base + target, 2000},
+		{"over target", base + 3*target/2, 2000 - maxCwndIncrease*1000/2000/2},
+		// Shrinking stops at the minimum window.
+		{"far over target", base + 20*target, packet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLedbat(packet)
+			now := time.Now()
+			l.addDelay(base, now)
+			l.onAck(1000, tt.delay, now, packet)
+			if l.window != tt.want {
+				t.Errorf("window = %d, want %d", l.window, tt.want)
+			}
+		})
+	}
+}
+
+func TestLedbatWindowMax(t *testing.T) {
+	l := newLedbat(1000)
+	now := time.Now()
+	for range 10000 {
+		l.onAck(l.window, 5000, now, 1000)
+	}
+	if l.window != maxWindow {
+		t.Errorf("window = %d, want it capped at %d", l.window, maxWindow)
+	}
+}
+
+// TestLedbatBaseDelay checks that the base delay is the lowest sample of
+// the last few minutes, so a lower delay from long ago stops counting.
+func TestLedbatBaseDelay(t *testing.T) {
+	var l ledbat
+	start := time.Now()
+	l.addDelay(1000, start)
+	l.addDelay(5000, start.Add(30*time.Second))
+	if base, _ := l.baseDelay(); base != 1000 {
+		t.Fatalf("base delay = %d, want 1000", base)
+	}
+	l.addDelay(4000, start.Add(2*time.Minute))
+	if base, _ := l.baseDelay(); base != 1000 {
+		t.Fatalf("base delay = %d after two minutes, want 1000", base)
+	}
+	l.addDelay(6000, start.Add(3*time.Minute))
+	if base, _ := l.baseDelay(); base != 4000 {
+		t.Fatalf("base delay = %d after three minutes, want 4000", base)
+	}
+
+	// The peer's clock may wrap around.
+	var w ledbat
+	w.addDelay(0xffffff00, start)
+	w.addDelay(0x10, start)
+	if base, _ := w.baseDelay(); base != 0xffffff00 {
+		t.Errorf("base delay = %#x across the wrap, want 0xffffff00", base)
+	}
+}
+
+func TestLedbatLoss(t *testing.T) {
+	l := newLedbat(1000)
+	l.window = 8000
+	l.onRTT(100 * time.Millisecond)
+	now := time.Now()
+	l.onLoss(now, 1000)
+	if l.window != 4000 {
+		t.Fatalf("window = %d after a loss, want 4000", l.window)
+	}
+	l.onLoss(now.Add(50*time.Millisecond), 1000)
+	if l.window != 4000 {
+		t.Fatalf("window = %d after a second loss in the same round trip, want 4000", l.window)
+	}
+	l.onLoss(now.Add(150*time.Millisecond), 1000)
+	if l.window != 2000 {
+		t.Fatalf("window = %d after a loss a round trip later, want 2000", l.window)
+	}
+
+	before := l.timeout
+	l.onTimeout(1000)
+	if l.window != 1000 || l.timeout != 2*before {
+		t.Errorf("after a timeout window = %d, timeout = %v", l.window, l.timeout)
+	}
+	l.onProgress()
+	if l.timeout != max(l.rtt+4*l.rttVar, minTimeout) {
+		t.Errorf("timeout = %v after progress, want the backoff undone", l.timeout)
+	}
+}
+
+func TestMTUSearch(t *testing.T) {
+	for _, mtu := range []int{mtuFloor, 576, 1200, 1400, mtuCeiling} {
+		m := newMTUSearch()
+		probes := 0
+		for size := m.nextProbe(); size != 0; size = m.nextProbe() {
+			m.probe = size // as Write does when it sends the probe
+			if m.nextProbe() != 0 {
+				t.Fatal("second probe while one is in flight")
+			}
+			if size <= mtu {
+				m.acked(size)
+			} else {
+				m.lost(size)
+			}
+			probes++
+		}
+		if got := m.packetSize(); got > mtu || mtu-got > mtuDone {
+			t.Errorf("path MTU %d: packet size %d after %d probes", mtu, got, probes)
+		}
+		if probes > 7 {
+			t.Errorf("path MTU %d: %d probes, want a binary search", mtu, probes)
+		}
+	}
+}
+
+// TestMTUSearchOtherPackets checks that only the probe moves the bounds:
+// acks and losses of ordinary packets say nothing about the path MTU.
+func TestMTUSearchOtherPackets(t *testing.T) {
+	m := newMTUSearch()
+	size := m.nextProbe()
+	m.probe = size
+	m.lost(mtuFloor)
+	m.acked(size - 1)
+	if m.floor != mtuFloor || m.ceiling != mtuCeiling || m.probe != size {
+		t.Fatalf("non-probe packets moved the search: %+v", m)
+	}
+	m.lost(size)
+	if m.ceiling != size-1 || m.probe != 0 {
+		t.Errorf("lost probe: %+v", m)
+	}
+	if m.packetSize() != mtuFloor {
+		t.Errorf("packet size = %d after a lost probe, want %d", m.packetSize(), mtuFloor)
+	}
+}
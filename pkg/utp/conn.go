@@ -0,0 +1,568 @@
+package utp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// recvBufMax is how much received data we hold, in order or not,
+	// before the advertised window closes.
+	recvBufMax = 1 << 20
+	// maxUnacked bounds the packets in flight, keeping them well inside the
+	// 16-bit sequence space.
+	maxUnacked = 1000
+	// maxSackBytes is the largest selective ack we send, covering 256 packets.
+	maxSackBytes = 32
+	// maxTimeouts consecutive retransmission timeouts end the connection.
+	// With the doubling timer that is about half a minute of silence.
+	maxTimeouts = 6
+	// closeLinger is how long a closed connection waits for unsent data to
+	// be acked before its FIN goes out.
+	closeLinger = 5 * time.Second
+)
+
+var errTimeout = errors.New("utp: peer stopped responding")
+
+// outPacket is a sent packet waiting for its ack.
+type outPacket struct {
+	p      *packet
+	sentAt time.Time
+	// transmissions counts sends; only packets sent once give RTT samples.
+	transmissions int
+	// sacked is set when a selective ack covered the packet; it stays in
+	// the queue until the cumulative ack passes it.
+	sacked     bool
+	fastResent bool
+	// needResend is set for packets taken as lost on a timeout; they don't
+	// count as in flight until they are sent again.
+	needResend bool
+	// probe is set for an MTU probe, which is sent at the size being tested.
+	probe bool
+}
+
+func (op *outPacket) size() int {
+	return len(op.p.payload)
+}
+
+// Conn is a uTP connection. It implements net.Conn.
+type Conn struct {
+	s      *Socket
+	raddr  net.Addr
+	key    connKey
+	sendID uint16
+
+	established chan struct{} // closed once the SYN has been answered
+	estOnce     sync.Once
+	done        chan struct{} // closed when the connection is finished
+	doneOnce    sync.Once
+
+	mu        sync.Mutex
+	notify    chan struct{} // closed and replaced whenever state changes
+	connected bool
+	err       error // why the connection ended
+	closed    bool  // Close was called
+	finSent   bool
+
+	// Sending side.
+	seq        uint16 // sequence number of the next packet
+	unacked    []*outPacket
+	inflight   int // payload bytes sent and not yet acked
+	peerWnd    int
+	lastAck    uint16
+	dupAcks    int
+	timeouts   int
+	cc         *ledbat
+	mtu        mtuSearch
+	replyMicro uint32 // our measurement of the peer's last one-way delay
+
+	// Receiving side.
+	ack      uint16 // last sequence number received in order
+	readBuf  bytes.Buffer
+	ooo      map[uint16]*packet // received out of order
+	oooBytes int
+	eof      bool // the peer's FIN has been reached
+
+	readDeadline, writeDeadline time.Time
+}
+
+func newConn(s *Socket, raddr net.Addr, key connKey, sendID uint16) *Conn {
+	c := &Conn{
+		s:           s,
+		raddr:       raddr,
+		key:         key,
+		sendID:      sendID,
+		established: make(chan struct{}),
+		done:        make(chan struct{}),
+		notify:      make(chan struct{}),
+		peerWnd:     recvBufMax,
+		mtu:         newMTUSearch(),
+		ooo:         map[uint16]*packet{},
+	}
+	c.cc = newLedbat(c.mtu.packetSize())
+	return c
+}
+
+func (c *Conn) setEstablished() {
+	c.connected = true
+	c.estOnce.Do(func() { close(c.established) })
+}
+
+// broadcast wakes everyone blocked in wait. Callers hold c.mu.
+func (c *Conn) broadcast() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+// wait releases c.mu until the next broadcast or the deadline.
+func (c *Conn) wait(deadline time.Time) error {
+	ch := c.notify
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		expired = t.C
+	}
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	select {
+	case <-ch:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.readBuf.Len() == 0 {
+		switch {
+		case c.closed:
+			return 0, errClosed
+		case c.eof:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		}
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+	wasShut := c.recvWindow() < c.mtu.packetSize()
+	n, _ := c.readBuf.Read(b)
+	if wasShut && c.recvWindow() >= c.mtu.packetSize() {
+		// Tell a sender that stopped for our full buffer that it may go on.
+		c.sendState()
+	}
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written := 0
+	for len(b) > 0 {
+		switch {
+		case c.closed:
+			return written, errClosed
+		case c.err != nil:
+			return written, c.err
+		}
+
+		size := c.mtu.packetSize() - headerSize
+		probe := c.mtu.nextProbe()
+		if probe != 0 && len(b) >= probe-headerSize {
+			size = probe - headerSize
+		} else {
+			probe = 0
+		}
+		size = min(size, len(b))
+
+		// One packet may always be in flight, even into a closed window:
+		// it is how we find out that the window opened again.
+		window := min(c.cc.window, c.peerWnd)
+		if c.inflight > 0 && c.inflight+size > window || len(c.unacked) >= maxUnacked {
+			if err := c.wait(c.writeDeadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		op := &outPacket{p: &packet{
+			header:  header{typ: stData, seq: c.seq},
+			payload: append([]byte(nil), b[:size]...),
+		}}
+		if probe != 0 {
+			op.probe = true
+			c.mtu.probe = probe
+		}
+		c.seq++
+		c.unacked = append(c.unacked, op)
+		c.inflight += size
+		c.transmit(op)
+		b = b[size:]
+		written += size
+	}
+	return written, nil
+}
+
+// Close ends the connection without waiting: Read and Write fail from now
+// on, and in the background a FIN follows once the data already written has
+// been acked, or after closeLinger. The FIN is retransmitted until acked.
+// A peer that stopped acking so never holds up the caller.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.broadcast()
+	go c.linger()
+	return nil
+}
+
+// linger waits for the unacked data of a closed connection and sends the FIN.
+func (c *Conn) linger() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := time.Now().Add(closeLinger)
+	for len(c.unacked) > 0 && c.err == nil {
+		if c.wait(deadline) != nil {
+			break
+		}
+	}
+	if c.err == nil && c.connected {
+		fin := &outPacket{p: &packet{header: header{typ: stFin, seq: c.seq}}}
+		c.seq++
+		c.unacked = append(c.unacked, fin)
+		c.finSent = true
+		c.transmit(fin)
+	} else {
+		c.finish(errClosed)
+	}
+	c.broadcast()
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.s.Addr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.broadcast()
+	return nil
+}
+
+// fail ends the connection with err.
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finish(err)
+	c.broadcast()
+}
+
+// finish records why the connection ended and takes it off the socket.
+// Callers hold c.mu.
+func (c *Conn) finish(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.doneOnce.Do(func() { close(c.done) })
+	c.s.remove(c.key)
+}
+
+// sendPacket stamps p with our current receive state and sends it.
+func (c *Conn) sendPacket(p *packet) {
+	if p.typ != stSyn {
+		p.connID = c.sendID
+	}
+	p.ts = now()
+	p.tsDiff = c.replyMicro
+	p.wnd = uint32(c.recvWindow())
+	p.ack = c.ack
+	c.s.pc.WriteTo(p.marshal(), c.raddr)
+}
+
+func (c *Conn) transmit(op *outPacket) {
+	if op.needResend {
+		op.needResend = false
+		c.inflight += op.size()
+	}
+	op.sentAt = time.Now()
+	op.transmissions++
+	c.sendPacket(op.p)
+}
+
+// sendState acks everything received so far.
+func (c *Conn) sendState() {
+	c.sendPacket(&packet{header: header{typ: stState, seq: c.seq}, sack: c.sackMask()})
+}
+
+func (c *Conn) recvWindow() int {
+	return max(0, recvBufMax-c.readBuf.Len()-c.oooBytes)
+}
+
+// sackMask describes the packets received out of order past ack+1.
+func (c *Conn) sackMask() []byte {
+	if len(c.ooo) == 0 {
+		return nil
+	}
+	var mask [maxSackBytes]byte
+	used := 0
+	for seq := range c.ooo {
+		i := int(seq - c.ack - 2)
+		if i < maxSackBytes*8 {
+			mask[i/8] |= 1 << (i % 8)
+			used = max(used, i/8+1)
+		}
+	}
+	// The length must be a multiple of four.
+	return append([]byte(nil), mask[:(used+3)/4*4]...)
+}
+
+// receive handles a packet the socket routed to this connection.
+func (c *Conn) receive(p *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	defer c.broadcast()
+
+	c.peerWnd = int(p.wnd)
+	if p.ts != 0 {
+		c.replyMicro = now() - p.ts
+	}
+	switch p.typ {
+	case stReset:
+		c.finish(errReset)
+		return
+	case stSyn:
+		// A new or repeated SYN on the accepting side.
+		c.sendState()
+		return
+	}
+	if !c.connected {
+		// The answer to our SYN carries the sequence number the peer's
+		// data will start at.
+		c.ack = p.seq - 1
+		c.setEstablished()
+	}
+
+	c.processAck(p)
+	if p.typ == stData || p.typ == stFin {
+		c.processData(p)
+		c.sendState()
+	}
+}
+
+func (c *Conn) processAck(p *packet) {
+	now := time.Now()
+	acked := 0
+	for len(c.unacked) > 0 && !seqLess(p.ack, c.unacked[0].p.seq) {
+		acked += c.acked(c.unacked[0], now)
+		c.unacked = c.unacked[1:]
+	}
+
+	if len(p.sack) > 0 {
+		for _, op := range c.unacked {
+			i := int(op.p.seq - p.ack - 2)
+			if i < len(p.sack)*8 && p.sack[i/8]&(1<<(i%8)) != 0 && !op.sacked {
+				acked += c.acked(op, now)
+				op.sacked = true
+			}
+		}
+		// A packet that three later packets overtook is taken as lost.
+		overtaken := 0
+		for i := len(c.unacked) - 1; i >= 0; i-- {
+			op := c.unacked[i]
+			if op.sacked {
+				overtaken++
+			} else if overtaken >= 3 && !op.fastResent {
+				c.resend(op, now)
+			}
+		}
+	}
+
+	// Three acks in a row for the same packet also mean the next one was lost.
+	if p.typ == stState && acked == 0 && p.ack == c.lastAck && len(c.unacked) > 0 {
+		c.dupAcks++
+		if op := c.unacked[0]; c.dupAcks == 3 && !op.sacked && !op.fastResent {
+			c.resend(op, now)
+		}
+	} else {
+		c.dupAcks = 0
+	}
+	c.lastAck = p.ack
+
+	if acked > 0 {
+		c.timeouts = 0
+		c.cc.onProgress()
+		c.cc.onAck(acked, p.tsDiff, now, c.mtu.packetSize())
+	}
+	c.flush()
+	if c.finSent && len(c.unacked) == 0 {
+		// Our FIN was acked.
+		c.finish(errClosed)
+	}
+}
+
+// acked accounts for a packet the peer confirmed and returns its size.
+func (c *Conn) acked(op *outPacket, now time.Time) int {
+	if op.sacked {
+		return 0
+	}
+	if op.transmissions == 1 {
+		c.cc.onRTT(now.Sub(op.sentAt))
+	}
+	if op.probe {
+		c.mtu.acked(headerSize + op.size())
+	}
+	if !op.needResend {
+		c.inflight -= op.size()
+	}
+	op.needResend = false
+	return op.size()
+}
+
+// resend retransmits a packet taken as lost and shrinks the window.
+func (c *Conn) resend(op *outPacket, now time.Time) {
+	op.fastResent = true
+	c.lost(op)
+	c.cc.onLoss(now, c.mtu.packetSize())
+	c.transmit(op)
+}
+
+// flush retransmits packets lost to a timeout as far as the window allows,
+// and at least one when nothing else is in flight.
+func (c *Conn) flush() {
+	window := min(c.cc.window, c.peerWnd)
+	for _, op := range c.unacked {
+		if !op.needResend {
+			continue
+		}
+		if c.inflight > 0 && c.inflight+op.size() > window {
+			return
+		}
+		c.transmit(op)
+	}
+}
+
+func (c *Conn) lost(op *outPacket) {
+	if op.probe {
+		// The path didn't carry it; the retransmission is left to IP
+		// fragmentation and later packets use the smaller size.
+		c.mtu.lost(headerSize + op.size())
+		op.probe = false
+	}
+}
+
+func (c *Conn) processData(p *packet) {
+	if !seqLess(c.ack, p.seq) {
+		return // a duplicate; the ack we send will settle it
+	}
+	if p.seq != c.ack+1 {
+		if int(p.seq-c.ack) < maxUnacked && c.ooo[p.seq] == nil && len(p.payload) <= c.recvWindow() {
+			c.ooo[p.seq] = p
+			c.oooBytes += len(p.payload)
+		}
+		return
+	}
+	if len(p.payload) > c.recvWindow() {
+		return // no room; the peer will retransmit
+	}
+	c.deliver(p)
+	for {
+		q := c.ooo[c.ack+1]
+		if q == nil {
+			break
+		}
+		delete(c.ooo, q.seq)
+		c.oooBytes -= len(q.payload)
+		c.deliver(q)
+	}
+}
+
+func (c *Conn) deliver(p *packet) {
+	c.ack = p.seq
+	if c.eof {
+		return
+	}
+	if p.typ == stFin {
+		c.eof = true
+		return
+	}
+	c.readBuf.Write(p.payload)
+}
+
+// timerLoop watches the oldest packet in flight. When its timer runs out,
+// every packet not known to have arrived is taken as lost and resent as the
+// collapsed window allows.
+func (c *Conn) timerLoop() {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-c.done:
+			return
+		}
+		c.mu.Lock()
+		if c.timedOut() {
+			c.timeouts++
+			if c.timeouts > maxTimeouts {
+				c.finish(errTimeout)
+				c.broadcast()
+				c.mu.Unlock()
+				return
+			}
+			for _, op := range c.unacked {
+				if !op.sacked && !op.needResend {
+					c.lost(op)
+					op.needResend = true
+					c.inflight -= op.size()
+				}
+			}
+			c.cc.onTimeout(c.mtu.packetSize())
+			c.flush()
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *Conn) timedOut() bool {
+	for _, op := range c.unacked {
+		if !op.sacked && !op.needResend {
+			return time.Since(op.sentAt) > c.cc.timeout
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package utp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxy relays datagrams between one client and a server, dropping a share
+// of them and holding some back for a few milliseconds so that they arrive
+// out of order.
+type proxy struct {
+	pc     net.PacketConn
+	server net.Addr
+	loss   float64 // share of datagrams dropped
+	delay  float64 // share of datagrams held back
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	client net.Addr
+}
+
+func newProxy(t *testing.T, server net.Addr, loss, delay float64) *proxy {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	p := &proxy{pc: pc, server: server, loss: loss, delay: delay, rand: rand.New(rand.NewSource(1))}
+	go p.run()
+	return p
+}
+
+func (p *proxy) run() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		to := p.server
+		if from.String() == p.server.String() {
+			to = p.client
+		} else {
+			p.client = from
+		}
+		r := p.rand.Float64()
+		p.mu.Unlock()
+		if to == nil {
+			continue
+		}
+		switch b := bytes.Clone(buf[:n]); {
+		case r < p.loss:
+		case r < p.loss+p.delay:
+			time.AfterFunc(5*time.Millisecond, func() { p.pc.WriteTo(b, to) })
+		default:
+			p.pc.WriteTo(b, to)
+		}
+	}
+}
+
+func listen(t *testing.T) *Socket {
+	t.Helper()
+	s, err := Listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// transfer sends n random bytes from a new connection to addr, which the
+// server socket accepts, and checks what arrives.
+func transfer(t *testing.T, server *Socket, addr string, n int) {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	got := make(chan []byte, 1)
+	go func() {
+		c, err := server.Accept()
+		if err != nil {
+			t.Error(err)
+			got <- nil
+			return
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(30 * time.Second))
+		b, err := io.ReadAll(c)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- b
+	}()
+
+	client := listen(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := client.DialContext(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; !bytes.Equal(b, data) {
+		t.Fatalf("received %d bytes that differ from the %d sent", len(b), len(data))
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	server := listen(t)
+	transfer(t, server, server.Addr().String(), 4<<20)
+}
+
+// TestTransferLossy goes through a proxy that drops 5% of the datagrams in
+// both directions, SYNs and ACKs included, and reorders another 20%.
+// Selective ACKs, resends and the timers have to put the stream back
+// together.
+func TestTransferLossy(t *testing.T) {
+	server := listen(t)
+	p := newProxy(t, server.Addr(), 0.05, 0.2)
+	transfer(t, server, p.pc.LocalAddr().String(), 1<<20)
+}
+
+func TestEcho(t *testing.T) {
+	server := listen(t)
+	go func() {
+		c, err := server.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	client := listen(t)
+	c, err := client.DialContext(context.Background(), server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := make([]byte, 300<<10)
+	rand.New(rand.NewSource(3)).Read(data)
+	go c.Write(data)
+	buf := make([]byte, len(data))
+	c.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("echo differs from what was sent")
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// A UDP socket that never answers the SYN.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	start := time.Now()
+	if _, err := DialTimeout(pc.LocalAddr().String(), 300*time.Millisecond); err == nil {
+		t.Fatal("dial succeeded without an answer")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("dial gave up after %v", d)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	server := listen(t)
+	go server.Accept()
+	client := listen(t)
+	c, err := client.DialContext(context.Background(), server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = c.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("read error %v, want a timeout", err)
+	}
+}
+
+// TestCloseUnacked closes a connection whose data the peer will never ack
+// and checks that Close doesn't wait for it, while reads and writes fail.
+func TestCloseUnacked(t *testing.T) {
+	server := listen(t)
+	accepted := make(chan struct{})
+	go func() {
+		if _, err := server.Accept(); err == nil {
+			close(accepted)
+		}
+	}()
+	client := listen(t)
+	c, err := client.DialContext(context.Background(), server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	server.Close() // nothing is acked from now on
+
+	// One packet, which may always be sent whatever the window.
+	if _, err := c.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > closeLinger/10 {
+		t.Errorf("Close took %v with data unacked", d)
+	}
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Error("Read succeeded after Close")
+	}
+	if _, err := c.Write([]byte{1}); err == nil {
+		t.Error("Write succeeded after Close")
+	}
+}
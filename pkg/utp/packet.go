@@ -0,0 +1,121 @@
+package utp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Packet types.
+const (
+	stData  = 0 // carries payload
+	stFin   = 1 // the last packet of the stream
+	stState = 2 // a bare ack, it doesn't take a sequence number
+	stReset = 3 // tear the connection down
+	stSyn   = 4 // opens a connection
+)
+
+const (
+	version    = 1
+	headerSize = 20
+	// extSack is the selective ack extension.
+	extSack = 1
+)
+
+// header is the fixed 20 byte uTP header:
+//
+//	0       4       8               16              24              32
+//	+-------+-------+---------------+---------------+---------------+
+//	| type  | ver   | extension     | connection_id                 |
+//	+-------+-------+---------------+---------------+---------------+
+//	| timestamp_microseconds                                        |
+//	+---------------+---------------+---------------+---------------+
+//	| timestamp_difference_microseconds                             |
+//	+---------------+---------------+---------------+---------------+
+//	| wnd_size                                                      |
+//	+---------------+---------------+---------------+---------------+
+//	| seq_nr                        | ack_nr                        |
+//	+---------------+---------------+---------------+---------------+
+type header struct {
+	typ    uint8
+	connID uint16
+	ts     uint32 // when the packet was sent, in our clock
+	tsDiff uint32 // the one-way delay of the last packet received, as we measured it
+	wnd    uint32 // bytes the sender is still willing to receive
+	seq    uint16
+	ack    uint16
+}
+
+type packet struct {
+	header
+	// sack is the selective ack bitmask: bit i (least significant bit
+	// first in each byte) is set when packet ack+2+i has been received.
+	sack    []byte
+	payload []byte
+}
+
+func (p *packet) marshal() []byte {
+	n := headerSize + len(p.payload)
+	if len(p.sack) > 0 {
+		n += 2 + len(p.sack)
+	}
+	buf := make([]byte, headerSize, n)
+	buf[0] = p.typ<<4 | version
+	if len(p.sack) > 0 {
+		buf[1] = extSack
+	}
+	binary.BigEndian.PutUint16(buf[2:], p.connID)
+	binary.BigEndian.PutUint32(buf[4:], p.ts)
+	binary.BigEndian.PutUint32(buf[8:], p.tsDiff)
+	binary.BigEndian.PutUint32(buf[12:], p.wnd)
+	binary.BigEndian.PutUint16(buf[16:], p.seq)
+	binary.BigEndian.PutUint16(buf[18:], p.ack)
+	if len(p.sack) > 0 {
+		buf = append(buf, 0, byte(len(p.sack)))
+		buf = append(buf, p.sack...)
+	}
+	return append(buf, p.payload...)
+}
+
+// parsePacket decodes a datagram. Extensions other than selective acks are
+// skipped. The payload aliases b.
+func parsePacket(b []byte) (*packet, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("utp: packet of %d bytes is shorter than the header", len(b))
+	}
+	if b[0]&0x0f != version {
+		return nil, fmt.Errorf("utp: unsupported version %d", b[0]&0x0f)
+	}
+	p := &packet{header: header{
+		typ:    b[0] >> 4,
+		connID: binary.BigEndian.Uint16(b[2:]),
+		ts:     binary.BigEndian.Uint32(b[4:]),
+		tsDiff: binary.BigEndian.Uint32(b[8:]),
+		wnd:    binary.BigEndian.Uint32(b[12:]),
+		seq:    binary.BigEndian.Uint16(b[16:]),
+		ack:    binary.BigEndian.Uint16(b[18:]),
+	}}
+	if p.typ > stSyn {
+		return nil, fmt.Errorf("utp: unknown packet type %d", p.typ)
+	}
+
+	// Each extension starts with the type of the next one and its length.
+	ext := b[1]
+	rest := b[headerSize:]
+	for ext != 0 {
+		if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+			return nil, fmt.Errorf("utp: truncated extension %d", ext)
+		}
+		next, data := rest[0], rest[2:2+int(rest[1])]
+		if ext == extSack {
+			p.sack = data
+		}
+		ext, rest = next, rest[2+len(data):]
+	}
+	p.payload = rest
+	return p, nil
+}
+
+// seqLess compares sequence numbers that wrap around at 65536.
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
@@ -0,0 +1,256 @@
+// Package utp implements the Micro Transport Protocol (BEP 29), a reliable,
+// ordered stream over UDP. Its LEDBAT congestion control yields to other
+// traffic by watching queuing delay instead of waiting for losses, and
+// connections are plain net.Conns, so the rest of the client can use them
+// exactly like TCP.
+package utp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errClosed = errors.New("utp: use of closed connection")
+	errReset  = errors.New("utp: connection reset by peer")
+)
+
+// Socket is a UDP socket carrying any number of uTP connections. It is a
+// net.Listener when created with Listen.
+type Socket struct {
+	pc        net.PacketConn
+	accepting bool
+
+	mu     sync.Mutex
+	conns  map[connKey]*Conn
+	err    error // set once the read loop has stopped
+	accept chan *Conn
+	done   chan struct{}
+}
+
+// connKey identifies a connection: the same connection id may be used by
+// different remote hosts.
+type connKey struct {
+	addr string
+	id   uint16 // our recv_id, the connection_id the peer sends with
+}
+
+// Listen opens a Socket on a local UDP address that accepts incoming
+// connections as well as dialling out.
+func Listen(network, address string) (*Socket, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return newSocket(pc, true), nil
+}
+
+func newSocket(pc net.PacketConn, accepting bool) *Socket {
+	s := &Socket{
+		pc:        pc,
+		accepting: accepting,
+		conns:     map[connKey]*Conn{},
+		accept:    make(chan *Conn, 16),
+		done:      make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultSocket *Socket
+	defaultErr    error
+)
+
+// DialTimeout connects to address over uTP from a shared socket on an
+// ephemeral port, which only dials out.
+func DialTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	defaultOnce.Do(func() {
+		var pc net.PacketConn
+		pc, defaultErr = net.ListenPacket("udp", ":0")
+		if defaultErr == nil {
+			defaultSocket = newSocket(pc, false)
+		}
+	})
+	if defaultErr != nil {
+		return nil, defaultErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return defaultSocket.DialContext(ctx, address)
+}
+
+// DialContext connects to a uTP peer at address. The SYN is retransmitted
+// until the peer answers or ctx is done.
+func (s *Socket) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	var c *Conn
+	for {
+		// The initiator picks recv_id and sends with recv_id+1.
+		id := randomUint16()
+		key := connKey{raddr.String(), id}
+		if s.conns[key] == nil {
+			c = newConn(s, raddr, key, id+1)
+			c.seq = 2 // the SYN is 1
+			s.conns[key] = c
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	// The SYN goes out with connection_id = recv_id, unlike every other packet.
+	syn := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.sendPacket(&packet{header: header{typ: stSyn, connID: c.key.id, seq: 1}})
+	}
+	syn()
+	retry := time.NewTicker(time.Second)
+	defer retry.Stop()
+	for {
+		select {
+		case <-c.established:
+			go c.timerLoop()
+			return c, nil
+		case <-retry.C:
+			syn()
+		case <-ctx.Done():
+			s.remove(c.key)
+			return nil, &net.OpError{Op: "dial", Net: "utp", Addr: raddr, Err: ctx.Err()}
+		case <-s.done:
+			return nil, s.closedErr()
+		}
+	}
+}
+
+// Accept waits for the next incoming connection.
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.accept:
+		return c, nil
+	case <-s.done:
+		return nil, s.closedErr()
+	}
+}
+
+// Addr is the local UDP address.
+func (s *Socket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+// Close shuts the socket and every connection on it.
+func (s *Socket) Close() error {
+	return s.pc.Close()
+}
+
+func (s *Socket) closedErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Socket) remove(key connKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, key)
+}
+
+func (s *Socket) readLoop() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			s.err = errClosed
+			conns := s.conns
+			s.conns = nil
+			s.mu.Unlock()
+			close(s.done)
+			for _, c := range conns {
+				c.fail(errClosed)
+			}
+			return
+		}
+		p, err := parsePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		// The payload aliases buf, which the next read reuses.
+		p.payload = append([]byte(nil), p.payload...)
+		p.sack = append([]byte(nil), p.sack...)
+		s.dispatch(p, addr)
+	}
+}
+
+func (s *Socket) dispatch(p *packet, addr net.Addr) {
+	key := connKey{addr.String(), p.connID}
+	if p.typ == stSyn {
+		// The SYN carries the initiator's recv_id; we receive on recv_id+1.
+		key.id++
+	}
+	s.mu.Lock()
+	c := s.conns[key]
+	if c == nil && p.typ == stReset {
+		// Resets may carry either of our ids: the one we receive with or
+		// the one we send with, which is ours plus or minus one.
+		for _, id := range []uint16{p.connID - 1, p.connID + 1} {
+			if rc := s.conns[connKey{key.addr, id}]; rc != nil && rc.sendID == p.connID {
+				c = rc
+			}
+		}
+	}
+	if c == nil && p.typ == stSyn && s.accepting {
+		c = newConn(s, addr, key, p.connID)
+		c.seq = randomUint16()
+		c.ack = p.seq
+		c.setEstablished()
+		select {
+		case s.accept <- c:
+			s.conns[key] = c
+			go c.timerLoop()
+		default:
+			c = nil // backlog full; the peer will retry the SYN
+		}
+	}
+	s.mu.Unlock()
+
+	if c == nil {
+		if p.typ != stReset {
+			s.reset(p, addr)
+		}
+		return
+	}
+	c.receive(p)
+}
+
+// reset answers a packet for an unknown connection.
+func (s *Socket) reset(p *packet, addr net.Addr) {
+	r := &packet{header: header{typ: stReset, connID: p.connID, seq: randomUint16(), ack: p.seq, ts: now()}}
+	s.pc.WriteTo(r.marshal(), addr)
+}
+
+func randomUint16() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// now is the current time in microseconds, as it goes in packet timestamps.
+func now() uint32 {
+	return uint32(time.Now().UnixMicro())
+}